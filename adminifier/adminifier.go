@@ -80,6 +80,11 @@ func Configure() {
 		mux.HandleFunc(host+root+name, function)
 	}
 
+	// server panel handlers
+	for name, function := range serverHandlers {
+		mux.HandleFunc(host+root+name, function)
+	}
+
 	// handlers for each site at shortcode/
 	for shortcode, wi := range webserver.Wikis {
 		setupWikiHandlers(shortcode, wi)
@@ -2,6 +2,7 @@ package adminifier
 
 import (
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/cooper/quiki/authenticator"
@@ -10,8 +11,19 @@ import (
 
 // handlers that call functions
 var funcHandlers = map[string]func(w http.ResponseWriter, r *http.Request){
-	"func/login": handleLogin,
-	"logout":     handleLogout,
+	"func/login":           handleLogin,
+	"logout":               handleLogout,
+	"func/create-user":     handleCreateUser,
+	"func/update-user":     handleUpdateUser,
+	"func/delete-user":     handleDeleteUser,
+	"func/change-password": handleChangePassword,
+}
+
+// server panel pages
+var serverHandlers = map[string]func(w http.ResponseWriter, r *http.Request){
+	"users":           handleUsersPage,
+	"edit-user":       handleEditUserPage,
+	"change-password": handleChangePasswordPage,
 }
 
 func handleRoot(w http.ResponseWriter, r *http.Request) {
@@ -28,15 +40,27 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// only list wikis the user has access to
+	user := sessionUser(r)
+	wikis := viewableWikis(user.Username)
+
+	// if user has only one site and no admin privs, go straight to site dashboard
+	if len(wikis) == 1 && !webserver.Auth.Can(user.Username, "", authenticator.ActionServerAdmin) {
+		for shortcode := range wikis {
+			http.Redirect(w, r, root+shortcode+"/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
 	tmpl.ExecuteTemplate(w, "server.tpl", struct {
-		User  *authenticator.User
-		Wikis map[string]*webserver.WikiInfo
+		User        *authenticator.User
+		Wikis       map[string]*webserver.WikiInfo
+		ServerAdmin bool
 	}{
-		User:  sessMgr.Get(r.Context(), "user").(*authenticator.User),
-		Wikis: webserver.Wikis,
+		User:        user,
+		Wikis:       wikis,
+		ServerAdmin: webserver.Auth.Can(user.Username, "", authenticator.ActionServerAdmin),
 	})
-	// TODO: if user has only one site and no admin privs, go straight to site dashboard
-	// and deny access to the server admin panel
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -51,6 +75,10 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	// attempt login
 	user, err := webserver.Auth.Login(r.Form.Get("username"), r.Form.Get("password"))
+	if err == authenticator.ErrAccountLocked {
+		w.Write([]byte("Account is locked"))
+		return
+	}
 	if err != nil {
 		w.Write([]byte("Bad password"))
 		return
@@ -73,6 +101,228 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 	handleRoot(w, r)
 }
 
+func handleUsersPage(w http.ResponseWriter, r *http.Request) {
+	if !requireServerAdmin(w, r) {
+		return
+	}
+	tmpl.ExecuteTemplate(w, "users.tpl", struct {
+		User  *authenticator.User
+		Users []authenticator.User
+		Roles []authenticator.Role
+	}{
+		User:  sessionUser(r),
+		Users: webserver.Auth.Users(),
+		Roles: allRoles,
+	})
+}
+
+func handleEditUserPage(w http.ResponseWriter, r *http.Request) {
+	if !requireServerAdmin(w, r) {
+		return
+	}
+
+	// find the user
+	user, exist := webserver.Auth.User(r.URL.Query().Get("user"))
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	// role for each wiki
+	type wikiRole struct {
+		Shortcode string
+		Title     string
+		Role      authenticator.Role
+	}
+	var wikis []wikiRole
+	for shortcode, wi := range webserver.Wikis {
+		wikis = append(wikis, wikiRole{shortcode, wi.Title, user.Wikis[shortcode]})
+	}
+	sort.Slice(wikis, func(i, j int) bool {
+		return wikis[i].Shortcode < wikis[j].Shortcode
+	})
+
+	tmpl.ExecuteTemplate(w, "edit-user.tpl", struct {
+		User     *authenticator.User
+		EditUser *authenticator.User
+		Wikis    []wikiRole
+		Roles    []authenticator.Role
+	}{
+		User:     sessionUser(r),
+		EditUser: &user,
+		Wikis:    wikis,
+		Roles:    allRoles,
+	})
+}
+
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if !requireServerAdmin(w, r) || !parsePost(w, r, "username", "password", "role") {
+		return
+	}
+
+	// create the user
+	err := webserver.Auth.NewUser(authenticator.User{
+		Username:    r.Form.Get("username"),
+		DisplayName: r.Form.Get("display"),
+		Email:       r.Form.Get("email"),
+		Role:        authenticator.Role(r.Form.Get("role")),
+	}, r.Form.Get("password"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	http.Redirect(w, r, root+"users", http.StatusSeeOther)
+}
+
+func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if !requireServerAdmin(w, r) || !parsePost(w, r, "username", "role") {
+		return
+	}
+
+	// find the user
+	user, exist := webserver.Auth.User(r.Form.Get("username"))
+	if !exist {
+		http.Error(w, "user does not exist", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// update info
+	user.DisplayName = r.Form.Get("display")
+	user.Email = r.Form.Get("email")
+	user.Role = authenticator.Role(r.Form.Get("role"))
+	user.Locked = r.Form.Get("locked") != ""
+
+	// per-wiki roles
+	user.Wikis = make(map[string]authenticator.Role)
+	for shortcode := range webserver.Wikis {
+		if role := r.Form.Get("wiki." + shortcode); role != "" {
+			user.Wikis[shortcode] = authenticator.Role(role)
+		}
+	}
+
+	// clear temporary lockout
+	if r.Form.Get("unlock") != "" {
+		user.LockedUntil = nil
+		user.FailedLogins = 0
+	}
+
+	if err := webserver.Auth.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// set password if provided
+	if password := r.Form.Get("password"); password != "" {
+		if err := webserver.Auth.SetPassword(user.Username, password); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// refresh own session info
+	if strings.EqualFold(user.Username, sessionUser(r).Username) {
+		sessMgr.Put(r.Context(), "user", &user)
+	}
+
+	http.Redirect(w, r, root+"users", http.StatusSeeOther)
+}
+
+func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !requireServerAdmin(w, r) || !parsePost(w, r, "username") {
+		return
+	}
+
+	// can't delete yourself
+	username := r.Form.Get("username")
+	if strings.EqualFold(username, sessionUser(r).Username) {
+		http.Error(w, "cannot delete your own account", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := webserver.Auth.DeleteUser(username); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	http.Redirect(w, r, root+"users", http.StatusSeeOther)
+}
+
+func handleChangePasswordPage(w http.ResponseWriter, r *http.Request) {
+	if !requireLogin(w, r) {
+		return
+	}
+	tmpl.ExecuteTemplate(w, "change-password.tpl", struct {
+		User    *authenticator.User
+		Changed bool
+	}{
+		User:    sessionUser(r),
+		Changed: r.URL.Query().Get("changed") != "",
+	})
+}
+
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if !requireLogin(w, r) || !parsePost(w, r, "current", "password", "confirm") {
+		return
+	}
+	user := sessionUser(r)
+
+	// passwords don't match
+	password := r.Form.Get("password")
+	if password != r.Form.Get("confirm") {
+		http.Error(w, "passwords do not match", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// verify current password
+	if err := webserver.Auth.CheckPassword(user.Username, r.Form.Get("current")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := webserver.Auth.SetPassword(user.Username, password); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	http.Redirect(w, r, root+"change-password?changed=1", http.StatusSeeOther)
+}
+
+// roles which can be assigned in the user editor
+var allRoles = []authenticator.Role{
+	authenticator.RoleNone,
+	authenticator.RoleViewer,
+	authenticator.RoleEditor,
+	authenticator.RoleAdmin,
+	authenticator.RoleServerAdmin,
+}
+
+// sessionUser returns the logged in user
+func sessionUser(r *http.Request) *authenticator.User {
+	return sessMgr.Get(r.Context(), "user").(*authenticator.User)
+}
+
+// requireLogin redirects to the login page if not logged in
+func requireLogin(w http.ResponseWriter, r *http.Request) bool {
+	if !sessMgr.GetBool(r.Context(), "loggedIn") {
+		http.Redirect(w, r, root+"login", http.StatusTemporaryRedirect)
+		return false
+	}
+	return true
+}
+
+// requireServerAdmin confirms the logged in user is a server administrator
+func requireServerAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !requireLogin(w, r) {
+		return false
+	}
+	if !webserver.Auth.Can(sessionUser(r).Username, "", authenticator.ActionServerAdmin) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 // parsePost confirms POST requests are well-formed and parameters satisfied
 func parsePost(w http.ResponseWriter, r *http.Request, required ...string) bool {
 
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"html/template"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cooper/quiki/authenticator"
 	"github.com/cooper/quiki/webserver"
@@ -30,6 +32,11 @@ var frameHandlers = map[string]func(*wikiRequest){
 	"edit-category": handleEditCategoryFrame,
 	"edit-model":    handleEditModelFrame,
 	"switch-branch": handleSwitchBranchFrame,
+	"page-history":  handlePageHistoryFrame,
+	"page-revision": handlePageRevisionFrame,
+	"page-diff":     handlePageDiffFrame,
+	"upload-images": handleUploadImagesFrame,
+	"maintenance":   handleMaintenanceFrame,
 	"help":          handleHelpFrame,
 	"help/":         handleHelpFrame,
 }
@@ -37,14 +44,78 @@ var frameHandlers = map[string]func(*wikiRequest){
 var wikiFuncHandlers = map[string]func(*wikiRequest){
 	"switch-branch/": handleSwitchBranch,
 	"create-branch":  handleCreateBranch,
+	"merge-branch":   handleMergeBranch,
+	"delete-branch":  handleDeleteBranch,
 	"write-page":     handleWritePage,
+	"page-revisions": handlePageRevisions,
+	"page-backlinks": handlePageBacklinks,
+	"page-diff":      handlePageDiff,
+	"revert-page":    handleRevertPage,
+	"upload-image":   handleUploadImage,
+	"purge-page":     handlePurgePage,
+	"purge-image":    handlePurgeImage,
+	"purge-cache":    handlePurgeCache,
+	"evict-images":   handleEvictImages,
+	"pregenerate":    handlePregenerate,
+	"pregen-status":  handlePregenStatus,
+	"check-links":    handleCheckLinks,
+	"link-status":    handleLinkStatus,
 	"image/":         handleImage,
 }
 
+// maximum number of images uploaded in one request
+const maxUploadFiles = 20
+
+// actions required to access each frame and function. a frame or function
+// not listed here is forbidden
+var frameActions = map[string]authenticator.Action{
+	"dashboard":     authenticator.ActionView,
+	"pages":         authenticator.ActionView,
+	"categories":    authenticator.ActionView,
+	"images":        authenticator.ActionView,
+	"models":        authenticator.ActionView,
+	"settings":      authenticator.ActionAdmin,
+	"edit-page":     authenticator.ActionEdit,
+	"edit-category": authenticator.ActionEdit,
+	"edit-model":    authenticator.ActionEdit,
+	"switch-branch": authenticator.ActionView,
+	"page-history":  authenticator.ActionView,
+	"page-revision": authenticator.ActionView,
+	"page-diff":     authenticator.ActionView,
+	"upload-images": authenticator.ActionEdit,
+	"maintenance":   authenticator.ActionAdmin,
+	"help":          authenticator.ActionView,
+	"help/":         authenticator.ActionView,
+}
+
+var funcActions = map[string]authenticator.Action{
+	"switch-branch/": authenticator.ActionView,
+	"create-branch":  authenticator.ActionEdit,
+	"merge-branch":   authenticator.ActionAdmin,
+	"delete-branch":  authenticator.ActionAdmin,
+	"write-page":     authenticator.ActionEdit,
+	"page-revisions": authenticator.ActionView,
+	"page-backlinks": authenticator.ActionView,
+	"page-diff":      authenticator.ActionView,
+	"revert-page":    authenticator.ActionEdit,
+	"upload-image":   authenticator.ActionEdit,
+	"purge-page":     authenticator.ActionAdmin,
+	"purge-image":    authenticator.ActionAdmin,
+	"purge-cache":    authenticator.ActionAdmin,
+	"evict-images":   authenticator.ActionAdmin,
+	"pregenerate":    authenticator.ActionAdmin,
+	"pregen-status":  authenticator.ActionView,
+	"check-links":    authenticator.ActionAdmin,
+	"link-status":    authenticator.ActionView,
+	"image/":         authenticator.ActionView,
+}
+
 // wikiTemplate members are available to all wiki templates
 type wikiTemplate struct {
 	User              *authenticator.User // user
 	ServerPanelAccess bool                // whether user can access main panel
+	CanEdit           bool                // whether user can edit wiki content
+	CanAdmin          bool                // whether user can change wiki settings
 	Shortcode         string              // wiki shortcode
 	WikiTitle         string              // wiki title
 	Branch            string              // selected branch
@@ -58,6 +129,7 @@ type wikiRequest struct {
 	shortcode string
 	wikiRoot  string
 	wi        *webserver.WikiInfo
+	master    *webserver.WikiInfo // wiki at master, regardless of selected branch
 	w         http.ResponseWriter
 	r         *http.Request
 	tmplName  string
@@ -73,8 +145,6 @@ type editorOpts struct {
 	info   interface{} // PageInfo or ModelInfo
 }
 
-// TODO: verify session on ALL wiki handlers
-
 func setupWikiHandlers(shortcode string, wi *webserver.WikiInfo) {
 
 	// each of these URLs generates wiki.tpl
@@ -102,6 +172,12 @@ func setupWikiHandlers(shortcode string, wi *webserver.WikiInfo) {
 		}
 		tmplName := "frame-" + frameName + ".tpl"
 
+		// check permission
+		if action, ok := frameActions[frameNameFull]; !ok || !userCan(r, shortcode, action) {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+
 		// call func to create template params
 		var dot interface{} = nil
 
@@ -157,7 +233,7 @@ func setupWikiHandlers(shortcode string, wi *webserver.WikiInfo) {
 	// functions
 	funcRoot := root + shortcode + "/func/"
 	for funcName, thisHandler := range wikiFuncHandlers {
-		handler := thisHandler
+		handler, name := thisHandler, funcName
 		mux.HandleFunc(host+funcRoot+funcName, func(w http.ResponseWriter, r *http.Request) {
 
 			// check logged in
@@ -171,6 +247,12 @@ func setupWikiHandlers(shortcode string, wi *webserver.WikiInfo) {
 				return
 			}
 
+			// check permission
+			if action, ok := funcActions[name]; !ok || !userCan(r, shortcode, action) {
+				http.Error(w, "permission denied", http.StatusForbidden)
+				return
+			}
+
 			// create wiki request
 			wr := &wikiRequest{
 				shortcode: shortcode,
@@ -204,6 +286,12 @@ func handleWiki(shortcode string, wi *webserver.WikiInfo, w http.ResponseWriter,
 		return
 	}
 
+	// check permission
+	if !userCan(r, shortcode, authenticator.ActionView) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+
 	// load javascript templates
 	if javascriptTemplates == "" {
 		files, _ := filepath.Glob(dirAdminifier + "/template/js-tmpl/*.tpl")
@@ -248,13 +336,17 @@ func handleDashboardFrame(wr *wikiRequest) {
 	}
 
 	wr.dot = struct {
-		Logs     string
-		Errors   []wikifier.PageInfo
-		Warnings []wikifier.PageInfo
+		Logs      string
+		Errors    []wikifier.PageInfo
+		Warnings  []wikifier.PageInfo
+		LinkCheck wiki.LinkCheckReport
+		Pregen    wiki.PregenerateProgress
 	}{
-		Logs:     string(logs),
-		Errors:   errors,
-		Warnings: warnings,
+		Logs:      string(logs),
+		Errors:    errors,
+		Warnings:  warnings,
+		LinkCheck: wr.master.LinkCheckReport(),
+		Pregen:    wr.master.PregenerateProgress(),
 	}
 }
 
@@ -280,6 +372,18 @@ func getSortFunc(wr *wikiRequest) (bool, wiki.SortFunc) {
 }
 
 func handlePagesFrame(wr *wikiRequest) {
+
+	// full-text search
+	if q := wr.r.URL.Query().Get("q"); q != "" {
+		results, err := wr.wi.Search(q, wiki.SearchOpts{Drafts: true})
+		if err != nil {
+			wr.err = err
+			return
+		}
+		handleFileFrames(wr, results)
+		return
+	}
+
 	descending, sortFunc := getSortFunc(wr)
 	pages := wr.wi.PagesSorted(descending, sortFunc, wiki.SortTitle)
 	handleFileFrames(wr, pages)
@@ -470,16 +574,40 @@ func handleEditor(wr *wikiRequest, path, file, title string, o editorOpts) {
 }
 
 func handleSwitchBranchFrame(wr *wikiRequest) {
-	branches, err := wr.wi.BranchNames()
+	branches, err := wr.master.BranchNames()
 	if err != nil {
 		wr.err = err
 		return
 	}
+
+	// compare a branch with master
+	var changes []wiki.BranchChange
+	conflicts := false
+	compare := wr.r.URL.Query().Get("compare")
+	if compare != "" {
+		changes, err = wr.master.CompareBranch(compare)
+		if err != nil {
+			wr.err = err
+			return
+		}
+		for _, c := range changes {
+			if c.Conflict != "" {
+				conflicts = true
+			}
+		}
+	}
+
 	wr.dot = struct {
-		Branches []string
+		Branches  []string
+		Compare   string              // branch being compared, if any
+		Changes   []wiki.BranchChange // files changed on the compared branch
+		Conflicts bool                // true if any changes conflict with master
 		wikiTemplate
 	}{
 		Branches:     branches,
+		Compare:      compare,
+		Changes:      changes,
+		Conflicts:    conflicts,
 		wikiTemplate: getGenericTemplate(wr),
 	}
 }
@@ -539,6 +667,50 @@ func handleCreateBranch(wr *wikiRequest) {
 	http.Redirect(wr.w, wr.r, wr.wikiRoot+"/dashboard", http.StatusTemporaryRedirect)
 }
 
+func handleMergeBranch(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "branch") {
+		return
+	}
+
+	// merge into master
+	branchName := wr.r.Form.Get("branch")
+	err := wr.master.MergeBranch(branchName, getCommitOpts(wr, wr.r.Form.Get("message")))
+
+	// conflicts are displayed in the comparison
+	if _, ok := err.(*wiki.MergeConflictError); ok {
+		http.Redirect(wr.w, wr.r, wr.wikiRoot+"/switch-branch?compare="+branchName, http.StatusTemporaryRedirect)
+		return
+	}
+	if err != nil {
+		wr.err = err
+		return
+	}
+
+	// redirect back to branches
+	http.Redirect(wr.w, wr.r, wr.wikiRoot+"/switch-branch", http.StatusTemporaryRedirect)
+}
+
+func handleDeleteBranch(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "branch") {
+		return
+	}
+
+	// delete it
+	branchName := wr.r.Form.Get("branch")
+	if err := wr.master.DeleteBranch(branchName); err != nil {
+		wr.err = err
+		return
+	}
+
+	// if the deleted branch was selected, go back to master
+	if sessMgr.GetString(wr.r.Context(), "branch") == branchName {
+		sessMgr.Put(wr.r.Context(), "branch", "master")
+	}
+
+	// redirect back to branches
+	http.Redirect(wr.w, wr.r, wr.wikiRoot+"/switch-branch", http.StatusTemporaryRedirect)
+}
+
 func handleHelpFrame(wr *wikiRequest) {
 
 	var dot struct {
@@ -560,7 +732,7 @@ func handleHelpFrame(wr *wikiRequest) {
 	// determine page
 	helpPage := strings.TrimPrefix(strings.TrimPrefix(wr.r.URL.Path, wr.wikiRoot+"/frame/help"), "/")
 	if helpPage == "" {
-		helpPage = helpWiki.Opt.MainPage
+		helpPage = helpWiki.Opt().MainPage
 	}
 
 	// display the page
@@ -584,19 +756,458 @@ func handleHelpFrame(wr *wikiRequest) {
 	}
 }
 
+// writes a page, or a model with ?model, or the configuration with ?config
 func handleWritePage(wr *wikiRequest) {
 	if !parsePost(wr.w, wr.r, "page", "content") {
 		return
 	}
 
-	// TODO: double check the path is OK
 	pageName, content, message := wr.r.Form.Get("page"), wr.r.Form.Get("content"), wr.r.Form.Get("message")
+	commit := getCommitOpts(wr, message)
+	query := wr.r.URL.Query()
 
 	// write the file & commit
-	if err := wr.wi.WriteFile(filepath.Join("pages", pageName), []byte(content), true, getCommitOpts(wr, message)); err != nil {
+	var err error
+	switch {
+	case query["config"] != nil:
+		if !userCan(wr.r, wr.shortcode, authenticator.ActionAdmin) {
+			http.Error(wr.w, "permission denied", http.StatusForbidden)
+			return
+		}
+		err = wr.wi.WriteConfig([]byte(content), commit)
+	case query["model"] != nil:
+		err = wr.wi.WriteModel(pageName, []byte(content), true, commit)
+	default:
+		err = wr.wi.WritePage(pageName, []byte(content), true, commit)
+	}
+	if err != nil {
+		wr.err = err
+		return
+	}
+}
+
+func handlePageHistoryFrame(wr *wikiRequest) {
+	name := wr.r.URL.Query().Get("page")
+	if name == "" {
+		wr.err = errors.New("no page filename provided")
+		return
+	}
+
+	// find the page. if File is empty, it doesn't exist
+	info := wr.wi.PageInfo(name)
+	if info.File == "" {
+		wr.err = errors.New("page does not exist")
+		return
+	}
+
+	revs, err := wr.wi.PageHistory(name)
+	if err != nil {
+		wr.err = err
+		return
+	}
+
+	wr.dot = struct {
+		File      string
+		Title     string
+		Revisions []wiki.RevisionInfo
+		wikiTemplate
+	}{
+		File:         info.File,
+		Title:        info.Title,
+		Revisions:    revs,
+		wikiTemplate: getGenericTemplate(wr),
+	}
+}
+
+func handlePageRevisionFrame(wr *wikiRequest) {
+	q := wr.r.URL.Query()
+	name, rev := q.Get("page"), q.Get("rev")
+	if name == "" || rev == "" {
+		wr.err = errors.New("no page filename or revision provided")
+		return
+	}
+
+	var dot struct {
+		File     string
+		Rev      string
+		Title    string
+		Source   string
+		Content  template.HTML
+		CSS      template.CSS
+		IsSource bool // true if showing source rather than content
+		wikiTemplate
+	}
+	dot.File = name
+	dot.Rev = rev
+	dot.Title = name
+	dot.wikiTemplate = getGenericTemplate(wr)
+	wr.dot = &dot
+
+	// show source code
+	if _, ok := q["source"]; ok {
+		dot.IsSource = true
+		dot.Source, wr.err = wr.wi.PageSourceAtRevision(name, rev)
+		return
+	}
+
+	// display the page
+	switch res := wr.wi.PageAtRevision(name, rev).(type) {
+
+	// page content
+	case wiki.DisplayPage:
+		dot.Title = res.Title
+		dot.Content = template.HTML(res.Content)
+		dot.CSS = template.CSS(res.CSS)
+
+	// error
+	case wiki.DisplayError:
+		wr.err = errors.New(res.Error)
+
+	// something else
+	default:
+		wr.err = errors.New("unknown response")
+	}
+}
+
+func handlePageDiffFrame(wr *wikiRequest) {
+	q := wr.r.URL.Query()
+	name := q.Get("page")
+	if name == "" {
+		wr.err = errors.New("no page filename provided")
+		return
+	}
+
+	// compare revisions. if to is empty, compare to current
+	diff, err := wr.wi.Diff(name, q.Get("from"), q.Get("to"))
+	if err != nil {
+		wr.err = err
+		return
+	}
+
+	wr.dot = struct {
+		File string
+		*wiki.Diff
+		wikiTemplate
+	}{
+		File:         name,
+		Diff:         diff,
+		wikiTemplate: getGenericTemplate(wr),
+	}
+}
+
+func handlePageRevisions(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "page") {
+		return
+	}
+
+	// only pages have history here
+	if _, ok := wr.r.URL.Query()["model"]; ok {
+		writeJSONError(wr, "Revision history is only available for pages")
+		return
+	}
+
+	revs, err := wr.wi.PageHistory(wr.r.Form.Get("page"))
+	if err != nil {
+		writeJSONError(wr, err.Error())
+		return
+	}
+
+	writeJSON(wr, map[string]interface{}{
+		"success": true,
+		"revs":    revs,
+	})
+}
+
+func handlePageBacklinks(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "page") {
+		return
+	}
+	writeJSON(wr, map[string]interface{}{
+		"success":   true,
+		"backlinks": wr.wi.Backlinks(wr.r.Form.Get("page")),
+	})
+}
+
+func handlePageDiff(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "page", "from") {
+		return
+	}
+
+	// only pages have history here
+	if _, ok := wr.r.URL.Query()["model"]; ok {
+		writeJSONError(wr, "Revision history is only available for pages")
+		return
+	}
+
+	// null or missing to means compare to current
+	to := wr.r.Form.Get("to")
+	if to == "null" {
+		to = ""
+	}
+
+	diff, err := wr.wi.Diff(wr.r.Form.Get("page"), wr.r.Form.Get("from"), to)
+	if err != nil {
+		writeJSONError(wr, err.Error())
+		return
+	}
+
+	// diff is omitted if there are no changes
+	res := map[string]interface{}{"success": true}
+	if unified := diff.Unified(); unified != "" {
+		res["diff"] = unified
+	}
+	writeJSON(wr, res)
+}
+
+func handleRevertPage(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "page", "rev") {
+		return
+	}
+
+	// restore the old content & commit
+	pageName, rev, message := wr.r.Form.Get("page"), wr.r.Form.Get("rev"), wr.r.Form.Get("message")
+	if err := wr.wi.RevertPage(pageName, rev, getCommitOpts(wr, message)); err != nil {
+		writeJSONError(wr, err.Error())
+		return
+	}
+
+	writeJSON(wr, map[string]interface{}{"success": true})
+}
+
+func handleUploadImagesFrame(wr *wikiRequest) {
+	wr.dot = struct {
+		MaxSize      int // maximum upload size in MB, or 0
+		MaxDimension int // images larger than this are downsized, or 0
+		wikiTemplate
+	}{
+		MaxSize:      wr.wi.Opt().Image.UploadMaxSize,
+		MaxDimension: wr.wi.Opt().Image.UploadMaxDimension,
+		wikiTemplate: getGenericTemplate(wr),
+	}
+}
+
+func handleUploadImage(wr *wikiRequest) {
+	if wr.r.Method != http.MethodPost {
+		http.Error(wr.w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// limit the request to the number of files times the max size.
+	// individual files are checked by UploadImage
+	if max := wr.wi.Opt().Image.UploadMaxSize; max != 0 {
+		wr.r.Body = http.MaxBytesReader(wr.w, wr.r.Body, int64(max+1)*maxUploadFiles<<20)
+	}
+	if err := wr.r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(wr, "Upload failed: "+err.Error())
+		return
+	}
+	defer wr.r.MultipartForm.RemoveAll()
+
+	files := wr.r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		writeJSONError(wr, "No images selected")
+		return
+	}
+	if len(files) > maxUploadFiles {
+		writeJSONError(wr, "Too many images; upload at most "+strconv.Itoa(maxUploadFiles)+" at once")
+		return
+	}
+
+	// upload each image, reporting errors individually
+	type uploadResult struct {
+		Name  string `json:"name"`            // original filename
+		Error string `json:"error,omitempty"` // error, if upload failed
+		*wiki.ImageUpload
+	}
+	results := make([]uploadResult, len(files))
+	success := true
+	for i, fh := range files {
+		results[i].Name = fh.Filename
+		upload, err := uploadImageFile(wr, fh)
+		if err != nil {
+			results[i].Error = err.Error()
+			success = false
+			continue
+		}
+		results[i].ImageUpload = upload
+	}
+
+	writeJSON(wr, map[string]interface{}{
+		"success": success,
+		"images":  results,
+	})
+}
+
+// reads an uploaded file and stores it as an image
+func uploadImageFile(wr *wikiRequest, fh *multipart.FileHeader) (*wiki.ImageUpload, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// default commit message
+	message := wr.r.FormValue("message")
+	if message == "" {
+		message = "Upload " + fh.Filename
+	}
+
+	return wr.wi.UploadImage(fh.Filename, content, getCommitOpts(wr, message))
+}
+
+func handleMaintenanceFrame(wr *wikiRequest) {
+	stats := wr.wi.CacheStats()
+	type cacheRow struct {
+		Name  string
+		Files int
+		Size  string
+	}
+	row := func(name string, usage wiki.CacheUsage) cacheRow {
+		return cacheRow{name, usage.Files, humanBytes(usage.Bytes)}
+	}
+	type memoryRow struct {
+		Pages     int
+		Size, Max string
+		Hits      int64
+		Misses    int64
+	}
+	wr.dot = struct {
+		Cache  []cacheRow
+		Memory memoryRow
+		Result string // result of the last maintenance action, if any
+		wikiTemplate
+	}{
+		Cache: []cacheRow{
+			row("Pages", stats.Pages),
+			row("Images", stats.Images),
+			row("Unused images", stats.UnusedImages),
+			row("Categories", stats.Categories),
+		},
+		Memory: memoryRow{
+			stats.Memory.Pages,
+			humanBytes(stats.Memory.Bytes),
+			humanBytes(stats.Memory.MaxBytes),
+			stats.Memory.Hits,
+			stats.Memory.Misses,
+		},
+		Result:       sessMgr.PopString(wr.r.Context(), "maintenanceResult"),
+		wikiTemplate: getGenericTemplate(wr),
+	}
+}
+
+func handlePurgePage(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "page") {
+		return
+	}
+	name := wr.r.Form.Get("page")
+	if wr.wi.PageInfo(name).File == "" {
+		wr.err = errors.New("page does not exist")
+		return
+	}
+	wr.wi.PurgePage(name)
+	finishMaintenance(wr, "Purged page "+name+".")
+}
+
+func handlePurgeImage(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r, "image") {
+		return
+	}
+	name := wr.r.Form.Get("image")
+	if wr.wi.ImageInfo(name).File == "" {
+		wr.err = errors.New("image does not exist")
+		return
+	}
+	wr.wi.PurgeImage(name)
+	finishMaintenance(wr, "Purged generated versions of "+name+".")
+}
+
+func handlePurgeCache(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r) {
+		return
+	}
+	if err := wr.wi.PurgeAll(); err != nil {
 		wr.err = err
 		return
 	}
+	finishMaintenance(wr, "Purged all generated pages and images.")
+}
+
+func handleEvictImages(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r) {
+		return
+	}
+
+	// both are optional; if neither is given, all unused images are evicted
+	var opts wiki.EvictOpts
+	if days, err := strconv.Atoi(wr.r.Form.Get("days")); err == nil && days > 0 {
+		opts.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+	if mb, err := strconv.Atoi(wr.r.Form.Get("mb")); err == nil && mb > 0 {
+		opts.MaxBytes = int64(mb) << 20
+	}
+
+	evicted := wr.wi.EvictImages(opts)
+	finishMaintenance(wr, "Deleted "+strconv.Itoa(evicted.Files)+" unused images ("+humanBytes(evicted.Bytes)+").")
+}
+
+func handlePregenerate(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r) {
+		return
+	}
+	wr.master.StartPregenerate()
+	finishMaintenance(wr, "Started pregenerating pages. Progress is shown on the dashboard.")
+}
+
+func handlePregenStatus(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r) {
+		return
+	}
+	writeJSON(wr, map[string]interface{}{
+		"success":  true,
+		"progress": wr.master.PregenerateProgress(),
+	})
+}
+
+func handleCheckLinks(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r) {
+		return
+	}
+	wr.master.StartLinkCheck(wr.r.Form.Get("external") != "")
+	finishMaintenance(wr, "Started checking for broken links. Results are shown on the dashboard.")
+}
+
+func handleLinkStatus(wr *wikiRequest) {
+	if !parsePost(wr.w, wr.r) {
+		return
+	}
+	writeJSON(wr, map[string]interface{}{
+		"success": true,
+		"report":  wr.master.LinkCheckReport(),
+	})
+}
+
+// redirects back to the maintenance frame with a message
+func finishMaintenance(wr *wikiRequest, result string) {
+	sessMgr.Put(wr.r.Context(), "maintenanceResult", result)
+	http.Redirect(wr.w, wr.r, wr.wikiRoot+"/maintenance", http.StatusSeeOther)
+}
+
+// formats a number of bytes for display
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGT"[exp]) + "B"
 }
 
 func handleImage(wr *wikiRequest) {
@@ -643,12 +1254,38 @@ func switchUserWiki(wr *wikiRequest, wi *webserver.WikiInfo) {
 		userWiki = wi.Copy(branchWiki)
 	}
 	wr.wi = userWiki
+	wr.master = wi
+}
+
+// userCan returns whether the logged in user may perform an action on a wiki
+func userCan(r *http.Request, shortcode string, action authenticator.Action) bool {
+	user := sessionUser(r)
+	return webserver.Auth.Can(user.Username, shortcode, action)
+}
+
+// viewableWikis returns the wikis which the user can access
+func viewableWikis(username string) map[string]*webserver.WikiInfo {
+	wikis := make(map[string]*webserver.WikiInfo)
+	for shortcode, wi := range webserver.Wikis {
+		if webserver.Auth.Can(username, shortcode, authenticator.ActionView) {
+			wikis[shortcode] = wi
+		}
+	}
+	return wikis
 }
 
 func getGenericTemplate(wr *wikiRequest) wikiTemplate {
+	user := sessMgr.Get(wr.r.Context(), "user").(*authenticator.User)
+	auth := webserver.Auth
 	return wikiTemplate{
-		User:              sessMgr.Get(wr.r.Context(), "user").(*authenticator.User),
-		ServerPanelAccess: true, // TODO
+		User: user,
+
+		// server admins and users with more than one wiki can see the sites list
+		ServerPanelAccess: auth.Can(user.Username, "", authenticator.ActionServerAdmin) ||
+			len(viewableWikis(user.Username)) > 1,
+		CanEdit:  auth.Can(user.Username, wr.shortcode, authenticator.ActionEdit),
+		CanAdmin: auth.Can(user.Username, wr.shortcode, authenticator.ActionAdmin),
+
 		Branch:            sessMgr.GetString(wr.r.Context(), "branch"),
 		Shortcode:         wr.shortcode,
 		WikiTitle:         wr.wi.Title,
@@ -659,6 +1296,25 @@ func getGenericTemplate(wr *wikiRequest) wikiTemplate {
 	}
 }
 
+// writeJSON responds to a func/ request with JSON
+func writeJSON(wr *wikiRequest, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		wr.err = err
+		return
+	}
+	wr.w.Header().Set("Content-Type", "application/json")
+	wr.w.Write(data)
+}
+
+// writeJSONError responds to a func/ request with a JSON error
+func writeJSONError(wr *wikiRequest, msg string) {
+	writeJSON(wr, map[string]interface{}{
+		"success": false,
+		"error":   msg,
+	})
+}
+
 func getCommitOpts(wr *wikiRequest, comment string) wiki.CommitOpts {
 	user := sessMgr.Get(wr.r.Context(), "user").(*authenticator.User)
 	return wiki.CommitOpts{
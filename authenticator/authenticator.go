@@ -6,27 +6,52 @@ import (
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 )
 
 // Authenticator represents a quiki server or site authentication service.
 type Authenticator struct {
-	Users map[string]User `json:"users,omitempty"`
 
-	path string      // path to JSON file
-	mu   *sync.Mutex // data lock
+	// MaxLoginAttempts is the number of consecutive failed logins after
+	// which an account is temporarily locked. If zero, accounts are never
+	// locked automatically.
+	MaxLoginAttempts int
+
+	// LockoutDuration is how long an account remains locked after too many
+	// failed logins.
+	LockoutDuration time.Duration
+
+	users map[string]User // users by lowercase username
+	path  string          // path to JSON file
+	mu    *sync.Mutex     // data lock
+}
+
+// version of the data file format. files without a version predate roles
+const authDataVersion = 1
+
+// authData is the JSON representation of an Authenticator
+type authData struct {
+	Version int             `json:"version,omitempty"`
+	Users   map[string]User `json:"users,omitempty"`
 }
 
 // Open reads a user data file and returns an Authenticator for it.
 // If the path does not exist, a new data file is created.
 func Open(path string) (*Authenticator, error) {
-	auth := &Authenticator{path: path, mu: new(sync.Mutex)}
+	auth := &Authenticator{
+		MaxLoginAttempts: 5,
+		LockoutDuration:  15 * time.Minute,
+		path:             path,
+		mu:               new(sync.Mutex),
+	}
 
 	// attempt to read the file
 	jsonData, err := ioutil.ReadFile(path)
 
 	// it exists; try to unmarshal it
 	if err == nil {
-		err = json.Unmarshal(jsonData, auth)
+		var data authData
+		err = json.Unmarshal(jsonData, &data)
 
 		// JSON data is no good?
 		// I mean, we can't just purge it because the data would be lost.
@@ -34,6 +59,13 @@ func Open(path string) (*Authenticator, error) {
 		if err != nil {
 			return nil, err
 		}
+		auth.users = data.Users
+
+		// users from before roles existed had full access
+		if data.Version == 0 {
+			auth.upgradeRoles()
+			return auth, auth.write()
+		}
 
 		// all good
 		return auth, nil
@@ -52,9 +84,14 @@ func Open(path string) (*Authenticator, error) {
 func (auth *Authenticator) write() error {
 	auth.mu.Lock()
 	defer auth.mu.Unlock()
+	return auth._write()
+}
+
+// like write, except the caller must hold the lock
+func (auth *Authenticator) _write() error {
 
 	// encode as JSON
-	jsonData, err := json.Marshal(auth)
+	jsonData, err := json.Marshal(authData{Version: authDataVersion, Users: auth.users})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,116 @@
+package authenticator
+
+// Role describes a user's level of access.
+type Role string
+
+const (
+	// RoleNone means the user has no access.
+	RoleNone Role = ""
+
+	// RoleViewer can browse a wiki in the admin panel without making changes.
+	RoleViewer Role = "viewer"
+
+	// RoleEditor can also edit pages, models, images, and categories,
+	// and can work in branches.
+	RoleEditor Role = "editor"
+
+	// RoleAdmin can also change wiki settings and merge or delete branches.
+	RoleAdmin Role = "admin"
+
+	// RoleServerAdmin has full access to every wiki and the server.
+	RoleServerAdmin Role = "server-admin"
+)
+
+// Action is something a user may be permitted to do.
+type Action string
+
+const (
+	// ActionView is viewing a wiki in the admin panel.
+	ActionView Action = "view"
+
+	// ActionEdit is changing wiki content.
+	ActionEdit Action = "edit"
+
+	// ActionAdmin is changing wiki settings and managing branches.
+	ActionAdmin Action = "admin"
+
+	// ActionServerAdmin is accessing the server panel and managing users.
+	ActionServerAdmin Action = "server-admin"
+)
+
+// access levels, where each includes the ones below it
+var roleLevels = map[Role]int{
+	RoleViewer:      1,
+	RoleEditor:      2,
+	RoleAdmin:       3,
+	RoleServerAdmin: 4,
+}
+
+var actionLevels = map[Action]int{
+	ActionView:        1,
+	ActionEdit:        2,
+	ActionAdmin:       3,
+	ActionServerAdmin: 4,
+}
+
+// ValidRole returns whether a role is one of the known roles.
+func ValidRole(role Role) bool {
+	_, ok := roleLevels[role]
+	return ok || role == RoleNone
+}
+
+// RoleFor returns the user's role on a wiki.
+//
+// This is the higher of the user's server-wide role and the role granted
+// for the wiki specifically.
+//
+func (user *User) RoleFor(wiki string) Role {
+	role := user.Role
+	if wikiRole := user.Wikis[wiki]; roleLevels[wikiRole] > roleLevels[role] {
+		role = wikiRole
+	}
+	return role
+}
+
+// Can returns whether the user is permitted to perform an action on a wiki.
+//
+// For ActionServerAdmin, the wiki is ignored.
+//
+func (user *User) Can(wiki string, action Action) bool {
+	need, ok := actionLevels[action]
+	if !ok {
+		return false
+	}
+
+	// server-wide actions only depend on the server role
+	if action == ActionServerAdmin {
+		return user.Role == RoleServerAdmin
+	}
+
+	return roleLevels[user.RoleFor(wiki)] >= need
+}
+
+// Can returns whether the user by the given username is permitted to perform
+// an action on a wiki.
+//
+// Since this looks up the user each time, changes to roles take effect
+// immediately, even for users who are already logged in.
+//
+func (auth *Authenticator) Can(username, wiki string, action Action) bool {
+	user, exist := auth.User(username)
+	if !exist || user.IsLocked() {
+		return false
+	}
+	return user.Can(wiki, action)
+}
+
+// grant full access to users in data files predating roles, since those
+// users already had unrestricted access. this is only done for data files
+// without a version, never based on the roles themselves, since RoleNone
+// may be assigned on purpose
+func (auth *Authenticator) upgradeRoles() {
+	for name, user := range auth.users {
+		user.Role = RoleServerAdmin
+		auth.users[name] = user
+	}
+}
@@ -1,70 +1,247 @@
 package authenticator
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User represents a user.
 type User struct {
-	Username    string `json:"u"`
-	DisplayName string `json:"d"`
-	Email       string `json:"e"`
-	Password    []byte `json:"p"`
+	Username     string          `json:"u"`
+	DisplayName  string          `json:"d"`
+	Email        string          `json:"e"`
+	Password     []byte          `json:"p"`
+	Role         Role            `json:"r,omitempty"`  // role on all wikis
+	Wikis        map[string]Role `json:"w,omitempty"`  // roles on specific wikis by shortcode
+	Locked       bool            `json:"l,omitempty"`  // true if the account is disabled
+	LockedUntil  *time.Time      `json:"t,omitempty"`  // time until which login is refused after failures
+	FailedLogins int             `json:"f,omitempty"`  // consecutive failed login attempts
+	LastLogin    *time.Time      `json:"ll,omitempty"` // time of the last successful login
 }
 
+// errors returned by Login
+var (
+	ErrUserNotExist  = errors.New("user does not exist")
+	ErrBadPassword   = errors.New("bad password")
+	ErrAccountLocked = errors.New("account is locked")
+)
+
 // NewUser registers a new user with the given information.
 //
 // The Password field of the struct should be left empty and
 // the plain-text password passed to the function.
 //
 func (auth *Authenticator) NewUser(user User, password string) error {
-	// consider: is it possible 2 users could be created with the same username
-	// at the same time?
-	lcun := strings.ToLower(user.Username)
 
-	// user already exists!!
-	if _, exist := auth.Users[lcun]; exist {
-		return errors.New("user exists")
+	// unknown role
+	if err := validateUser(user); err != nil {
+		return err
 	}
 
-	// hash password
+	// hash password before locking, since it is slow
 	var err error
-	user.Password, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	user.Password, err = hashPassword(password)
 	if err != nil {
 		return err
 	}
 
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	lcun := strings.ToLower(user.Username)
+
+	// user already exists!!
+	if _, exist := auth.users[lcun]; exist {
+		return errors.New("user exists")
+	}
+
 	// store the user
-	if auth.Users == nil {
-		auth.Users = make(map[string]User)
+	if auth.users == nil {
+		auth.users = make(map[string]User)
 	}
-	auth.Users[lcun] = user
+	auth.users[lcun] = user
 
 	// write to file
-	return auth.write()
+	return auth._write()
 }
 
 // Login attempts a user login, returning the user on success.
 //
+// If the account is locked, ErrAccountLocked is returned. After
+// MaxLoginAttempts consecutive failures, the account is locked for
+// LockoutDuration.
+//
 func (auth *Authenticator) Login(username, password string) (User, error) {
+	return auth.checkPassword(username, password, true)
+}
+
+// CheckPassword returns nil if the password is correct for a user.
+//
+// It is meant for confirming the password of a user who is already logged
+// in. Failures count toward locking the account just like with Login, but
+// successes are not recorded as logins.
+//
+func (auth *Authenticator) CheckPassword(username, password string) error {
+	_, err := auth.checkPassword(username, password, false)
+	return err
+}
+
+// checkPassword implements Login and CheckPassword. the data lock is not
+// held while comparing the password, since that is slow on purpose
+func (auth *Authenticator) checkPassword(username, password string, login bool) (User, error) {
 	lcun := strings.ToLower(username)
 
 	// user does not exist
-	user, exist := auth.Users[lcun]
+	auth.mu.Lock()
+	user, exist := auth.users[lcun]
+	auth.mu.Unlock()
 	if !exist {
-		return user, errors.New("user does not exist")
+		return user, ErrUserNotExist
+	}
+
+	// account is locked
+	if user.IsLocked() {
+		return user, ErrAccountLocked
+	}
+
+	hash := user.Password
+	bad := bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil
+
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	// the user was deleted, or the password changed in the meantime
+	user, exist = auth.users[lcun]
+	if !exist {
+		return user, ErrUserNotExist
+	}
+	if !bytes.Equal(user.Password, hash) {
+		return user, ErrBadPassword
 	}
 
 	// bad password
-	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(password)); err != nil {
-		return user, errors.New("bad password")
+	now := time.Now()
+	if bad {
+		user.FailedLogins++
+
+		// too many failures; lock temporarily
+		if auth.MaxLoginAttempts > 0 && user.FailedLogins >= auth.MaxLoginAttempts {
+			until := now.Add(auth.LockoutDuration)
+			user.LockedUntil = &until
+			user.FailedLogins = 0
+		}
+
+		auth.users[lcun] = user
+		auth._write()
+		return user, ErrBadPassword
+	}
+
+	// success resets failures
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if login {
+		user.LastLogin = &now
+	}
+	auth.users[lcun] = user
+
+	return user, auth._write()
+}
+
+// User returns the user by the given username.
+func (auth *Authenticator) User(username string) (User, bool) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	user, exist := auth.users[strings.ToLower(username)]
+	return user, exist
+}
+
+// Users returns all users, sorted by username.
+func (auth *Authenticator) Users() []User {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	users := make([]User, 0, len(auth.users))
+	for _, user := range auth.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Username) < strings.ToLower(users[j].Username)
+	})
+	return users
+}
+
+// UpdateUser replaces the information for an existing user.
+//
+// The user is identified by its Username, which cannot be changed.
+// The Password field is ignored; use SetPassword instead.
+//
+func (auth *Authenticator) UpdateUser(user User) error {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	lcun := strings.ToLower(user.Username)
+
+	existing, exist := auth.users[lcun]
+	if !exist {
+		return ErrUserNotExist
+	}
+	if err := validateUser(user); err != nil {
+		return err
+	}
+
+	user.Password = existing.Password
+	auth.users[lcun] = user
+	return auth._write()
+}
+
+// DeleteUser deletes a user.
+func (auth *Authenticator) DeleteUser(username string) error {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	lcun := strings.ToLower(username)
+
+	if _, exist := auth.users[lcun]; !exist {
+		return ErrUserNotExist
+	}
+
+	delete(auth.users, lcun)
+	return auth._write()
+}
+
+// SetPassword changes a user's password.
+// This also clears any temporary lockout from failed logins.
+func (auth *Authenticator) SetPassword(username, password string) error {
+
+	// hash password before locking, since it is slow
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	lcun := strings.ToLower(username)
+
+	user, exist := auth.users[lcun]
+	if !exist {
+		return ErrUserNotExist
 	}
 
-	return user, nil
+	user.Password = hash
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+
+	auth.users[lcun] = user
+	return auth._write()
+}
+
+// IsLocked returns whether the user is currently unable to log in because
+// the account is disabled or temporarily locked.
+func (user *User) IsLocked() bool {
+	return user.Locked || user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
 }
 
 // GobDecode allows users to be decoded from a session.
@@ -76,3 +253,25 @@ func (user *User) GobDecode(data []byte) error {
 func (user *User) GobEncode() ([]byte, error) {
 	return json.Marshal(user)
 }
+
+func hashPassword(password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("password is empty")
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func validateUser(user User) error {
+	if user.Username == "" {
+		return errors.New("username is empty")
+	}
+	if !ValidRole(user.Role) {
+		return errors.New("invalid role: " + string(user.Role))
+	}
+	for _, role := range user.Wikis {
+		if !ValidRole(role) {
+			return errors.New("invalid role: " + string(role))
+		}
+	}
+	return nil
+}
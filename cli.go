@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cooper/quiki/authenticator"
+	"github.com/cooper/quiki/wiki"
+	"github.com/cooper/quiki/wikifier"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// command is a quiki subcommand
+type command struct {
+	desc string                    // description
+	run  func(args []string) error // runs the command
+}
+
+var commands = map[string]command{
+	"init":        {"create a new wiki", cmdInit},
+	"render":      {"render a page and print its HTML", cmdRender},
+	"pregenerate": {"generate and cache all pages", cmdPregenerate},
+	"check":       {"parse every page and report warnings and errors", cmdCheck},
+	"adduser":     {"create a user", cmdAddUser},
+	"passwd":      {"change a user's password", cmdPasswd},
+	"purge-cache": {"delete cached pages and images", cmdPurgeCache},
+}
+
+// runCommand runs a subcommand with the remaining arguments
+func runCommand(name string, args []string) {
+	if err := commands[name].run(args); err != nil {
+		fmt.Fprintln(os.Stderr, name+": "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// newFlagSet creates a flag set for a subcommand
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s %s\n", filepath.Base(os.Args[0]), name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the flags for a subcommand, exiting with usage if the
+// wrong number of arguments remain
+func parseFlags(fs *flag.FlagSet, args []string, nArg int) {
+	fs.Parse(args)
+	if fs.NArg() != nArg {
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// usage prints usage for the server and all subcommands
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s path/to/quiki.conf\n", name)
+	fmt.Fprintf(os.Stderr, "       %s command [arguments]\n\ncommands:\n", name)
+	names := make([]string, 0, len(commands))
+	for cmdName := range commands {
+		names = append(names, cmdName)
+	}
+	sort.Strings(names)
+	for _, cmdName := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmdName, commands[cmdName].desc)
+	}
+	os.Exit(2)
+}
+
+const initConfig = `/* wiki name, displayed in the <title> tag and a few other places */
+@name: %s;
+
+/* filename of the main page (.page extension is not necessary) */
+@main_page: main;
+
+/* HTTP roots (WITHOUT trailing slash) */
+@root.wiki:     ;       /* if left blank, wiki is at site HTTP root */
+@root.page:     ;       /* if left blank, pages served from wiki root */
+@root.image:    /image;
+@root.file:     /file;
+@root.category: /topic;
+
+/* navigation items */
+@navigation: {
+    Main page: /;
+};
+`
+
+const initMainPage = `@page.title: Welcome;
+
+sec {
+    Welcome to your new wiki! Edit [b]pages/main.page[/b] to change this page.
+}
+`
+
+func cmdInit(args []string) error {
+	fs := newFlagSet("init", "[-name title] path/to/wiki")
+	title := fs.String("name", "", "wiki title; defaults to the directory name")
+	parseFlags(fs, args, 1)
+	dir := fs.Arg(0)
+
+	// refuse to overwrite an existing wiki
+	confPath := filepath.Join(dir, "wiki.conf")
+	if _, err := os.Stat(confPath); err == nil {
+		return errors.New(confPath + " already exists")
+	}
+
+	// title defaults to directory name
+	if *title == "" {
+		abs, _ := filepath.Abs(dir)
+		*title = filepath.Base(abs)
+	}
+
+	// create directories
+	for _, sub := range []string{"pages", "images", "models", "topics", "cache"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	// create config and main page
+	if err := ioutil.WriteFile(confPath, []byte(fmt.Sprintf(initConfig, *title)), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pages", "main.page"), []byte(initMainPage), 0644); err != nil {
+		return err
+	}
+
+	// make sure it loads
+	if _, err := wiki.NewWiki(dir); err != nil {
+		return err
+	}
+
+	fmt.Println("created wiki at " + dir)
+	return nil
+}
+
+func cmdRender(args []string) error {
+	fs := newFlagSet("render", "[-draft] path/to/wiki page")
+	draft := fs.Bool("draft", false, "render even if the page is a draft")
+	parseFlags(fs, args, 2)
+
+	w, err := wiki.NewWiki(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	switch res := w.DisplayPageDraft(fs.Arg(1), *draft).(type) {
+	case wiki.DisplayPage:
+		fmt.Println(res.Content)
+	case wiki.DisplayRedirect:
+		fmt.Println("redirect: " + res.Redirect)
+	case wiki.DisplayError:
+		if res.Pos.Line != 0 {
+			return fmt.Errorf("%d:%d: %s", res.Pos.Line, res.Pos.Column, res.Error)
+		}
+		return errors.New(res.Error)
+	default:
+		return errors.New("unknown response")
+	}
+	return nil
+}
+
+func cmdPregenerate(args []string) error {
+	fs := newFlagSet("pregenerate", "path/to/wiki")
+	parseFlags(fs, args, 1)
+
+	w, err := wiki.NewWiki(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	w.Pregenerate()
+
+	progress := w.PregenerateProgress()
+	for _, pErr := range progress.Errors {
+		fmt.Printf("%s: error: %s\n", pErr.Page, pErr.Error)
+	}
+	fmt.Printf("%d pages, %d unchanged, %d errors\n", progress.Total, progress.Skipped, len(progress.Errors))
+	return nil
+}
+
+func cmdCheck(args []string) error {
+	fs := newFlagSet("check", "path/to/wiki")
+	parseFlags(fs, args, 1)
+
+	w, err := wiki.NewWiki(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	files, err := wikifier.UniqueFilesInDir(w.Opt().Dir.Page, []string{"page", "md"}, false)
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	nErrors, nWarnings := 0, 0
+	for _, name := range files {
+		page := w.FindPage(name)
+
+		// redirects have no content
+		if page.IsSymlink() {
+			continue
+		}
+		file := filepath.ToSlash(filepath.Join(filepath.Base(w.Opt().Dir.Page), name))
+
+		// parse, then generate to catch warnings which occur in html
+		if err := page.Parse(); err != nil {
+			nErrors++
+			if page.Error != nil {
+				fmt.Printf("%s:%d:%d: error: %s\n", file, page.Error.Pos.Line, page.Error.Pos.Column, page.Error.Message)
+			} else {
+				fmt.Printf("%s: error: %s\n", file, err)
+			}
+			continue
+		}
+		page.HTML()
+
+		for _, warn := range page.Warnings {
+			nWarnings++
+			fmt.Printf("%s:%d:%d: warning: %s\n", file, warn.Pos.Line, warn.Pos.Column, warn.Message)
+		}
+	}
+
+	fmt.Printf("%d pages, %d errors, %d warnings\n", len(files), nErrors, nWarnings)
+	if nErrors != 0 {
+		return errors.New("some pages have errors")
+	}
+	return nil
+}
+
+func cmdAddUser(args []string) error {
+	fs := newFlagSet("adduser", "[flags] path/to/quiki.conf username")
+	role := fs.String("role", string(authenticator.RoleEditor), "role on all wikis")
+	wikis := fs.String("wikis", "", "comma-separated roles on specific wikis, e.g. mywiki=admin")
+	name := fs.String("name", "", "display name; defaults to the username")
+	email := fs.String("email", "", "email address")
+	parseFlags(fs, args, 2)
+
+	auth, err := openAuth(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	user := authenticator.User{
+		Username:    fs.Arg(1),
+		DisplayName: *name,
+		Email:       *email,
+		Role:        authenticator.Role(*role),
+	}
+	if user.DisplayName == "" {
+		user.DisplayName = user.Username
+	}
+
+	// per-wiki roles
+	if *wikis != "" {
+		user.Wikis = make(map[string]authenticator.Role)
+		for _, grant := range strings.Split(*wikis, ",") {
+			split := strings.SplitN(grant, "=", 2)
+			if len(split) != 2 {
+				return errors.New("invalid wiki role: " + grant)
+			}
+			user.Wikis[strings.TrimSpace(split[0])] = authenticator.Role(strings.TrimSpace(split[1]))
+		}
+	}
+
+	password, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+
+	return auth.NewUser(user, password)
+}
+
+func cmdPasswd(args []string) error {
+	fs := newFlagSet("passwd", "path/to/quiki.conf username")
+	parseFlags(fs, args, 2)
+
+	auth, err := openAuth(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// check the user exists before asking for the password
+	if _, exist := auth.User(fs.Arg(1)); !exist {
+		return authenticator.ErrUserNotExist
+	}
+
+	password, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+
+	return auth.SetPassword(fs.Arg(1), password)
+}
+
+func cmdPurgeCache(args []string) error {
+	fs := newFlagSet("purge-cache", "path/to/wiki")
+	parseFlags(fs, args, 1)
+
+	w, err := wiki.NewWiki(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return w.PurgeAll()
+}
+
+// opens the server authenticator for a config file, like webserver does.
+// a path to the JSON file itself is also accepted
+func openAuth(path string) (*authenticator.Authenticator, error) {
+	if filepath.Ext(path) != ".json" {
+		path = filepath.Join(filepath.Dir(path), "quiki-auth.json")
+	}
+	return authenticator.Open(path)
+}
+
+// reads a new password, asking for confirmation if on a terminal
+func readNewPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+
+	// not a terminal; read a line
+	if !terminal.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords do not match")
+	}
+
+	return string(password), nil
+}
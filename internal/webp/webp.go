@@ -0,0 +1,736 @@
+// Package webp is a minimal encoder for lossless WebP (VP8L).
+//
+// It applies the subtract green and predictor transforms, then writes
+// literal pixels, color cache references, and backward references found with
+// hash chains, all with a single set of prefix codes. Output is about the
+// size of PNG, without requiring cgo.
+package webp
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+const (
+	maxDimension  = 1 << 14 // width and height are stored in 14 bits
+	maxCodeLen    = 15      // longest allowed prefix code
+	maxRunLen     = 4096    // longest backward reference
+	minRunLen     = 3       // shorter runs are written as literals
+	maxDistance   = 1<<20 - numNearCodes
+	maxChain      = 32 // hash chain entries tried for each pixel
+	hashBits      = 16
+	mainCacheBits = 10 // color cache size for the main image
+
+	numLiterals      = 256
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+
+	// distance codes for the pixel above and the pixel to the left. codes
+	// up to numNearCodes refer to nearby pixels
+	distAbove    = 1
+	distLeft     = 2
+	numNearCodes = 120
+
+	// transform types
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+
+	// predictor modes are chosen for blocks of 1<<predictorBits pixels
+	// square
+	predictorBits  = 4
+	numPredictors  = 14
+	opaqueBlack    = 0xff000000
+	predictorBlock = 1 << predictorBits
+)
+
+// order in which code length code lengths are written
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// a literal pixel or a backward reference
+type symbol struct {
+	argb   uint32
+	length int // nonzero for backward references
+	dist   int // distance code, for backward references
+	cache  int // color cache index plus one, for cached literals
+}
+
+// writes bits least significant first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (bw *bitWriter) write(n uint, v uint32) {
+	bw.acc |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nBits -= 8
+	}
+}
+
+func (bw *bitWriter) flush() []byte {
+	if bw.nBits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nBits = 0, 0
+	}
+	return bw.buf
+}
+
+// a canonical prefix code
+type prefixCode struct {
+	lengths []int
+	codes   []uint32 // bit-reversed, ready to write
+}
+
+func (c *prefixCode) write(bw *bitWriter, sym int) {
+	bw.write(uint(c.lengths[sym]), c.codes[sym])
+}
+
+// Encode writes an image as lossless WebP.
+func Encode(out io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return errors.New("webp: invalid image dimensions")
+	}
+
+	// collect pixels as non-premultiplied ARGB
+	pixels := make([]uint32, 0, width*height)
+	hasAlpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			pixels = append(pixels, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+
+	// header
+	bw := &bitWriter{}
+	bw.write(8, 0x2f) // signature
+	bw.write(14, uint32(width-1))
+	bw.write(14, uint32(height-1))
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(1, 0)
+	}
+	bw.write(3, 0) // version
+
+	// transforms, in the order they are applied. the decoder reverses them
+	// in the opposite order
+	bw.write(1, 1)
+	bw.write(2, transformSubtractGreen)
+	subtractGreen(pixels)
+
+	bw.write(1, 1)
+	bw.write(2, transformPredictor)
+	bw.write(3, predictorBits-2)
+	modes, residuals := predict(pixels, width, height)
+	writeImage(bw, modes, numBlocks(width), 0, false)
+
+	bw.write(1, 0) // no more transforms
+
+	writeImage(bw, residuals, width, mainCacheBits, true)
+	data := bw.flush()
+
+	// RIFF container. chunks are padded to an even length
+	chunkLen := len(data)
+	if chunkLen%2 == 1 {
+		data = append(data, 0)
+	}
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkLen))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	_, err := out.Write(data)
+	return err
+}
+
+// writes an entropy-coded image: the main image or the sub-image of a
+// transform, which has no meta prefix codes. if cacheBits is nonzero, a
+// color cache of that many bits is used
+func writeImage(bw *bitWriter, pixels []uint32, width int, cacheBits uint, main bool) {
+	if cacheBits != 0 {
+		bw.write(1, 1)
+		bw.write(4, uint32(cacheBits))
+	} else {
+		bw.write(1, 0)
+	}
+	if main {
+		bw.write(1, 0) // no meta prefix codes
+	}
+
+	symbols := findSymbols(pixels, width, cacheBits)
+
+	// count symbol frequencies for each of the five codes:
+	// green, lengths, and cache indexes; red; blue; alpha; distance
+	cacheCodes, cacheSize := numLiterals+numLengthCodes, 0
+	if cacheBits != 0 {
+		cacheSize = 1 << cacheBits
+	}
+	hist := [5][]int{
+		make([]int, cacheCodes+cacheSize),
+		make([]int, numLiterals),
+		make([]int, numLiterals),
+		make([]int, numLiterals),
+		make([]int, numDistanceCodes),
+	}
+	for _, s := range symbols {
+		if s.length != 0 {
+			lenCode, _, _ := prefixEncode(s.length)
+			distCode, _, _ := prefixEncode(s.dist)
+			hist[0][numLiterals+lenCode]++
+			hist[4][distCode]++
+			continue
+		}
+		if s.cache != 0 {
+			hist[0][cacheCodes+s.cache-1]++
+			continue
+		}
+		hist[0][s.argb>>8&0xff]++
+		hist[1][s.argb>>16&0xff]++
+		hist[2][s.argb&0xff]++
+		hist[3][s.argb>>24]++
+	}
+
+	// prefix codes
+	var codes [5]*prefixCode
+	for i, h := range hist {
+		codes[i] = writeCode(bw, h)
+	}
+
+	// image data
+	for _, s := range symbols {
+		if s.length != 0 {
+			lenCode, lenBits, lenExtra := prefixEncode(s.length)
+			distCode, distBits, distExtra := prefixEncode(s.dist)
+			codes[0].write(bw, numLiterals+lenCode)
+			bw.write(lenBits, lenExtra)
+			codes[4].write(bw, distCode)
+			bw.write(distBits, distExtra)
+			continue
+		}
+		if s.cache != 0 {
+			codes[0].write(bw, cacheCodes+s.cache-1)
+			continue
+		}
+		codes[0].write(bw, int(s.argb>>8&0xff))
+		codes[1].write(bw, int(s.argb>>16&0xff))
+		codes[2].write(bw, int(s.argb&0xff))
+		codes[3].write(bw, int(s.argb>>24))
+	}
+}
+
+// subtracts green from red and blue, since they are often correlated
+func subtractGreen(pixels []uint32) {
+	for i, p := range pixels {
+		green := p >> 8 & 0xff
+		red := (p>>16 - green) & 0xff
+		blue := (p - green) & 0xff
+		pixels[i] = p&0xff00ff00 | red<<16 | blue
+	}
+}
+
+// returns the number of predictor blocks needed to cover a dimension
+func numBlocks(size int) int {
+	return (size + predictorBlock - 1) / predictorBlock
+}
+
+// chooses a predictor mode for each block and returns the modes, stored in
+// the green channel of the sub-image, and the residuals: each pixel minus
+// its prediction
+func predict(pixels []uint32, width, height int) ([]uint32, []uint32) {
+	blocksX, blocksY := numBlocks(width), numBlocks(height)
+	modes := make([]uint32, blocksX*blocksY)
+	residuals := make([]uint32, len(pixels))
+
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			x0, y0 := bx*predictorBlock, by*predictorBlock
+			x1, y1 := x0+predictorBlock, y0+predictorBlock
+			if x1 > width {
+				x1 = width
+			}
+			if y1 > height {
+				y1 = height
+			}
+
+			// choose the mode with the smallest residuals
+			best, bestCost := 0, -1
+			for mode := 0; mode < numPredictors; mode++ {
+				cost := 0
+				for y := y0; y < y1 && (bestCost < 0 || cost < bestCost); y++ {
+					for x := x0; x < x1; x++ {
+						i := y*width + x
+						cost += residualCost(subPixels(pixels[i], prediction(pixels, width, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksX+bx] = opaqueBlack | uint32(best)<<8
+
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					i := y*width + x
+					residuals[i] = subPixels(pixels[i], prediction(pixels, width, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+// predicts a pixel from its neighbors, which come before it
+func prediction(pixels []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+
+	// the top-left pixel, top row, and left column are fixed
+	switch {
+	case x == 0 && y == 0:
+		return opaqueBlack
+	case y == 0:
+		return pixels[i-1]
+	case x == 0:
+		return pixels[i-width]
+	}
+
+	// for the rightmost column, the top-right pixel is the leftmost pixel
+	// of the current row, which is the pixel after the one above in memory
+	l, t, tl, tr := pixels[i-1], pixels[i-width], pixels[i-width-1], pixels[i-width+1]
+	switch mode {
+	case 0:
+		return opaqueBlack
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average(average(l, tr), t)
+	case 6:
+		return average(l, tl)
+	case 7:
+		return average(l, t)
+	case 8:
+		return average(tl, t)
+	case 9:
+		return average(t, tr)
+	case 10:
+		return average(average(l, tl), average(t, tr))
+	case 11:
+		return selectPixel(l, t, tl)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	default:
+		return clampAddSubtractHalf(average(l, t), tl)
+	}
+}
+
+// bit offsets of the alpha, red, green, and blue channels
+var channels = [4]uint{24, 16, 8, 0}
+
+// subtracts each channel modulo 256
+func subPixels(a, b uint32) uint32 {
+	var p uint32
+	for _, s := range channels {
+		p |= (a>>s - b>>s) & 0xff << s
+	}
+	return p
+}
+
+// estimates how costly a residual is to encode. small positive and
+// negative values are cheap
+func residualCost(r uint32) int {
+	cost := 0
+	for _, s := range channels {
+		v := int(r >> s & 0xff)
+		if v > 128 {
+			v = 256 - v
+		}
+		cost += v
+	}
+	return cost
+}
+
+func average(a, b uint32) uint32 {
+	var p uint32
+	for _, s := range channels {
+		p |= (a>>s&0xff + b>>s&0xff) / 2 << s
+	}
+	return p
+}
+
+// returns whichever of the left and top pixels is closer to the gradient
+// estimate l + t - tl
+func selectPixel(l, t, tl uint32) uint32 {
+	distL, distT := 0, 0
+	for _, s := range channels {
+		distL += abs(int(t>>s&0xff) - int(tl>>s&0xff))
+		distT += abs(int(l>>s&0xff) - int(tl>>s&0xff))
+	}
+	if distL < distT {
+		return l
+	}
+	return t
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for _, s := range channels {
+		p |= clamp(int(a>>s&0xff)+int(b>>s&0xff)-int(c>>s&0xff)) << s
+	}
+	return p
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var p uint32
+	for _, s := range channels {
+		v := int(a >> s & 0xff)
+		p |= clamp(v+(v-int(b>>s&0xff))/2) << s
+	}
+	return p
+}
+
+func clamp(v int) uint32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint32(v)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// splits pixels into literals, color cache references, and backward
+// references to earlier runs of the same pixels. the most recent positions
+// of each pair of pixels are kept in hash chains to find runs
+func findSymbols(pixels []uint32, width int, cacheBits uint) []symbol {
+	var cache []uint32
+	if cacheBits != 0 {
+		cache = make([]uint32, 1<<cacheBits)
+	}
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pixels))
+
+	// adds a pixel to the hash chains and color cache, as the decoder does
+	// for every pixel
+	add := func(i int) {
+		if i+1 < len(pixels) {
+			h := hashPair(pixels[i], pixels[i+1])
+			prev[i], head[h] = head[h], int32(i)
+		}
+		if cache != nil {
+			cache[cacheIndex(pixels[i], cacheBits)] = pixels[i]
+		}
+	}
+
+	var symbols []symbol
+	for i := 0; i < len(pixels); {
+
+		// the pixels above and to the left are tried first, since their
+		// distance codes are short
+		length, dist := 0, 0
+		try := func(d int) {
+			if n := matchLen(pixels, i, i-d); n > length {
+				length, dist = n, d
+			}
+		}
+		if i >= width {
+			try(width)
+		}
+		if i >= 1 {
+			try(1)
+		}
+		if i+1 < len(pixels) {
+			chain := 0
+			for cand := head[hashPair(pixels[i], pixels[i+1])]; cand >= 0 && chain < maxChain; cand = prev[cand] {
+				if i-int(cand) > maxDistance {
+					break
+				}
+				try(i - int(cand))
+				chain++
+			}
+		}
+
+		if length >= minRunLen {
+			symbols = append(symbols, symbol{length: length, dist: distanceCode(dist, width)})
+			for end := i + length; i < end; i++ {
+				add(i)
+			}
+			continue
+		}
+
+		sym := symbol{argb: pixels[i]}
+		if cache != nil {
+			if idx := cacheIndex(pixels[i], cacheBits); cache[idx] == pixels[i] {
+				sym.cache = idx + 1
+			}
+		}
+		symbols = append(symbols, sym)
+		add(i)
+		i++
+	}
+	return symbols
+}
+
+// returns the number of pixels starting at i which repeat those at j
+func matchLen(pixels []uint32, i, j int) int {
+	n := 0
+	for n < maxRunLen && i+n < len(pixels) && pixels[i+n] == pixels[j+n] {
+		n++
+	}
+	return n
+}
+
+// returns the distance code for a backward reference distance. the pixels
+// above and to the left have their own codes; others are offset by the
+// number of codes for nearby pixels
+func distanceCode(dist, width int) int {
+	switch dist {
+	case width:
+		return distAbove
+	case 1:
+		return distLeft
+	}
+	return dist + numNearCodes
+}
+
+func hashPair(a, b uint32) uint32 {
+	return (a*0x9e3779b1 ^ b*0x85ebca77) >> (32 - hashBits)
+}
+
+func cacheIndex(argb uint32, cacheBits uint) int {
+	return int(argb * 0x1e35a7bd >> (32 - cacheBits))
+}
+
+// returns the prefix code, number of extra bits, and extra bits value for
+// a backward reference length or distance code
+func prefixEncode(value int) (int, uint, uint32) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := 31
+	for v>>uint(high) == 0 {
+		high--
+	}
+	second := v >> uint(high-1) & 1
+	extraBits := uint(high - 1)
+	return 2*high + second, extraBits, uint32(v) & (1<<extraBits - 1)
+}
+
+// writes a prefix code for a histogram, returning the code
+func writeCode(bw *bitWriter, hist []int) *prefixCode {
+
+	// find used symbols
+	var used []int
+	for sym, count := range hist {
+		if count != 0 {
+			used = append(used, sym)
+		}
+	}
+
+	// simple code for up to two symbols below 256
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.write(1, 1) // simple
+		bw.write(1, uint32(len(used)-1))
+		if used[0] < 2 {
+			bw.write(1, 0)
+			bw.write(1, uint32(used[0]))
+		} else {
+			bw.write(1, 1)
+			bw.write(8, uint32(used[0]))
+		}
+		if len(used) == 2 {
+			bw.write(8, uint32(used[1]))
+		}
+
+		// a single symbol uses zero bits
+		lengths := make([]int, len(hist))
+		if len(used) == 2 {
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	// normal code
+	lengths := codeLengths(hist, maxCodeLen)
+	code := newPrefixCode(lengths)
+
+	// code lengths are themselves written with a prefix code
+	clHist := make([]int, 19)
+	for _, l := range lengths {
+		clHist[l]++
+	}
+	clLengths := codeLengths(clHist, 7)
+	clCode := newPrefixCode(clLengths)
+
+	// trim trailing zero lengths, but write at least 4
+	n := 19
+	for n > 4 && clLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(1, 0) // normal
+	bw.write(4, uint32(n-4))
+	for _, sym := range codeLengthOrder[:n] {
+		bw.write(3, uint32(clLengths[sym]))
+	}
+
+	// all lengths are written, so max_symbol is not used
+	bw.write(1, 0)
+	for _, l := range lengths {
+		clCode.write(bw, l)
+	}
+
+	return code
+}
+
+// a node while building a Huffman tree
+type node struct {
+	count       int
+	sym         int // leaf symbol, or -1
+	left, right *node
+}
+
+type nodeHeap []*node
+
+func (h nodeHeap) Len() int            { return len(h) }
+func (h nodeHeap) Less(i, j int) bool  { return h[i].count < h[j].count }
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(*node)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// computes Huffman code lengths no longer than maxLen. the resulting code
+// is always complete, so at least two symbols are given lengths
+func codeLengths(hist []int, maxLen int) []int {
+	counts := make([]int, len(hist))
+	copy(counts, hist)
+
+	// make sure there are at least two symbols
+	nUsed := 0
+	for _, c := range counts {
+		if c != 0 {
+			nUsed++
+		}
+	}
+	for sym := 0; nUsed < 2; sym++ {
+		if counts[sym] == 0 {
+			counts[sym] = 1
+			nUsed++
+		}
+	}
+
+	for {
+		lengths := make([]int, len(counts))
+		h := &nodeHeap{}
+		for sym, c := range counts {
+			if c != 0 {
+				*h = append(*h, &node{count: c, sym: sym})
+			}
+		}
+		sort.SliceStable(*h, func(i, j int) bool { return (*h)[i].count < (*h)[j].count })
+		heap.Init(h)
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*node)
+			b := heap.Pop(h).(*node)
+			heap.Push(h, &node{count: a.count + b.count, sym: -1, left: a, right: b})
+		}
+
+		// assign depths
+		tooLong := false
+		var walk func(n *node, depth int)
+		walk = func(n *node, depth int) {
+			if n.sym >= 0 {
+				lengths[n.sym] = depth
+				if depth > maxLen {
+					tooLong = true
+				}
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+		if !tooLong {
+			return lengths
+		}
+
+		// flatten the distribution and try again
+		for sym, c := range counts {
+			if c != 0 {
+				counts[sym] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// assigns canonical codes to code lengths
+func newPrefixCode(lengths []int) *prefixCode {
+	c := &prefixCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+
+	// count codes of each length and find the first code of each
+	var count [maxCodeLen + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLen + 2]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLen; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	// codes are written most significant bit first
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		v := next[l]
+		next[l]++
+		rev := uint32(0)
+		for i := 0; i < l; i++ {
+			rev = rev<<1 | v>>uint(i)&1
+		}
+		c.codes[sym] = rev
+	}
+	return c
+}
@@ -0,0 +1,410 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name          string
+		width, height int
+		pixel         func(x, y int) color.NRGBA
+	}{
+		{"single pixel", 1, 1, func(x, y int) color.NRGBA {
+			return color.NRGBA{10, 20, 30, 255}
+		}},
+		{"flat", 40, 30, func(x, y int) color.NRGBA {
+			return color.NRGBA{200, 100, 50, 255}
+		}},
+		{"gradient", 67, 45, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 3), uint8(y * 5), uint8(x + y), 255}
+		}},
+		{"stripes", 33, 17, func(x, y int) color.NRGBA {
+			if (x/3+y)%2 == 0 {
+				return color.NRGBA{255, 255, 255, 255}
+			}
+			return color.NRGBA{0, 0, 0, 255}
+		}},
+		{"noise with alpha", 50, 21, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))}
+		}},
+		{"palette", 120, 80, func(x, y int) color.NRGBA {
+			palette := []color.NRGBA{{255, 0, 0, 255}, {0, 128, 0, 200}, {0, 0, 255, 0}, {30, 30, 30, 255}}
+			return palette[rng.Intn(len(palette))]
+		}},
+		{"tiles", 150, 90, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x % 7 * 30), uint8(y % 5 * 40), uint8((x + y) % 11 * 20), 255}
+		}},
+		{"tall", 1, 100, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(y), uint8(y), uint8(y), uint8(255 - y)}
+		}},
+		{"wide", 100, 1, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x), 0, uint8(x * 2), 255}
+		}},
+		{"odd blocks with alpha", 17, 33, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 15), uint8(y * 7), 99, uint8((x * y) % 256)}
+		}},
+		{"transparent", 19, 5, func(x, y int) color.NRGBA {
+			return color.NRGBA{}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+			for y := 0; y < test.height; y++ {
+				for x := 0; x < test.width; x++ {
+					img.SetNRGBA(x, y, test.pixel(x, y))
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := Encode(&buf, img); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			decoded, err := xwebp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			if decoded.Bounds() != img.Bounds() {
+				t.Fatalf("bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+			}
+			for y := 0; y < test.height; y++ {
+				for x := 0; x < test.width; x++ {
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if want := img.NRGBAAt(x, y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeInvalidDimensions(t *testing.T) {
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(0, 0, 10, 0),
+		image.Rect(0, 0, maxDimension+1, 1),
+	} {
+		if err := Encode(ioutil.Discard, image.NewNRGBA(r)); err == nil {
+			t.Errorf("%v: expected error", r)
+		}
+	}
+}
+
+func TestSubtractGreen(t *testing.T) {
+	pixels := randomPixels(rand.New(rand.NewSource(2)), 1000)
+	orig := append([]uint32(nil), pixels...)
+	subtractGreen(pixels)
+
+	// the decoder adds green back to red and blue
+	for i, p := range pixels {
+		green := p >> 8 & 0xff
+		red := (p>>16 + green) & 0xff
+		blue := (p + green) & 0xff
+		if got := p&0xff00ff00 | red<<16 | blue; got != orig[i] {
+			t.Fatalf("pixel %d = %08x, want %08x", i, got, orig[i])
+		}
+	}
+}
+
+func TestPredict(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	sizes := [][2]int{{1, 1}, {1, 17}, {17, 1}, {16, 16}, {17, 17}, {33, 19}, {50, 3}}
+	for _, size := range sizes {
+		width, height := size[0], size[1]
+
+		// smooth areas with noise, so that various modes are chosen
+		pixels := make([]uint32, width*height)
+		for i := range pixels {
+			x, y := i%width, i/width
+			pixels[i] = uint32(x*9)<<24 | uint32(y*5)<<16 | uint32(x+y)<<8 | uint32(rng.Intn(4))
+			if (x/7+y/5)%3 == 0 {
+				pixels[i] = rng.Uint32()
+			}
+		}
+
+		modes, residuals := predict(pixels, width, height)
+		if len(modes) != numBlocks(width)*numBlocks(height) {
+			t.Fatalf("%dx%d: %d modes, want %d", width, height, len(modes), numBlocks(width)*numBlocks(height))
+		}
+
+		// reconstruct in decoding order from the residuals alone
+		decoded := make([]uint32, len(pixels))
+		for i := range decoded {
+			x, y := i%width, i/width
+			mode := modes[y/predictorBlock*numBlocks(width)+x/predictorBlock]
+			if mode&^0xff00 != opaqueBlack || mode>>8&0xff >= numPredictors {
+				t.Fatalf("%dx%d: bad mode pixel %08x", width, height, mode)
+			}
+			decoded[i] = addPixels(residuals[i], prediction(decoded, width, x, y, int(mode>>8&0xff)))
+			if decoded[i] != pixels[i] {
+				t.Fatalf("%dx%d: pixel (%d, %d) = %08x, want %08x", width, height, x, y, decoded[i], pixels[i])
+			}
+		}
+	}
+}
+
+func TestPrediction(t *testing.T) {
+
+	// a 3x2 image; the pixel at (1, 1) is predicted from these
+	tl, tp, tr, l := uint32(0x10204080), uint32(0x3040f0ff), uint32(0x01020304), uint32(0xffe00a50)
+	pixels := []uint32{tl, tp, tr, l, 0, 0}
+	avg := func(a, b uint32) uint32 {
+		return perChannel(a, b, 0, func(a, b, c int) int { return (a + b) / 2 })
+	}
+	tests := []uint32{
+		opaqueBlack,
+		l,
+		tp,
+		tr,
+		tl,
+		avg(avg(l, tr), tp),
+		avg(l, tl),
+		avg(l, tp),
+		avg(tl, tp),
+		avg(tp, tr),
+		avg(avg(l, tl), avg(tp, tr)),
+		specSelect(l, tp, tl),
+		perChannel(l, tp, tl, func(a, b, c int) int { return clampInt(a + b - c) }),
+		perChannel(avg(l, tp), tl, 0, func(a, b, c int) int { return clampInt(a + (a-b)/2) }),
+	}
+	for mode, want := range tests {
+		if got := prediction(pixels, 3, 1, 1, mode); got != want {
+			t.Errorf("mode %d = %08x, want %08x", mode, got, want)
+		}
+	}
+
+	// the edges ignore the mode
+	for mode := range tests {
+		if got := prediction(pixels, 3, 0, 0, mode); got != opaqueBlack {
+			t.Errorf("mode %d at (0, 0) = %08x, want %08x", mode, got, uint32(opaqueBlack))
+		}
+		if got := prediction(pixels, 3, 2, 0, mode); got != tp {
+			t.Errorf("mode %d at (2, 0) = %08x, want left %08x", mode, got, tp)
+		}
+		if got := prediction(pixels, 3, 0, 1, mode); got != tl {
+			t.Errorf("mode %d at (0, 1) = %08x, want top %08x", mode, got, tl)
+		}
+	}
+}
+
+func TestPrefixEncode(t *testing.T) {
+	for value := 1; value <= maxDistance+numNearCodes; value++ {
+		code, nBits, extra := prefixEncode(value)
+		if extra >= 1<<nBits {
+			t.Fatalf("%d: extra bits %d do not fit in %d bits", value, extra, nBits)
+		}
+
+		// as decoded
+		got := code + 1
+		if code >= 4 {
+			extraBits := uint(code-2) >> 1
+			offset := (2 + code&1) << extraBits
+			got = offset + int(extra) + 1
+		}
+		if got != value {
+			t.Fatalf("%d: decoded as %d", value, got)
+		}
+		if value <= maxRunLen && code >= numLengthCodes {
+			t.Fatalf("%d: length code %d out of range", value, code)
+		}
+		if code >= numDistanceCodes {
+			t.Fatalf("%d: distance code %d out of range", value, code)
+		}
+	}
+}
+
+func TestCodeLengths(t *testing.T) {
+
+	// fibonacci counts give the deepest possible Huffman tree
+	fib := make([]int, 30)
+	fib[0], fib[1] = 1, 1
+	for i := 2; i < len(fib); i++ {
+		fib[i] = fib[i-1] + fib[i-2]
+	}
+	hists := map[string][]int{
+		"empty":     make([]int, 10),
+		"single":    {0, 0, 5, 0},
+		"two":       {3, 0, 0, 9},
+		"even":      {1, 1, 1, 1, 1, 1, 1, 1},
+		"fibonacci": fib,
+	}
+	for name, hist := range hists {
+		lengths := codeLengths(hist, maxCodeLen)
+
+		// every used symbol has a code, and the code is complete
+		kraft, used := 0, 0
+		for sym, l := range lengths {
+			if hist[sym] != 0 && l == 0 {
+				t.Errorf("%s: symbol %d has no code", name, sym)
+			}
+			if l > maxCodeLen {
+				t.Errorf("%s: symbol %d has length %d", name, sym, l)
+			}
+			if l != 0 {
+				kraft += 1 << uint(maxCodeLen-l)
+				used++
+			}
+		}
+		if used < 2 || kraft != 1<<maxCodeLen {
+			t.Errorf("%s: code is not complete: %v", name, lengths)
+		}
+	}
+}
+
+func TestNewPrefixCode(t *testing.T) {
+	lengths := []int{3, 3, 3, 3, 3, 2, 4, 4, 0}
+	code := newPrefixCode(lengths)
+
+	// codes are stored reversed, so read them back least significant bit
+	// first and check that none is a prefix of another
+	codes := make([]string, len(lengths))
+	for sym, l := range lengths {
+		for i := 0; i < l; i++ {
+			codes[sym] += string(rune('0' + code.codes[sym]>>uint(i)&1))
+		}
+	}
+	for i, a := range codes {
+		for j, b := range codes {
+			if i != j && a != "" && strings.HasPrefix(b, a) {
+				t.Errorf("code %s is a prefix of %s", a, b)
+			}
+		}
+	}
+
+	// canonical: shorter codes come first, then by symbol
+	if codes[5] != "00" || codes[0] != "010" || codes[4] != "110" || codes[6] != "1110" || codes[7] != "1111" {
+		t.Errorf("codes are not canonical: %v", codes)
+	}
+}
+
+func TestFindSymbols(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	palette := randomPixels(rng, 6)
+	for _, width := range []int{1, 7, 33} {
+
+		// runs which repeat the rows above, and noise from a small palette
+		// which the color cache can find
+		pixels := make([]uint32, width*20)
+		for i := range pixels {
+			if i%width < width/2 {
+				pixels[i] = palette[i/width%2]
+			} else {
+				pixels[i] = palette[rng.Intn(len(palette))]
+			}
+		}
+
+		for _, bits := range []uint{0, mainCacheBits} {
+			symbols := findSymbols(pixels, width, bits)
+
+			// replay the symbols as the decoder does
+			var decoded []uint32
+			cache := make([]uint32, 1<<bits)
+			for _, s := range symbols {
+				start := len(decoded)
+				switch {
+				case s.length != 0:
+					dist := s.dist - numNearCodes
+					switch s.dist {
+					case distAbove:
+						dist = width
+					case distLeft:
+						dist = 1
+					}
+					if s.length < minRunLen || s.length > maxRunLen || dist < 1 || dist > len(decoded) {
+						t.Fatalf("width %d: bad backward reference %+v", width, s)
+					}
+					for n := 0; n < s.length; n++ {
+						decoded = append(decoded, decoded[len(decoded)-dist])
+					}
+				case s.cache != 0:
+					if bits == 0 {
+						t.Fatalf("width %d: cache reference without a cache", width)
+					}
+					decoded = append(decoded, cache[s.cache-1])
+				default:
+					decoded = append(decoded, s.argb)
+				}
+				if bits != 0 {
+					for _, p := range decoded[start:] {
+						cache[cacheIndex(p, bits)] = p
+					}
+				}
+			}
+
+			if len(decoded) != len(pixels) {
+				t.Fatalf("width %d: decoded %d pixels, want %d", width, len(decoded), len(pixels))
+			}
+			for i := range pixels {
+				if decoded[i] != pixels[i] {
+					t.Fatalf("width %d, cache bits %d: pixel %d = %08x, want %08x", width, bits, i, decoded[i], pixels[i])
+				}
+			}
+		}
+	}
+}
+
+func randomPixels(rng *rand.Rand, n int) []uint32 {
+	pixels := make([]uint32, n)
+	for i := range pixels {
+		pixels[i] = rng.Uint32()
+	}
+	return pixels
+}
+
+// adds each channel modulo 256, reversing subPixels
+func addPixels(a, b uint32) uint32 {
+	return perChannel(a, b, 0, func(a, b, c int) int { return (a + b) & 0xff })
+}
+
+// applies f to each channel of a, b, and c
+func perChannel(a, b, c uint32, f func(a, b, c int) int) uint32 {
+	var p uint32
+	for _, s := range []uint{24, 16, 8, 0} {
+		p |= uint32(f(int(a>>s&0xff), int(b>>s&0xff), int(c>>s&0xff))) << s
+	}
+	return p
+}
+
+func clampInt(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// the Select predictor as written in the specification
+func specSelect(l, t, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for _, s := range []uint{24, 16, 8, 0} {
+		estimate := int(l>>s&0xff) + int(t>>s&0xff) - int(tl>>s&0xff)
+		pl += absInt(estimate - int(l>>s&0xff))
+		pt += absInt(estimate - int(t>>s&0xff))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
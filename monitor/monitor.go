@@ -6,17 +6,25 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/cooper/quiki/wiki"
 	"github.com/fsnotify/fsnotify"
 )
 
+// how long to wait after a change for more changes before handling them.
+// editors and git often touch a file several times, or many files at once
+const debounceDelay = 250 * time.Millisecond
+
 type wikiMonitor struct {
 	w        *wiki.Wiki
 	watcher  *fsnotify.Watcher
 	watching map[string]bool
 }
 
+type eventHandler func(mon wikiMonitor, event fsnotify.Event, abs string)
+
 // WatchWiki starts a file monitor loop for the provided wiki.
 func WatchWiki(w *wiki.Wiki) {
 
@@ -44,10 +52,11 @@ func WatchWiki(w *wiki.Wiki) {
 		return nil
 	}
 
-	dirs := map[string]func(mon wikiMonitor, event fsnotify.Event, abs string){
-		w.Opt.Dir.Page:  handlePageEvent,
-		w.Opt.Dir.Image: handleImageEvent,
-		w.Opt.Dir.Model: handleModelEvent,
+	dirs := map[string]eventHandler{
+		w.Opt().Dir.Page:  handlePageEvent,
+		w.Opt().Dir.Image: handleImageEvent,
+		w.Opt().Dir.Model: handleModelEvent,
+		w.Dir("topics"):   handleCategoryEvent,
 	}
 
 	// watch each of the content dirs
@@ -58,11 +67,29 @@ func WatchWiki(w *wiki.Wiki) {
 			continue
 		}
 		dirs[dir] = handler
+
+		// topics are optional
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+
 		if err := filepath.Walk(dir, walkDir); err != nil {
 			log.Println("ERROR", err)
 		}
 	}
 
+	// watch the directory containing the config, since editors often
+	// replace the file rather than writing to it
+	configFile, _ := filepath.Abs(w.ConfigFile)
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		log.Println("ERROR", err)
+	}
+
+	// changes waiting to be handled, by absolute path
+	pending := make(map[string]fsnotify.Op)
+	timer := time.NewTimer(debounceDelay)
+	timer.Stop()
+
 	//
 	done := make(chan bool)
 
@@ -88,9 +115,11 @@ func WatchWiki(w *wiki.Wiki) {
 				// new directory created -- add to monitor
 				fi, err := os.Lstat(abs)
 				if err == nil && fi.IsDir() && event.Op&fsnotify.Create == fsnotify.Create {
-					log.Println("adding dir", abs)
-					mon.watching[abs] = true
-					watcher.Add(abs)
+					if findHandler(dirs, abs) != nil {
+						log.Println("adding dir", abs)
+						mon.watching[abs] = true
+						watcher.Add(abs)
+					}
 					continue
 				}
 
@@ -103,15 +132,27 @@ func WatchWiki(w *wiki.Wiki) {
 					continue
 				}
 
-				// file change; pass it on to handlers
-				for dir, handler := range dirs {
-					if _, err := filepath.Rel(dir, abs); err == nil {
-						handler(mon, event, abs)
-						break
+				// file change; wait for things to settle down
+				pending[abs] |= event.Op
+				timer.Reset(debounceDelay)
+
+			// nothing has changed for a bit; pass changes on to handlers
+			case <-timer.C:
+
+				// config first, since it affects everything else
+				if op, ok := pending[configFile]; ok {
+					delete(pending, configFile)
+					handleConfigEvent(mon, fsnotify.Event{Name: configFile, Op: op}, configFile)
+				}
+
+				for abs, op := range pending {
+					if handler := findHandler(dirs, abs); handler != nil {
+						handler(mon, fsnotify.Event{Name: abs, Op: op}, abs)
 					}
 				}
+				pending = make(map[string]fsnotify.Op)
 
-				// watch for errors
+			// watch for errors
 			case err := <-watcher.Errors:
 				log.Println("ERROR", err)
 			}
@@ -121,36 +162,105 @@ func WatchWiki(w *wiki.Wiki) {
 	<-done
 }
 
+// findHandler returns the handler for the watched directory containing a path
+func findHandler(dirs map[string]eventHandler, abs string) eventHandler {
+	for dir, handler := range dirs {
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return handler
+	}
+	return nil
+}
+
+// relName returns the slash-separated name of a file relative to a directory
+func relName(dir, abs string) string {
+	dir, _ = filepath.Abs(dir)
+	if rel, err := filepath.Rel(dir, abs); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return abs
+}
+
+// regenerateDependents generates the pages which use a page, image, or model
+// again after it has changed
+func regenerateDependents(mon wikiMonitor, name string, typ wiki.CategoryType) {
+	pages := mon.w.GetSpecialCategory(name, typ).Pages
+	mon.w.PurgeDependents(name, typ)
+	for pageName := range pages {
+		mon.w.DisplayPageDraft(pageName, true)
+	}
+}
+
 func handlePageEvent(mon wikiMonitor, event fsnotify.Event, abs string) {
 
 	// trim the page dir to get the actual name with prefix
 	osName := abs
-	dirPage, _ := filepath.Abs(mon.w.Opt.Dir.Page)
+	dirPage, _ := filepath.Abs(mon.w.Opt().Dir.Page)
 	if relPath, err := filepath.Rel(dirPage, abs); err == nil {
 		osName = relPath
 	}
 
-	switch event.Op {
+	// the page was deleted or renamed
+	fi, err := os.Lstat(abs)
+	if err != nil {
+		mon.w.RegeneratePage(osName)
+		regenerateDependents(mon, mon.w.FindPage(osName).NameNE(), wiki.CategoryTypePage)
+		return
+	}
 
-	case fsnotify.Create, fsnotify.Write:
+	// this is a symlink; ignore it
+	// FIXME: only skip if the target is also in the page dir?
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return
+	}
 
-		// this is a symlink; ignore it
-		// FIXME: only skip if the target is also in the page dir?
-		if fi, err := os.Lstat(abs); err == nil && fi.Mode()&os.ModeSymlink != 0 {
-			return
-		}
+	// force page to generate even if marked as draft, and remove it from
+	// categories it left. page name will be normalized including os-specific
+	// path separator
+	mon.w.RegeneratePage(osName)
+
+	// links to the page are no longer broken
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		regenerateDependents(mon, mon.w.FindPage(osName).NameNE(), wiki.CategoryTypePage)
+	}
+}
+
+func handleImageEvent(mon wikiMonitor, event fsnotify.Event, abs string) {
+	name := relName(mon.w.Opt().Dir.Image, abs)
 
-		// force page to generate even if marked as draft
-		// page name will be normalized including os-specific path separator
-		mon.w.DisplayPageDraft(osName, true)
+	// scaled versions are out of date, and pages need the new dimensions
+	mon.w.PurgeImage(name)
+	regenerateDependents(mon, name, wiki.CategoryTypeImage)
+}
+
+func handleModelEvent(mon wikiMonitor, event fsnotify.Event, abs string) {
+	regenerateDependents(mon, relName(mon.w.Opt().Dir.Model, abs), wiki.CategoryTypeModel)
+}
 
-	case fsnotify.Rename, fsnotify.Remove:
-		// TODO: w.PurgePage() or similar
-		os.Remove(filepath.Join(mon.w.Opt.Dir.Cache, "page", osName+".cache"))
-		os.Remove(filepath.Join(mon.w.Opt.Dir.Cache, "page", osName+".txt"))
+func handleCategoryEvent(mon wikiMonitor, event fsnotify.Event, abs string) {
+	name := relName(mon.w.Dir("topics"), abs)
+	if !strings.HasSuffix(name, ".cat") {
+		return
 	}
+	mon.w.ReloadCategory(strings.TrimSuffix(name, ".cat"))
 }
 
-func handleImageEvent(mon wikiMonitor, event fsnotify.Event, abs string) {}
+func handleConfigEvent(mon wikiMonitor, event fsnotify.Event, abs string) {
 
-func handleModelEvent(mon wikiMonitor, event fsnotify.Event, abs string) {}
+	// it may be in the middle of being replaced
+	if _, err := os.Lstat(abs); err != nil {
+		return
+	}
+
+	if err := mon.w.ReloadConfig(); err != nil {
+		log.Println("ERROR", err)
+		return
+	}
+
+	// every page depends on the configuration. pregenerate in the
+	// background so that other events are not held up
+	log.Println("reloaded configuration", abs)
+	mon.w.StartPregenerate()
+}
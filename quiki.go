@@ -1,9 +1,7 @@
 package main
 
 import (
-	"log"
 	"os"
-	"path/filepath"
 
 	"github.com/cooper/quiki/adminifier"
 	"github.com/cooper/quiki/webserver"
@@ -12,7 +10,13 @@ import (
 func main() {
 	// find config file
 	if len(os.Args) < 2 || os.Args[1] == "" {
-		log.Fatal("usage: " + os.Args[0] + " " + filepath.Join("path", "to", "quiki.conf"))
+		usage()
+	}
+
+	// run a subcommand
+	if _, exist := commands[os.Args[1]]; exist {
+		runCommand(os.Args[1], os.Args[2:])
+		return
 	}
 
 	// configure webserver using conf file
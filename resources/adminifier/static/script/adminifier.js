@@ -270,6 +270,7 @@ function frameLoad (page) {
 }
 
 // extract the page from the current URL and load it
+a.loadURL = loadURL;
 function loadURL() {
     var loc = window.location.pathname;
     frameLoad(loc.replace(wikiRootRgx, '') + window.location.search);
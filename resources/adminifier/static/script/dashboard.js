@@ -0,0 +1,91 @@
+(function (a) {
+
+var timer, linkTimer;
+document.addEvent('pageUnloaded', pageUnloaded);
+
+function pageUnloaded () {
+    clearTimeout(timer);
+    clearTimeout(linkTimer);
+    document.removeEvent('pageUnloaded', pageUnloaded);
+}
+
+// poll for pregeneration progress while it is running
+function updatePregen () {
+    var req = new Request.JSON({
+        url: 'func/pregen-status',
+        onSuccess: function (data) {
+            if (!data.success || !$('pregen-status'))
+                return;
+            displayPregen(data.progress);
+            if (data.progress.running)
+                timer = setTimeout(updatePregen, 1000);
+        }
+    }).post();
+}
+
+// show pregeneration progress
+function displayPregen (p) {
+    $('pregen-bar').set('max', p.total || 1);
+    $('pregen-bar').set('value', p.done);
+    $('pregen-text').set('text',
+        p.done + ' of ' + p.total + ' pages, ' + p.skipped + ' unchanged' +
+        (p.running ? '' : '. Finished.')
+    );
+
+    // errors
+    var errors = $('pregen-errors');
+    errors.empty();
+    errors.setStyle('display', p.errors ? 'block' : 'none');
+    (p.errors || []).each(function (err) {
+        errors.appendChild(new Element('a', {
+            href: 'edit-page?page=' + encodeURIComponent(err.page),
+            text: err.page
+        }));
+        errors.appendText(': ' + err.error + '\n');
+    });
+}
+
+// poll for broken link check results while it is running
+function updateLinks () {
+    var req = new Request.JSON({
+        url: 'func/link-status',
+        onSuccess: function (data) {
+            if (!data.success || !$('link-status'))
+                return;
+            if (data.report.running)
+                linkTimer = setTimeout(updateLinks, 1000);
+            else
+                displayLinks(data.report);
+        }
+    }).post();
+}
+
+// show broken link check results
+function displayLinks (r) {
+    var links = r.links || [];
+    var text = links.length ?
+        links.length + (links.length > 1 ? ' links do' : ' link does') + ' not lead anywhere.' :
+        'No broken links found.';
+    if (!r.external)
+        text += ' External links were not checked.';
+    $('link-text').set('text', text);
+
+    var list = $('link-list');
+    list.empty();
+    list.setStyle('display', links.length ? 'block' : 'none');
+    links.each(function (link) {
+        list.appendChild(new Element('a', {
+            href: 'edit-page?page=' + encodeURIComponent(link.page),
+            text: link.page
+        }));
+        list.appendText(':' + link.line + ':' + link.column + ': ' +
+            link.kind + ' ' + link.target + ': ' + link.reason + '\n');
+    });
+}
+
+if ($('pregen-status').get('data-running') == 'true')
+    timer = setTimeout(updatePregen, 1000);
+if ($('link-status').get('data-running') == 'true')
+    linkTimer = setTimeout(updateLinks, 1000);
+
+})(adminifier);
@@ -0,0 +1,79 @@
+(function (a) {
+
+document.addEvent('editorLoaded', loadedHandler);
+document.addEvent('pageUnloaded', unloadedHandler);
+
+var ae;
+function loadedHandler () {
+    ae = a.editor;
+
+    // add toolbar functions
+    ae.addToolbarFunctions({
+        backlinks: displayBacklinks
+    });
+}
+
+function unloadedHandler () {
+    document.removeEvent('editorLoaded', loadedHandler);
+    document.removeEvent('pageUnloaded', unloadedHandler);
+}
+
+// BACKLINKS
+
+function displayBacklinks () {
+
+    // make the li stay open until finish()
+    var li = ae.liForAction('backlinks');
+    ae.setLiLoading(li, true);
+
+    // create the box
+    var box = ae.createPopupBox(li);
+    box.setStyles({ right: 0, bottom: 0 });
+    box.addClass('fixed');
+    box.innerHTML = tmpl('tmpl-backlinks-viewer', {});
+    var container = box.getElement('#editor-backlinks');
+
+    // populate and display it
+    var finish = function (data) {
+        ae.setLiLoading(li, false);
+        if (!box)
+            return;
+        if (!data.success) {
+            alert(data.error);
+            return;
+        }
+        if (!data.backlinks || !data.backlinks.length) {
+            alert('No pages link here');
+            return;
+        }
+        data.backlinks.each(function (link) {
+            var row = new Element('div', { class: 'editor-backlink-row' });
+            row.innerHTML = tmpl('tmpl-backlink-row', link);
+            row.addEvent('click', function () {
+                editPage(link.file);
+            });
+            container.appendChild(row);
+        });
+        ae.displayPopupBox(box, 'auto', li);
+    };
+
+    // request backlinks
+    var req = new Request.JSON({
+        url: 'func/page-backlinks',
+        onSuccess: finish,
+        onFailure: function () {
+            finish({ error: 'Failed to fetch backlinks' });
+        },
+    }).post({
+        page: ae.getFilename()
+    });
+}
+
+// open a page in the editor
+function editPage (file) {
+    var target = 'edit-page?page=' + encodeURIComponent(file);
+    history.pushState(target, '', a.wikiRoot + '/' + target);
+    a.loadURL();
+}
+
+})(adminifier);
@@ -105,12 +105,12 @@ function handleDiffClick (box, row, e) {
         
         // view on wiki
         function () {
-            alert('Unimplemented');
+            viewRevision(row.get('data-commit'), false);
         },
         
         // view source
         function () {
-            alert('Unimplemented');
+            viewRevision(row.get('data-commit'), true);
         },
         
         // diff current
@@ -145,12 +145,25 @@ function handleDiffClick (box, row, e) {
         
         // revert
         function () {
-            alert('Unimplemented');
+            if (row.getPrevious()) {
+                alert('Only the most recent change can be reverted. ' +
+                    'Use restore to return to an older version.');
+                return;
+            }
+            if (!prevRow) {
+                alert('This is the oldest revision');
+                return;
+            }
+            restoreRevision(box, prevRow.get('data-commit'), 'revert ' + msg);
         },
         
         // restore
         function () {
-            alert('Unimplemented');
+            if (!row.getPrevious()) {
+                alert('This is the current version');
+                return;
+            }
+            restoreRevision(box, row.get('data-commit'));
         },
         
         // back
@@ -164,6 +177,48 @@ function handleDiffClick (box, row, e) {
     });
 }
 
+// open a revision in the revision frame
+function viewRevision (commit, source) {
+    if (ae.isModel()) {
+        alert('Revision history is only available for pages');
+        return;
+    }
+    var target = 'page-revision?page=' +
+        encodeURIComponent(ae.getFilename()) +
+        '&rev=' + commit + (source ? '&source' : '');
+    history.pushState(target, '', a.wikiRoot + '/' + target);
+    a.loadURL();
+}
+
+// restore the page to a revision and commit it
+function restoreRevision (box, commit, message) {
+    if (!confirm('Restore the page to revision ' + commit.substr(0, 7) + '?'))
+        return;
+    box.addClass('sticky');
+    var finish = function (data) {
+        box.removeClass('sticky');
+        if (!data.success) {
+            alert(data.error);
+            return;
+        }
+
+        // reload the editor with the restored content
+        ae.lastSavedData = editor.getValue();
+        a.loadURL();
+    };
+    var req = new Request.JSON({
+        url: 'func/revert-page',
+        onSuccess: finish,
+        onFailure: function () {
+            finish({ error: 'Failed to restore revision' });
+        },
+    }).post({
+        page: ae.getFilename(),
+        rev: commit,
+        message: message || ''
+    });
+}
+
 // DIFF VIEWER
 
 function displayDiffViewer (box, from, to, message, which) {
@@ -276,7 +276,7 @@ function saveRequest (saveData, message, success, fail) {
 
     // do the request
     new Request.JSON({
-        url: 'func/write-page' + (ae.isModel() ? '?model' : ''),
+        url: 'func/write-page' + (ae.isModel() ? '?model' : ae.isConfig() ? '?config' : ''),
         secure: true,
         onSuccess: function (data) {
 
@@ -22,6 +22,8 @@ a.json.results.each(function (pageData) {
     });
     if (pageData.redirect)
         pageData.desc = "Redirect \u00BB " + pageData.redirect;
+    else if (pageData.snippet)
+        pageData.desc = new Element('div', { html: pageData.snippet }).get('text');
     entry.setInfoState('Draft',     pageData.draft);
     entry.setInfoState('Redirect',  pageData.redirect);
     entry.setInfoState('External',  pageData.external);
@@ -46,4 +48,15 @@ exports.createPage = function () {
     createWindow.show();
 }
 
+exports.fullTextSearch = function () {
+    var query = $('top-search').get('value');
+    if (!query.length) {
+        alert('Enter search terms in the search box.');
+        return;
+    }
+    var page = 'pages?q=' + encodeURIComponent(query);
+    history.pushState(page, '', adminifier.wikiRoot + '/' + page);
+    a.loadURL();
+}
+
 })(adminifier, window);
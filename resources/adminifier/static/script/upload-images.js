@@ -0,0 +1,68 @@
+(function (a) {
+
+var form = $('upload-images-form');
+var results = $('upload-images-results');
+
+form.addEvent('submit', function (e) {
+    e.preventDefault();
+    var submit = form.getElement('input[type=submit]');
+    submit.disabled = true;
+    results.empty();
+
+    // post the files
+    var req = new XMLHttpRequest();
+    req.open('POST', form.action);
+    req.onload = function () {
+        submit.disabled = false;
+        var data;
+        try {
+            data = JSON.parse(req.responseText);
+        }
+        catch (err) {
+            displayError('Upload failed: ' + req.statusText);
+            return;
+        }
+        if (!data.images) {
+            displayError(data.error);
+            return;
+        }
+        data.images.each(displayResult);
+        if (data.success)
+            form.reset();
+    };
+    req.onerror = function () {
+        submit.disabled = false;
+        displayError('Upload failed');
+    };
+    req.send(new FormData(form));
+});
+
+// show the result of one upload
+function displayResult (image) {
+    var li = new Element('li');
+    if (image.error) {
+        li.set('text', image.name + ': ' + image.error);
+        results.appendChild(li);
+        return;
+    }
+
+    var link = new Element('a', {
+        href: a.wikiRoot + '/func/image/' + encodeURIComponent(image.file),
+        target: '_blank',
+        text: image.file
+    });
+    var note = ' (' + image.width + 'x' + image.height;
+    if (image.duplicate)
+        note += ', identical to an existing image';
+    if (image.resized)
+        note += ', scaled down';
+    li.appendChild(link);
+    li.appendText(note + ')');
+    results.appendChild(li);
+}
+
+function displayError (msg) {
+    results.appendChild(new Element('li', { text: msg }));
+}
+
+})(adminifier);
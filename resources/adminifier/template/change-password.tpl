@@ -0,0 +1,27 @@
+<h1>Change Password</h1>
+
+{{if .Changed}}
+<p>Your password has been changed.</p>
+{{end}}
+
+<form action="func/change-password" method="post">
+    <table>
+        <tr>
+            <td>Current password</td>
+            <td><input type="password" name="current" /></td>
+        </tr>
+        <tr>
+            <td>New password</td>
+            <td><input type="password" name="password" /></td>
+        </tr>
+        <tr>
+            <td>Confirm new password</td>
+            <td><input type="password" name="confirm" /></td>
+        </tr>
+        <tr>
+            <td><input type="submit" name="submit" value="Change" /></td>
+        </tr>
+    </table>
+</form>
+
+<a href="./">Back to sites</a>
@@ -0,0 +1,71 @@
+{{$roles := .Roles}}
+{{with .EditUser}}
+<h1>{{.Username}}</h1>
+
+<form action="func/update-user" method="post">
+    <input type="hidden" name="username" value="{{.Username}}" />
+    <table>
+        <tr>
+            <td>Name</td>
+            <td><input type="text" name="display" value="{{.DisplayName}}" /></td>
+        </tr>
+        <tr>
+            <td>Email</td>
+            <td><input type="text" name="email" value="{{.Email}}" /></td>
+        </tr>
+        <tr>
+            <td>New password</td>
+            <td><input type="password" name="password" placeholder="Unchanged" /></td>
+        </tr>
+        <tr>
+            <td>Role on all sites</td>
+            <td>
+                {{$role := .Role}}
+                <select name="role">
+                {{- range $roles}}
+                    <option value="{{.}}"{{if eq . $role}} selected{{end}}>{{if .}}{{.}}{{else}}none{{end}}</option>
+                {{- end}}
+                </select>
+            </td>
+        </tr>
+        <tr>
+            <td>Disabled</td>
+            <td><input type="checkbox" name="locked" value="1"{{if .Locked}} checked{{end}} /></td>
+        </tr>
+        {{if .LockedUntil}}{{if .IsLocked}}
+        <tr>
+            <td>Locked until {{.LockedUntil.Format "Jan 2, 2006 15:04"}}</td>
+            <td><label><input type="checkbox" name="unlock" value="1" /> Unlock</label></td>
+        </tr>
+        {{end}}{{end}}
+    </table>
+{{end}}
+
+    <h2>Site Roles</h2>
+    A role on a specific site only applies if it is higher than the role on all sites.
+    <table>
+    {{- range .Wikis}}
+        <tr>
+            <td>{{.Title}} ({{.Shortcode}})</td>
+            <td>
+                {{$role := .Role}}
+                <select name="wiki.{{.Shortcode}}">
+                {{- range $roles}}
+                    <option value="{{.}}"{{if eq . $role}} selected{{end}}>{{if .}}{{.}}{{else}}none{{end}}</option>
+                {{- end}}
+                </select>
+            </td>
+        </tr>
+    {{- end}}
+    </table>
+
+    <input type="submit" name="submit" value="Save" />
+</form>
+
+<h2>Delete User</h2>
+<form action="func/delete-user" method="post" onsubmit="return confirm('Delete {{.EditUser.Username}}?')">
+    <input type="hidden" name="username" value="{{.EditUser.Username}}" />
+    <input type="submit" name="submit" value="Delete" />
+</form>
+
+<a href="users">Back to users</a>
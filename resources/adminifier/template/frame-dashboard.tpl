@@ -2,6 +2,7 @@
     data-nav="dashboard"
     data-title="Dashboard"
     data-icon="home"
+    data-scripts="dashboard"
     data-styles="dashboard"
     data-flags="buttons"
     data-buttons="date-selection"
@@ -36,6 +37,47 @@
 </pre>
 {{end}}
 
+<h2>Pregeneration</h2>
+<div id="pregen-status" data-running="{{.Pregen.Running}}">
+{{- if .Pregen.Started}}
+<progress id="pregen-bar" max="{{if .Pregen.Total}}{{.Pregen.Total}}{{else}}1{{end}}" value="{{.Pregen.Done}}"></progress>
+<span id="pregen-text">
+    {{- .Pregen.Done}} of {{.Pregen.Total}} pages, {{.Pregen.Skipped}} unchanged
+    {{- if not .Pregen.Running}}. Finished.{{end -}}
+</span>
+<pre class="info" id="pregen-errors"{{if not .Pregen.Errors}} style="display: none;"{{end}}>
+{{- range .Pregen.Errors -}}
+<a href="edit-page?page={{.Page}}">{{.Page}}</a>: {{.Error}}
+{{end -}}
+</pre>
+{{- else}}
+Pages have not been pregenerated since the server started.
+{{- end}}
+</div>
+
+<h2>Broken Links</h2>
+<div id="link-status" data-running="{{.LinkCheck.Running}}">
+<span id="link-text">
+{{- if .LinkCheck.Running -}}
+Checking for broken links...
+{{- else if .LinkCheck.Finished -}}
+{{if .LinkCheck.Links -}}
+{{len .LinkCheck.Links}} link{{if gt (len .LinkCheck.Links) 1}}s do{{else}} does{{end}} not lead anywhere.
+{{- else -}}
+No broken links found.
+{{- end}}
+{{- if not .LinkCheck.External}} External links were not checked.{{end}}
+{{- else -}}
+Links have not been checked since the server started.
+{{- end -}}
+</span>
+<pre class="info" id="link-list"{{if not .LinkCheck.Links}} style="display: none;"{{end}}>
+{{- range .LinkCheck.Links -}}
+<a href="edit-page?page={{.Page}}">{{.Page}}</a>:
+{{- .Line}}:{{.Column}}: {{.Kind}} {{.Target}}: {{.Reason}}
+{{end -}}
+</pre>
+</div>
 
 <h2>Logs</h2>
 <pre class="info">
@@ -31,6 +31,7 @@
         <li data-action="save" class="right"><i class="fa right fa-save"></i> <span>Save</span></li>
         <li data-action="delete" class="right"><i class="fa right fa-trash"></i> Delete</li>
         <li data-action="revisions" class="right"><i class="fa right fa-history"></i> Revisions</li>
+        {{if .Page}}<li data-action="backlinks" class="right"><i class="fa right fa-link"></i> Links here</li>{{end}}
         <li data-action="view" class="right"><i class="fa right fa-binoculars"></i> View</li>
         <li class="hidden right" data-action="options"><i class="fa right fa-wrench"></i> Options</li>
         <li id="toolbar-redo" data-action="redo" class="right disabled"><i class="fa right fa-redo"></i> Redo</li>
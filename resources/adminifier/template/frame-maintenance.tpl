@@ -0,0 +1,75 @@
+<meta
+    data-nav="maintenance"
+    data-title="Maintenance"
+    data-icon="broom"
+/>
+
+{{if .Result}}
+<p><b>{{.Result}}</b></p>
+{{end}}
+
+<h2>Cache</h2>
+<table>
+    <tr>
+        <th></th>
+        <th>Files</th>
+        <th>Size</th>
+    </tr>
+{{range .Cache}}
+    <tr>
+        <td>{{.Name}}</td>
+        <td>{{.Files}}</td>
+        <td>{{.Size}}</td>
+    </tr>
+{{end}}
+</table>
+{{with .Memory}}
+<p>
+    {{.Pages}} pages in memory ({{.Size}} of {{.Max}}),
+    {{.Hits}} hits, {{.Misses}} misses
+</p>
+{{end}}
+
+<h2>Unused Images</h2>
+Generated images which are no longer displayed anywhere on the wiki can be
+deleted. Leave both fields empty to delete all of them.
+<form action="{{.Root}}/func/evict-images" method="post">
+    <input type="number" name="days" min="1" placeholder="Older than (days)" />
+    <input type="number" name="mb" min="1" placeholder="Until cache is under (MB)" />
+    <input type="submit" name="submit" value="Delete unused images" />
+</form>
+
+<h2>Pregenerate</h2>
+Generate all pages and the images they use ahead of time. Pages which have not
+changed since they were last pregenerated are skipped.
+<form action="{{.Root}}/func/pregenerate" method="post">
+    <input type="submit" name="submit" value="Pregenerate" />
+</form>
+
+<h2>Check Links</h2>
+Look for links to pages, categories, and sections which do not exist. Results
+are shown on the dashboard. External links on private networks are not checked.
+<form action="{{.Root}}/func/check-links" method="post">
+    <label><input type="checkbox" name="external" value="1" /> Check external links</label>
+    <input type="submit" name="submit" value="Check links" />
+</form>
+
+<h2>Purge</h2>
+Purged content is generated again the next time it is requested.
+
+Page:
+<form action="{{.Root}}/func/purge-page" method="post">
+    <input type="text" name="page" placeholder="Page filename" />
+    <input type="submit" name="submit" value="Purge" />
+</form>
+
+Image:
+<form action="{{.Root}}/func/purge-image" method="post">
+    <input type="text" name="image" placeholder="Image filename" />
+    <input type="submit" name="submit" value="Purge" />
+</form>
+
+Everything:
+<form action="{{.Root}}/func/purge-cache" method="post" onsubmit="return confirm('Purge all generated pages and images?')">
+    <input type="submit" name="submit" value="Purge all" />
+</form>
@@ -0,0 +1,26 @@
+<meta
+    data-nav="pages"
+    data-title="Compare {{.File}}"
+    data-icon="clone"
+    data-styles="history"
+/>
+
+<div class="history-notice">
+    Comparing <a href="page-history?page={{.File}}">{{.File}}</a>
+    at <code>{{slice .From 0 7}}</code> to
+    {{if .To}}<code>{{slice .To 0 7}}</code>{{else}}the current version{{end}}.
+</div>
+
+{{if .Lines}}
+<table class="history-diff">
+{{- range .Lines}}
+    <tr class="history-diff-{{.Type}}">
+        <td class="history-diff-num">{{if .OldLine}}{{.OldLine}}{{end}}</td>
+        <td class="history-diff-num">{{if .NewLine}}{{.NewLine}}{{end}}</td>
+        <td class="history-diff-text">{{.Text}}</td>
+    </tr>
+{{- end}}
+</table>
+{{else}}
+No changes.
+{{end}}
@@ -0,0 +1,33 @@
+<meta
+    data-nav="pages"
+    data-title="History: {{.Title}}"
+    data-icon="history"
+    data-styles="history"
+/>
+
+<h2>{{.Title}}</h2>
+{{len .Revisions}} revision{{if ne (len .Revisions) 1}}s{{end}} of <a href="edit-page?page={{.File}}">{{.File}}</a>.
+
+{{$file := .File}}
+<table class="history-revisions">
+    <tr>
+        <th>Revision</th>
+        <th>Message</th>
+        <th>Author</th>
+        <th>Date</th>
+        <th></th>
+    </tr>
+{{- range $i, $rev := .Revisions}}
+    <tr>
+        <td><code>{{slice .Id 0 7}}</code></td>
+        <td>{{.Message}}</td>
+        <td>{{.Author}}</td>
+        <td>{{.Date.Format "Jan 2, 2006 15:04"}}</td>
+        <td class="history-actions">
+            <a href="page-revision?page={{$file}}&amp;rev={{.Id}}">View</a>
+            <a href="page-revision?page={{$file}}&amp;rev={{.Id}}&amp;source">Source</a>
+            {{if $i}}<a href="page-diff?page={{$file}}&amp;from={{.Id}}">Compare to current</a>{{end}}
+        </td>
+    </tr>
+{{- end}}
+</table>
@@ -0,0 +1,24 @@
+<meta
+    data-nav="pages"
+    data-title="{{.Title}} at {{.Rev}}"
+    data-icon="history"
+    data-styles="history"
+/>
+
+<div class="history-notice">
+    You are viewing <a href="page-history?page={{.File}}">{{.File}}</a> as of revision <code>{{.Rev}}</code>.
+    {{if .IsSource -}}
+    <a href="page-revision?page={{.File}}&amp;rev={{.Rev}}">View page</a>
+    {{- else -}}
+    <a href="page-revision?page={{.File}}&amp;rev={{.Rev}}&amp;source">View source</a>
+    {{- end}}
+</div>
+
+{{if .IsSource}}
+<pre class="info">{{.Source}}</pre>
+{{else}}
+{{if .CSS}}<style>{{.CSS}}</style>{{end}}
+<div class="q-main">
+{{.Content}}
+</div>
+{{end}}
@@ -10,8 +10,9 @@
     data-search="fileSearch"
     data-sort="{{.Order}}"
 
-    data-buttons="create filter"
+    data-buttons="create search filter"
     data-button-create="{'title': 'New page', 'icon': 'plus-circle', 'func': 'createPage'}"
+    data-button-search="{'title': 'Full-text search', 'icon': 'search', 'func': 'fullTextSearch'}"
     data-button-filter="{'title': 'Filter', 'icon': 'filter', 'func': 'displayFilter'}"
 
     data-selection-buttons="move rename delete"
@@ -8,7 +8,12 @@ Switch Branch:
 <ul>
 {{$root := .Root}}
 {{range .Branches}}
-    <li><a href="{{$root}}/func/switch-branch/{{.}}">{{.}}</a></li>
+    <li>
+        <a href="{{$root}}/func/switch-branch/{{.}}">{{.}}</a>
+        {{if ne . "master"}}
+        (<a href="{{$root}}/switch-branch?compare={{.}}">compare with master</a>)
+        {{end}}
+    </li>
 {{end}}
 </ul>
 
@@ -16,4 +21,39 @@ New Branch:
 <form action="{{.Root}}/func/create-branch" method="post">
     <input type="text" name="branch" />
     <input type="submit" name="submit" value="Create" />
-</form>
\ No newline at end of file
+</form>
+
+{{if .Compare}}
+<h2>Changes on {{.Compare}}</h2>
+{{if .Changes}}
+{{len .Changes}} file{{if ne (len .Changes) 1}}s{{end}} changed since {{.Compare}} diverged from master.
+<ul>
+{{range .Changes}}
+    <li>
+        {{.Type}}:
+        {{if and .Page (ne .Type "deleted")}}<a href="{{$root}}/page-history?page={{.Page}}">{{.File}}</a>{{else}}{{.File}}{{end}}
+        {{if .Conflict}}<b>conflict: {{.Conflict}}</b>{{end}}
+    </li>
+{{end}}
+</ul>
+
+{{if .Conflicts}}
+These changes cannot be merged until the conflicting files are resolved.
+{{else}}
+Merge into master:
+<form action="{{.Root}}/func/merge-branch" method="post">
+    <input type="hidden" name="branch" value="{{.Compare}}" />
+    <input type="text" name="message" placeholder="Comment" />
+    <input type="submit" name="submit" value="Merge" />
+</form>
+{{end}}
+{{else}}
+There are no changes on {{.Compare}} which are not in master.
+{{end}}
+
+Discard branch:
+<form action="{{.Root}}/func/delete-branch" method="post" onsubmit="return confirm('Delete {{.Compare}} and any unmerged changes?')">
+    <input type="hidden" name="branch" value="{{.Compare}}" />
+    <input type="submit" name="submit" value="Delete" />
+</form>
+{{end}}
@@ -0,0 +1,21 @@
+<meta
+    data-nav="images"
+    data-title="Upload images"
+    data-icon="upload"
+    data-scripts="upload-images"
+/>
+
+<h2>Upload Images</h2>
+PNG and JPEG images are accepted{{if .MaxSize}}, up to {{.MaxSize}} MB each{{end}}.
+{{if .MaxDimension}}Images larger than {{.MaxDimension}}px are scaled down.{{end}}
+Metadata such as EXIF is removed.
+
+<form id="upload-images-form" action="{{.Root}}/func/upload-image" method="post" enctype="multipart/form-data">
+    <input type="file" name="image" accept="image/png,image/jpeg" multiple />
+    <input type="text" name="message" placeholder="Comment" />
+    <input type="submit" name="submit" value="Upload" />
+</form>
+
+<ul id="upload-images-results"></ul>
+
+<a href="{{.Root}}/images">Back to images</a>
@@ -196,6 +196,15 @@
     </div>
 </script>
 
+<script type="text/x-tmpl" id="tmpl-backlinks-viewer">
+    <div id="editor-backlinks"></div>
+</script>
+
+<script type="text/x-tmpl" id="tmpl-backlink-row">
+    <b>{%= o.title || o.file_ne %}</b><br />
+    {%= o.file %}{% if (o.lines) { %}, line{%= o.lines.length > 1 ? 's' : '' %} {%= o.lines.join(', ') %}{% } %}
+</script>
+
 <script type="text/x-tmpl" id="tmpl-color-name">
     <span style="padding-left: 10px;">{%= o.colorName %}</span>
 </script>
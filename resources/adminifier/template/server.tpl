@@ -4,6 +4,10 @@ Available sites:
 <ul>
 {{range $shortcode, $wi := .Wikis}}
     <li><a href="{{$shortcode}}/dashboard">{{$wi.Title}}</a></li>
+{{else}}
+    <li>You do not have access to any sites.</li>
 {{end}}
 </ul>
-<a href="logout">Logout</a>
\ No newline at end of file
+{{if .ServerAdmin}}<a href="users">Manage users</a> |{{end}}
+<a href="change-password">Change password</a> |
+<a href="logout">Logout</a>
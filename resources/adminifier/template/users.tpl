@@ -0,0 +1,59 @@
+<h1>Users</h1>
+
+<table>
+    <tr>
+        <th>Username</th>
+        <th>Name</th>
+        <th>Email</th>
+        <th>Role</th>
+        <th>Status</th>
+        <th>Last login</th>
+    </tr>
+{{- range .Users}}
+    <tr>
+        <td><a href="edit-user?user={{.Username}}">{{.Username}}</a></td>
+        <td>{{.DisplayName}}</td>
+        <td>{{.Email}}</td>
+        <td>{{if .Role}}{{.Role}}{{else}}none{{end}}{{if .Wikis}} (+{{len .Wikis}} site{{if gt (len .Wikis) 1}}s{{end}}){{end}}</td>
+        <td>{{if .Locked}}Disabled{{else if .IsLocked}}Locked{{else}}Active{{end}}</td>
+        <td>{{if .LastLogin}}{{.LastLogin.Format "Jan 2, 2006 15:04"}}{{else}}Never{{end}}</td>
+    </tr>
+{{- end}}
+</table>
+
+<h2>New User</h2>
+<form action="func/create-user" method="post">
+    <table>
+        <tr>
+            <td>Username</td>
+            <td><input type="text" name="username" /></td>
+        </tr>
+        <tr>
+            <td>Name</td>
+            <td><input type="text" name="display" /></td>
+        </tr>
+        <tr>
+            <td>Email</td>
+            <td><input type="text" name="email" /></td>
+        </tr>
+        <tr>
+            <td>Password</td>
+            <td><input type="password" name="password" /></td>
+        </tr>
+        <tr>
+            <td>Role</td>
+            <td>
+                <select name="role">
+                {{- range .Roles}}
+                    <option value="{{.}}">{{if .}}{{.}}{{else}}none{{end}}</option>
+                {{- end}}
+                </select>
+            </td>
+        </tr>
+        <tr>
+            <td><input type="submit" name="submit" value="Create" /></td>
+        </tr>
+    </table>
+</form>
+
+<a href="./">Back to sites</a>
@@ -49,8 +49,12 @@ var adminifier = {
         <li data-nav="categories"><a class="frame-click" href="{{.Root}}/categories"><i class="fa fa-list"></i> <span>Categories</span></a></li>
         <li data-nav="images"><a class="frame-click" href="{{.Root}}/images"><i class="fa fa-images"></i> <span>Images</span></a></li>
         <li data-nav="models"><a class="frame-click" href="{{.Root}}/models"><i class="fa fa-cube"></i> <span>Models</span></a></li>
+        {{if .CanAdmin}}
         <li data-nav="settings"><a class="frame-click" href="{{.Root}}/settings"><i class="fa fa-cog"></i> <span>Settings</a></li>
+        <li data-nav="maintenance"><a class="frame-click" href="{{.Root}}/maintenance"><i class="fa fa-broom"></i> <span>Maintenance</span></a></li>
+        {{end}}
         <li data-nav="help"><a class="frame-click" href="{{.Root}}/help"><i class="fa fa-question-circle"></i> <span>Help</a></li>
+        <li><a href="{{.AdminRoot}}/change-password"><i class="fa fa-key"></i> <span>Password</span></a></li>
         {{if .ServerPanelAccess}}
             <li><a href="{{.AdminRoot}}/"><i class="fa fa-globe-americas"></i> <span>Sites</span></a></li>
         {{else}}
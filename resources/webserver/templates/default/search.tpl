@@ -0,0 +1,16 @@
+{{template "header.tpl" .}}
+<form class="search-form" action="{{.Root.Search}}/" method="get">
+    <input type="search" name="q" value="{{.SearchQuery}}" placeholder="Search" />
+    <input type="submit" value="Search" />
+</form>
+{{if .SearchQuery}}
+    {{range $p := .Pages}}
+        <div class="search-result">
+            <a class="search-result-title" href="{{$.Root.Page}}/{{$p.Name}}">{{or $p.Title $p.Name}}</a>
+            <p>{{$p.HTMLContent}}</p>
+        </div>
+    {{else}}
+        <p>No pages matched <b>{{.SearchQuery}}</b>.</p>
+    {{end}}
+{{end}}
+{{template "footer.tpl" .}}
\ No newline at end of file
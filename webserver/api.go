@@ -0,0 +1,398 @@
+package webserver
+
+// api.go - read-only JSON API
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cooper/quiki/wiki"
+	"github.com/cooper/quiki/wikifier"
+)
+
+// HTTP root of the API, relative to the wiki root
+const apiRoot = "/api/v1"
+
+// default and maximum number of results on each page of a listing
+const (
+	apiPerPage    = 50
+	apiMaxPerPage = 500
+)
+
+// whether the API is enabled, from server.enable.api
+var apiEnabled bool
+
+// value of Access-Control-Allow-Origin for API responses, from
+// server.api.cors. if empty, the header is not sent
+var apiCORS string
+
+// sort types for the sort query parameter, as in adminifier.
+// a trailing - sorts in descending order
+var apiSorters = map[string]wiki.SortFunc{
+	"t": wiki.SortTitle,
+	"a": wiki.SortAuthor,
+	"c": wiki.SortCreated,
+	"m": wiki.SortModified,
+	"d": wiki.SortDimensions,
+}
+
+// apiPage is a page display result including its content
+type apiPage struct {
+	wiki.DisplayPage
+	Content wikifier.HTML `json:"content"` // page HTML
+	Text    string        `json:"text"`    // page text without HTML tags
+}
+
+// apiPosts is a category posts display result including the content of
+// each page
+type apiPosts struct {
+	*wiki.Category
+	Pages    []apiPage `json:"pages"`
+	Page     int       `json:"page"` // page number, starting at 1
+	NumPages int       `json:"num_pages"`
+	CSS      string    `json:"css,omitempty"`
+}
+
+// apiList is one page of a listing
+type apiList struct {
+	Results  interface{} `json:"results"`
+	Total    int         `json:"total"` // number of results on all pages
+	Page     int         `json:"page"`  // page number, starting at 1
+	PerPage  int         `json:"per_page"`
+	NumPages int         `json:"num_pages"`
+}
+
+// read server.enable.api and server.api.* options
+func setupAPI() error {
+	var err error
+	if apiEnabled, err = Conf.GetBool("server.enable.api"); err != nil {
+		return err
+	}
+	apiCORS, err = Conf.GetStr("server.api.cors")
+	return err
+}
+
+// api request
+func handleAPI(wi *WikiInfo, relPath string, w http.ResponseWriter, r *http.Request) {
+	if apiCORS != "" {
+		w.Header().Set("Access-Control-Allow-Origin", apiCORS)
+	}
+
+	// it's read-only
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		apiError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	// split into resource type and name.
+	// the name is cleaned so that it cannot refer outside of the wiki
+	split := strings.SplitN(relPath, "/", 2)
+	typ, name := split[0], ""
+	if len(split) == 2 {
+		name = path.Clean("/" + split[1])[1:]
+	}
+
+	switch typ {
+	case "pages":
+		if name == "" {
+			handleAPIPages(wi, w, r)
+		} else {
+			handleAPIPage(wi, name, w, r)
+		}
+	case "images":
+		if name == "" {
+			handleAPIImages(wi, w, r)
+		} else {
+			handleAPIImage(wi, name, w, r)
+		}
+	case "models":
+		if name == "" {
+			handleAPIModels(wi, w, r)
+		} else {
+			handleAPIModel(wi, name, w, r)
+		}
+	case "categories":
+		if name == "" {
+			handleAPICategories(wi, w, r)
+		} else if strings.HasSuffix(name, "/posts") {
+			handleAPICategoryPosts(wi, strings.TrimSuffix(name, "/posts"), w, r)
+		} else {
+			handleAPICategory(wi, name, w, r)
+		}
+	default:
+		apiError(w, http.StatusNotFound, "Not found.")
+	}
+}
+
+// GET /api/v1/pages
+func handleAPIPages(wi *WikiInfo, w http.ResponseWriter, r *http.Request) {
+	descending, sortFunc, err := apiSort(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// drafts are not listed
+	var pages []wikifier.PageInfo
+	for _, info := range wi.PagesSorted(descending, sortFunc, wiki.SortTitle) {
+		if !info.Draft {
+			pages = append(pages, info)
+		}
+	}
+
+	list, start, end, err := apiListPage(r, len(pages))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list.Results = append([]wikifier.PageInfo{}, pages[start:end]...)
+	apiJSON(w, r, list, time.Time{})
+}
+
+// GET /api/v1/pages/{name}
+func handleAPIPage(wi *WikiInfo, name string, w http.ResponseWriter, r *http.Request) {
+	switch res := wi.DisplayPage(name).(type) {
+	case wiki.DisplayPage:
+		apiJSON(w, r, apiPageFromRes(res), modTime(res.Modified))
+	case wiki.DisplayRedirect:
+		apiJSON(w, r, map[string]string{"redirect": res.Redirect}, time.Time{})
+	case wiki.DisplayError:
+		apiDisplayError(w, res)
+	default:
+		apiError(w, http.StatusNotFound, "Page does not exist.")
+	}
+}
+
+// GET /api/v1/images
+func handleAPIImages(wi *WikiInfo, w http.ResponseWriter, r *http.Request) {
+	descending, sortFunc, err := apiSort(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	images := wi.ImagesSorted(descending, sortFunc, wiki.SortTitle)
+	list, start, end, err := apiListPage(r, len(images))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list.Results = append([]wiki.ImageInfo{}, images[start:end]...)
+	apiJSON(w, r, list, time.Time{})
+}
+
+// GET /api/v1/images/{name}
+func handleAPIImage(wi *WikiInfo, name string, w http.ResponseWriter, r *http.Request) {
+	info := wi.ImageInfo(name)
+	if info.File == "" {
+		apiError(w, http.StatusNotFound, "Image does not exist.")
+		return
+	}
+	apiJSON(w, r, info, modTime(info.Modified))
+}
+
+// GET /api/v1/models
+func handleAPIModels(wi *WikiInfo, w http.ResponseWriter, r *http.Request) {
+	descending, sortFunc, err := apiSort(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	models := wi.ModelsSorted(descending, sortFunc, wiki.SortTitle)
+	list, start, end, err := apiListPage(r, len(models))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	results := make([]wikifier.ModelInfo, 0, end-start)
+	for _, info := range models[start:end] {
+		info.Path = "" // don't reveal server paths
+		results = append(results, info)
+	}
+	list.Results = results
+	apiJSON(w, r, list, time.Time{})
+}
+
+// GET /api/v1/models/{name}
+func handleAPIModel(wi *WikiInfo, name string, w http.ResponseWriter, r *http.Request) {
+	info := wi.ModelInfo(name)
+	if info.File == "" {
+		apiError(w, http.StatusNotFound, "Model does not exist.")
+		return
+	}
+	info.Path = "" // don't reveal server paths
+	apiJSON(w, r, info, modTime(info.Modified))
+}
+
+// GET /api/v1/categories
+func handleAPICategories(wi *WikiInfo, w http.ResponseWriter, r *http.Request) {
+	descending, sortFunc, err := apiSort(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cats := wi.CategoriesSorted(descending, sortFunc, wiki.SortTitle)
+	list, start, end, err := apiListPage(r, len(cats))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	results := make([]*wiki.Category, 0, end-start)
+	for _, info := range cats[start:end] {
+		results = append(results, apiCategory(info.Category))
+	}
+	list.Results = results
+	apiJSON(w, r, list, time.Time{})
+}
+
+// GET /api/v1/categories/{name}
+func handleAPICategory(wi *WikiInfo, name string, w http.ResponseWriter, r *http.Request) {
+	cat := wi.GetCategory(name)
+	if !cat.Exists() {
+		apiError(w, http.StatusNotFound, "Category does not exist.")
+		return
+	}
+	apiJSON(w, r, apiCategory(cat), modTime(cat.Modified))
+}
+
+// GET /api/v1/categories/{name}/posts
+func handleAPICategoryPosts(wi *WikiInfo, name string, w http.ResponseWriter, r *http.Request) {
+	pageN, err := apiIntParam(r, "page", 1)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch res := wi.DisplayCategoryPosts(name, pageN-1).(type) {
+	case wiki.DisplayCategoryPosts:
+		posts := apiPosts{
+			Category: apiCategory(res.Category),
+			Pages:    make([]apiPage, len(res.Pages)),
+			Page:     res.PageN + 1,
+			NumPages: res.NumPages,
+			CSS:      res.CSS,
+		}
+		for i, page := range res.Pages {
+			posts.Pages[i] = apiPageFromRes(page)
+		}
+		apiJSON(w, r, posts, postsModified(res))
+	case wiki.DisplayError:
+		apiDisplayError(w, res)
+	default:
+		apiError(w, http.StatusNotFound, "Category does not exist.")
+	}
+}
+
+func apiPageFromRes(res wiki.DisplayPage) apiPage {
+	res.Path = "" // don't reveal server paths
+	return apiPage{
+		DisplayPage: res,
+		Content:     res.Content,
+		Text:        strings.TrimSpace(res.Content.Text()),
+	}
+}
+
+// apiCategory returns a copy of a category without draft pages
+func apiCategory(cat *wiki.Category) *wiki.Category {
+	copied := *cat
+	copied.Pages = make(map[string]wiki.CategoryEntry, len(cat.Pages))
+	for name, entry := range cat.Pages {
+		if !entry.Draft {
+			copied.Pages[name] = entry
+		}
+	}
+	return &copied
+}
+
+// apiSort returns the sort requested by the sort query parameter.
+// the default is most recently modified first
+func apiSort(r *http.Request) (bool, wiki.SortFunc, error) {
+	s := r.URL.Query().Get("sort")
+	if s == "" {
+		return true, wiki.SortModified, nil
+	}
+	sortFunc, ok := apiSorters[s[:1]]
+	if !ok || (len(s) > 1 && s[1:] != "-") {
+		return false, nil, errors.New("invalid sort")
+	}
+	return len(s) > 1, sortFunc, nil
+}
+
+// apiListPage returns the part of a listing requested by the page and
+// per_page query parameters, and the bounds of the results on it
+func apiListPage(r *http.Request, total int) (list apiList, start, end int, err error) {
+	page, err := apiIntParam(r, "page", 1)
+	if err != nil {
+		return
+	}
+	perPage, err := apiIntParam(r, "per_page", apiPerPage)
+	if err != nil {
+		return
+	}
+	if perPage > apiMaxPerPage {
+		perPage = apiMaxPerPage
+	}
+
+	list = apiList{
+		Total:    total,
+		Page:     page,
+		PerPage:  perPage,
+		NumPages: (total + perPage - 1) / perPage,
+	}
+
+	// past the end, there are no results. the page number is checked
+	// first so that a huge one cannot overflow
+	start = total
+	if page <= list.NumPages {
+		start = (page - 1) * perPage
+	}
+	end = start + perPage
+	if end > total {
+		end = total
+	}
+	return
+}
+
+// apiIntParam returns a positive integer query parameter
+func apiIntParam(r *http.Request, name string, def int) (int, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+// apiJSON responds with a JSON-encoded value
+func apiJSON(w http.ResponseWriter, r *http.Request, v interface{}, modified time.Time) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	serveCacheable(w, r, "api", "application/json", modified, data)
+}
+
+// apiDisplayError responds with a display error
+func apiDisplayError(w http.ResponseWriter, res wiki.DisplayError) {
+	status := http.StatusNotFound
+	if res.Status != 0 {
+		status = res.Status
+	}
+	apiError(w, status, res.Error)
+}
+
+// apiError responds with a JSON error
+func apiError(w http.ResponseWriter, status int, msg string) {
+	data, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,124 @@
+package webserver
+
+// http-cache.go - caching headers and conditional requests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cooper/quiki/wiki"
+)
+
+// Cache-Control header for each type of resource, from server.http.cache.*
+var cacheControl = map[string]string{
+	"page":     "no-cache",
+	"category": "no-cache",
+	"image":    "public, max-age=3600",
+	"static":   "public, max-age=3600",
+	"api":      "no-cache",
+}
+
+// the type of resource each template displays, for cacheControl
+var templateResources = map[string]string{
+	"page":  "page",
+	"posts": "category",
+}
+
+// cacheControlWriter adds a Cache-Control header to successful responses,
+// so that errors are not cached
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+// read server.http.cache.* options
+func setupCacheControl() error {
+	for typ := range cacheControl {
+		str, err := Conf.GetStr("server.http.cache." + typ)
+		if err != nil {
+			return err
+		}
+		if str != "" {
+			cacheControl[typ] = str
+		}
+	}
+	return nil
+}
+
+// withCacheControl returns a ResponseWriter which adds the Cache-Control
+// header for a type of resource
+func withCacheControl(w http.ResponseWriter, typ string) http.ResponseWriter {
+	if cacheControl[typ] == "" {
+		return w
+	}
+	return &cacheControlWriter{ResponseWriter: w, value: cacheControl[typ]}
+}
+
+// cacheControlHandler wraps a handler to add the Cache-Control header for
+// a type of resource
+func cacheControlHandler(typ string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(withCacheControl(w, typ), r)
+	})
+}
+
+func (cw *cacheControlWriter) WriteHeader(status int) {
+	if !cw.wroteHeader && status < http.StatusBadRequest {
+		cw.Header().Set("Cache-Control", cw.value)
+	}
+	cw.wroteHeader = true
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheControlWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// serveCacheable serves generated content for a type of resource with
+// Last-Modified and ETag headers, answering conditional requests with
+// 304 Not Modified
+func serveCacheable(w http.ResponseWriter, r *http.Request, typ, contentType string, modified time.Time, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+
+	// this is an error page, and the status was already written
+	if r.Context().Value(lowLevelErrorKey{}) != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(int64(len(body)), 10))
+		w.Write(body)
+		return
+	}
+
+	// the ETag is weak since the content may be compressed
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `W/"`+hex.EncodeToString(sum[:16])+`"`)
+
+	w = withCacheControl(w, typ)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
+
+// returns the time or zero if nil
+func modTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// returns when category posts were last modified, which is when the
+// category or any of the pages were
+func postsModified(res wiki.DisplayCategoryPosts) time.Time {
+	modified := modTime(res.Modified)
+	for _, page := range res.Pages {
+		if pageMod := modTime(page.Modified); pageMod.After(modified) {
+			modified = pageMod
+		}
+	}
+	return modified
+}
@@ -0,0 +1,139 @@
+package webserver
+
+// http-compress.go - response compression
+//
+// only gzip is supported, since the standard library has no brotli encoder
+//
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// responses smaller than this are not worth compressing
+const minCompressSize = 1024
+
+// content types which are compressed
+var compressTypes = []string{
+	"text/html",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// compressWriter compresses the response if the client accepts it and the
+// content type is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	accept      bool         // client accepts gzip
+	gz          *gzip.Writer // non-nil if compressing
+	wroteHeader bool
+}
+
+// compressHandler wraps a handler to compress its responses
+func compressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{
+			ResponseWriter: w,
+			accept:         acceptsGzip(r.Header.Get("Accept-Encoding")),
+		}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	// only compress full responses. partial content and 304 Not Modified
+	// refer to the uncompressed content
+	h := cw.Header()
+	compress := compressible(h.Get("Content-Type"))
+	if compress || status == http.StatusNotModified {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress && cw.accept && status == http.StatusOK && h.Get("Content-Encoding") == "" && !tooSmall(h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		cw.gz = gzipPool.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// finish compressing, if applicable
+func (cw *compressWriter) close() {
+	if cw.gz == nil {
+		return
+	}
+	cw.gz.Close()
+	gzipPool.Put(cw.gz)
+	cw.gz = nil
+}
+
+// returns whether an Accept-Encoding header includes gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		// gzip;q=0 means not acceptable
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// returns whether a content type is worth compressing
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, typ := range compressTypes {
+		if contentType == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// returns whether the response is known to be too small to compress
+func tooSmall(h http.Header) bool {
+	size, err := strconv.Atoi(h.Get("Content-Length"))
+	return err == nil && size < minCompressSize
+}
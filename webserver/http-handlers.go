@@ -4,11 +4,13 @@ package webserver
 
 import (
 	"bytes"
+	"context"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cooper/quiki/wiki"
 )
@@ -21,7 +23,7 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 	for _, w := range Wikis {
 
 		// wrong root
-		wikiRoot := w.Opt.Root.Wiki
+		wikiRoot := w.Opt().Root.Wiki
 		if r.URL.Path != wikiRoot && !strings.HasPrefix(r.URL.Path, wikiRoot+"/") {
 			continue
 		}
@@ -47,15 +49,15 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 	if delayedWiki != nil {
 
 		// show the main page for the delayed wiki
-		wikiRoot := delayedWiki.Opt.Root.Wiki
-		mainPage := delayedWiki.Opt.MainPage
+		wikiRoot := delayedWiki.Opt().Root.Wiki
+		mainPage := delayedWiki.Opt().MainPage
 		if mainPage != "" && (r.URL.Path == wikiRoot || r.URL.Path == wikiRoot+"/") {
 
 			// main page redirect is enabled
-			if delayedWiki.Opt.MainRedirect {
+			if delayedWiki.Opt().MainRedirect {
 				http.Redirect(
 					w, r,
-					delayedWiki.Opt.Root.Page+
+					delayedWiki.Opt().Root.Page+
 						"/"+mainPage,
 					http.StatusMovedPermanently,
 				)
@@ -68,7 +70,7 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// if the page root is blank, this may be a page
-		if delayedWiki.Opt.Root.Page == "" {
+		if delayedWiki.Opt().Root.Page == "" {
 			relPath := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, wikiRoot), "/")
 			handlePage(delayedWiki, relPath, w, r)
 			return
@@ -110,16 +112,66 @@ func handleCategoryPosts(wi *WikiInfo, relPath string, w http.ResponseWriter, r
 	handleResponse(wi, wi.DisplayCategoryPosts(catName, pageN), w, r)
 }
 
+// search request
+func handleSearch(wi *WikiInfo, relPath string, w http.ResponseWriter, r *http.Request) {
+
+	// the template doesn't support search
+	if wi.template.template.Lookup("search.tpl") == nil {
+		handleError(wi, "Search is not available.", w, r)
+		return
+	}
+
+	// query is ?q= or the path relative to the search root
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		query = relPath
+	}
+
+	// do the search
+	results, err := wi.Search(query, wiki.SearchOpts{Limit: 100})
+	if err != nil {
+		handleError(wi, err, w, r)
+		return
+	}
+
+	// create template page
+	page := wikiPageWith(wi)
+	page.Name = "search"
+	page.Title = "Search"
+	page.SearchQuery = query
+
+	// add each result as a wikiPage, with the snippet as content
+	for _, res := range results {
+		result := wikiPageWith(wi)
+		result.HTMLContent = template.HTML(res.Snippet)
+		result.File = res.File
+		result.Name = res.FileNE
+		result.Title = res.Title
+		result.Description = res.Description
+		result.Author = res.Author
+		page.Pages = append(page.Pages, result)
+	}
+
+	renderTemplate(wi, w, r, "search", page, time.Time{})
+}
+
 func handleResponse(wi *WikiInfo, res interface{}, w http.ResponseWriter, r *http.Request) {
 	switch res := res.(type) {
 
 	// page content
 	case wiki.DisplayPage:
-		renderTemplate(wi, w, "page", wikiPageFromRes(wi, res))
+		renderTemplate(wi, w, r, "page", wikiPageFromRes(wi, res), modTime(res.Modified))
 
 	// image content
 	case wiki.DisplayImage:
-		http.ServeFile(w, r, res.Path)
+		w.Header().Set("Content-Type", res.Mime)
+
+		// don't run scripts embedded in SVGs
+		if res.ImageType == "svg" {
+			w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+		}
+
+		http.ServeFile(withCacheControl(w, "image"), r, res.Path)
 
 	// posts
 	case wiki.DisplayCategoryPosts:
@@ -138,7 +190,7 @@ func handleResponse(wi *WikiInfo, res interface{}, w http.ResponseWriter, r *htt
 			page.Pages = append(page.Pages, wikiPageFromRes(wi, dispPage))
 		}
 
-		renderTemplate(wi, w, "posts", page)
+		renderTemplate(wi, w, r, "posts", page, postsModified(res))
 
 	// error
 	case wiki.DisplayError:
@@ -154,10 +206,10 @@ func handleResponse(wi *WikiInfo, res interface{}, w http.ResponseWriter, r *htt
 	}
 }
 
-// this is set true when calling handlePage for the error page. this way, if an
-// error occurs when trying to display the error page, we don't infinitely loop
-// between handleError and handlePage
-var useLowLevelError bool
+// this is set in the request context when calling handlePage for the error
+// page. this way, if an error occurs when trying to display the error page,
+// we don't infinitely loop between handleError and handlePage
+type lowLevelErrorKey struct{}
 
 func handleError(wi *WikiInfo, errMaybe interface{}, w http.ResponseWriter, r *http.Request) {
 	status := http.StatusNotFound
@@ -187,12 +239,11 @@ func handleError(wi *WikiInfo, errMaybe interface{}, w http.ResponseWriter, r *h
 	}
 
 	// if we have an error page for this wiki, use it
-	errorPage := wi.Opt.ErrorPage
-	if !useLowLevelError && errorPage != "" {
-		useLowLevelError = true
+	errorPage := wi.Opt().ErrorPage
+	if errorPage != "" && r.Context().Value(lowLevelErrorKey{}) == nil {
 		w.WriteHeader(status)
-		handlePage(wi, errorPage, w, r)
-		useLowLevelError = false
+		ctx := context.WithValue(r.Context(), lowLevelErrorKey{}, true)
+		handlePage(wi, errorPage, w, r.WithContext(ctx))
 		return
 	}
 
@@ -215,15 +266,14 @@ func handleError(wi *WikiInfo, errMaybe interface{}, w http.ResponseWriter, r *h
 	http.Error(w, msg, status)
 }
 
-func renderTemplate(wi *WikiInfo, w http.ResponseWriter, templateName string, dot wikiPage) {
+func renderTemplate(wi *WikiInfo, w http.ResponseWriter, r *http.Request, templateName string, dot wikiPage, modified time.Time) {
 	var buf bytes.Buffer
 	err := wi.template.template.ExecuteTemplate(&buf, templateName+".tpl", dot)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Length", strconv.FormatInt(int64(buf.Len()), 10))
-	w.Write(buf.Bytes())
+	serveCacheable(w, r, templateResources[templateName], "text/html; charset=utf-8", modified, buf.Bytes())
 }
 
 func wikiPageFromRes(wi *WikiInfo, res wiki.DisplayPage) wikiPage {
@@ -236,6 +286,7 @@ func wikiPageFromRes(wi *WikiInfo, res wiki.DisplayPage) wikiPage {
 	page.Description = res.Description
 	page.Keywords = res.Keywords
 	page.Author = res.Author
+	page.References = res.References
 	return page
 }
 
@@ -243,10 +294,11 @@ func wikiPageWith(wi *WikiInfo) wikiPage {
 	return wikiPage{
 		WikiTitle:  wi.Title,
 		WikiLogo:   wi.Logo,
-		WikiRoot:   wi.Opt.Root.Wiki,
-		Root:       wi.Opt.Root,
+		WikiRoot:   wi.Opt().Root.Wiki,
+		Root:       wi.Opt().Root,
 		StaticRoot: wi.template.staticRoot,
-		Navigation: wi.Opt.Navigation,
-		retina:     wi.Opt.Image.Retina,
+		Navigation: wi.Opt().Navigation,
+		retina:     wi.Opt().Image.Retina,
+		wi:         wi,
 	}
 }
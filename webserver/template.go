@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cooper/quiki/wiki"
 	"github.com/cooper/quiki/wikifier"
 )
 
@@ -126,7 +127,7 @@ func loadTemplate(name, templatePath string) (wikiTemplate, error) {
 			t.staticRoot = "/tmpl/" + name
 			fileServer := http.FileServer(http.Dir(filePath))
 			pfx := t.staticRoot + "/"
-			Mux.Handle(pfx, http.StripPrefix(pfx, fileServer))
+			Mux.Handle(pfx, cacheControlHandler("static", http.StripPrefix(pfx, fileServer)))
 			log.Printf("[%s] template registered: %s", name, pfx)
 		}
 
@@ -179,14 +180,17 @@ type wikiPage struct {
 	WikiRoot    string                       // wiki HTTP root (deprecated, use Root.Wiki)
 	Root        wikifier.PageOptRoot         // all roots
 	StaticRoot  string                       // path to static resources
-	Pages       []wikiPage                   // more pages for category posts
+	Pages       []wikiPage                   // more pages for category posts or search results
 	Message     string                       // message for error page
+	SearchQuery string                       // for search, the query text
 	Navigation  []wikifier.PageOptNavigation // slice of nav items
 	PageN       int                          // for category posts, the page number (first page = 1)
 	NumPages    int                          // for category posts, the number of pages
+	References  []wikifier.Reference         // references cited on the page
 	PageCSS     template.CSS                 // css
 	HTMLContent template.HTML                // html
 	retina      []int                        // retina scales for logo
+	wi          *WikiInfo                    // wiki, for computed fields
 }
 
 func (p wikiPage) VisibleTitle() string {
@@ -199,6 +203,14 @@ func (p wikiPage) VisibleTitle() string {
 	return p.Title + " - " + p.WikiTitle
 }
 
+// Backlinks returns the pages which link to this page.
+func (p wikiPage) Backlinks() []wiki.Backlink {
+	if p.wi == nil || p.File == "" {
+		return nil
+	}
+	return p.wi.Backlinks(p.File)
+}
+
 func (p wikiPage) Scripts() []string {
 	return []string{
 		"/static/ext/mootools.min.js",
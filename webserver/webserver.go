@@ -84,6 +84,16 @@ func Configure(confFile string) {
 	dirResource = filepath.FromSlash(dirResource)
 	dirStatic := filepath.Join(dirResource, "webserver", "static")
 
+	// caching headers
+	if err = setupCacheControl(); err != nil {
+		log.Fatal(errors.Wrap(err, "setup cache control"))
+	}
+
+	// JSON API
+	if err = setupAPI(); err != nil {
+		log.Fatal(errors.Wrap(err, "setup api"))
+	}
+
 	// set up wikis
 	if err = initWikis(); err != nil {
 		log.Fatal(errors.Wrap(err, "init wikis"))
@@ -99,7 +109,11 @@ func Configure(confFile string) {
 
 	// create server with main handler
 	Mux.HandleFunc("/", handleRoot)
-	Server = &http.Server{Handler: SessMgr.LoadAndSave(Mux)}
+	handler := SessMgr.LoadAndSave(Mux)
+	if enabledByDefault("server.enable.compression") {
+		handler = compressHandler(handler)
+	}
+	Server = &http.Server{Handler: handler}
 
 	// create authenticator
 	Auth, err = authenticator.Open(filepath.Join(filepath.Dir(confFile), "quiki-auth.json"))
@@ -135,6 +149,6 @@ func setupStatic(staticPath string) error {
 		return err
 	}
 	fileServer := http.FileServer(http.Dir(staticPath))
-	Mux.Handle("/static/", http.StripPrefix("/static/", fileServer))
+	Mux.Handle("/static/", cacheControlHandler("static", http.StripPrefix("/static/", fileServer)))
 	return nil
 }
@@ -28,6 +28,13 @@ type WikiInfo struct {
 // Wikis is all wikis served by this webserver.
 var Wikis map[string]*WikiInfo
 
+// returns a boolean server option which is enabled unless it is set false
+func enabledByDefault(key string) bool {
+	val, _ := Conf.Get(key)
+	enable, ok := val.(bool)
+	return enable || !ok
+}
+
 // initialize all the wikis in the configuration
 func initWikis() error {
 
@@ -101,7 +108,7 @@ func initWikis() error {
 		// if wiki host was found in wiki config, use it ONLY when
 		// no host was specified in server config.
 		if wikiHost == "" {
-			wikiHost = w.Opt.Host.Wiki
+			wikiHost = w.Opt().Host.Wiki
 		}
 
 		// create wiki info for webserver
@@ -110,17 +117,21 @@ func initWikis() error {
 		// initialize git repsitory
 		log.Println(w.BranchNames())
 
-		// pregenerate
-		w.Pregenerate()
-
-		// monitor for changes
-		go monitor.WatchWiki(w)
-
 		// set up the wiki for webserver
 		if err := setupWiki(wi); err != nil {
 			return err
 		}
 
+		// pregenerate in the background
+		if enabledByDefault("server.enable.pregeneration") {
+			w.StartPregenerate()
+		}
+
+		// monitor for changes
+		if enabledByDefault("server.enable.monitor") {
+			go monitor.WatchWiki(w)
+		}
+
 		Wikis[wikiName] = wi
 	}
 
@@ -136,7 +147,7 @@ func initWikis() error {
 func setupWiki(wi *WikiInfo) error {
 
 	// if not configured, use default template
-	templateNameOrPath := wi.Opt.Template
+	templateNameOrPath := wi.Opt().Template
 	if templateNameOrPath == "" {
 		templateNameOrPath = "default"
 	}
@@ -160,17 +171,18 @@ func setupWiki(wi *WikiInfo) error {
 
 	// generate logo according to template
 	logoInfo := wi.template.manifest.Logo
-	logoName := wi.Opt.Logo
+	logoName := wi.Opt().Logo
 	if logoName != "" && (logoInfo.Width != 0 || logoInfo.Height != 0) {
+		wi.AllowImageSize(logoName, logoInfo.Width, logoInfo.Height)
 		si := wiki.SizedImageFromName(logoName)
 		si.Width = logoInfo.Width
 		si.Height = logoInfo.Height
 		res := wi.DisplaySizedImageGenerate(si, true)
 		switch disp := res.(type) {
 		case wiki.DisplayImage:
-			wi.Logo = wi.Opt.Root.Image + "/" + disp.File
+			wi.Logo = wi.Opt().Root.Image + "/" + disp.File
 		case wiki.DisplayRedirect:
-			wi.Logo = wi.Opt.Root.Image + "/" + disp.Redirect
+			wi.Logo = wi.Opt().Root.Image + "/" + disp.Redirect
 		default:
 			log.Printf("[%s] generate logo failed: %+v", wi.Name, res)
 		}
@@ -185,23 +197,35 @@ func setupWiki(wi *WikiInfo) error {
 	wikiRoots := []wikiHandler{
 		{
 			rootType: "page",
-			root:     wi.Opt.Root.Page,
+			root:     wi.Opt().Root.Page,
 			handler:  handlePage,
 		},
 		{
 			rootType: "image",
-			root:     wi.Opt.Root.Image,
+			root:     wi.Opt().Root.Image,
 			handler:  handleImage,
 		},
 		{
 			rootType: "category",
-			root:     wi.Opt.Root.Category,
+			root:     wi.Opt().Root.Category,
 			handler:  handleCategoryPosts,
 		},
+		{
+			rootType: "search",
+			root:     wi.Opt().Root.Search,
+			handler:  handleSearch,
+		},
 	}
 
 	// setup handlers
-	wikiRoot := wi.Opt.Root.Wiki
+	wikiRoot := wi.Opt().Root.Wiki
+	if apiEnabled {
+		wikiRoots = append(wikiRoots, wikiHandler{
+			rootType: "api",
+			root:     wikiRoot + apiRoot,
+			handler:  handleAPI,
+		})
+	}
 	for _, item := range wikiRoots {
 		rootType, root, handler := item.rootType, item.root, item.handler
 
@@ -228,7 +252,7 @@ func setupWiki(wi *WikiInfo) error {
 
 			// determine the path relative to the root
 			relPath := strings.TrimPrefix(r.URL.Path, root)
-			if relPath == "" && rootType != "wiki" {
+			if relPath == "" && rootType != "wiki" && rootType != "search" {
 				http.NotFound(w, r)
 				return
 			}
@@ -240,7 +264,7 @@ func setupWiki(wi *WikiInfo) error {
 	}
 
 	// file server
-	rootFile := wi.Opt.Root.File
+	rootFile := wi.Opt().Root.File
 	dirWiki := wi.Dir()
 	if rootFile != "" && dirWiki != "" {
 		rootFile += "/"
@@ -250,7 +274,7 @@ func setupWiki(wi *WikiInfo) error {
 	}
 
 	// store the wiki info
-	wi.Title = wi.Opt.Name
+	wi.Title = wi.Opt().Name
 	return nil
 }
 
@@ -0,0 +1,242 @@
+package wiki
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheStats describes the contents of the wiki cache.
+type CacheStats struct {
+	Pages      CacheUsage `json:"pages"`      // generated pages and search text
+	Images     CacheUsage `json:"images"`     // generated images
+	Categories CacheUsage `json:"categories"` // categories and page, image, and model metadata
+
+	// pages held in memory, which are also included in Pages
+	Memory MemoryCacheStats `json:"memory"`
+
+	// generated images which are no longer used anywhere on the wiki.
+	// these are included in Images
+	UnusedImages CacheUsage `json:"unused_images"`
+}
+
+// CacheUsage describes a number of cache files and their total size.
+type CacheUsage struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// EvictOpts describes which unused images to delete with EvictImages.
+// If both are zero, all unused images are deleted.
+type EvictOpts struct {
+
+	// delete unused images which were generated more than this long ago
+	MaxAge time.Duration
+
+	// delete unused images, oldest first, until all generated images
+	// (used or not) take up no more than this many bytes
+	MaxBytes int64
+}
+
+// a file in the image cache
+type cachedImage struct {
+	path string
+	img  SizedImage
+	fi   os.FileInfo
+	used bool
+}
+
+// PurgePage deletes the cached content and search text of a page,
+// so that it is generated again the next time it is displayed.
+func (w *Wiki) PurgePage(name string) {
+	w.purgePage(w.FindPage(name))
+}
+
+// RegeneratePage updates a page after its source file was changed or
+// deleted other than through the wiki methods, such as in a text editor.
+//
+// The page is generated again, or purged if it no longer exists. Categories
+// it belonged to are updated, including those tracking the pages it linked
+// to, so that it no longer appears where it does not belong.
+//
+func (w *Wiki) RegeneratePage(name string) {
+	page := w.FindPage(name)
+
+	// the cache still describes the old source
+	cats := w.cachedPageCategories(page)
+
+	if page.Exists() {
+		w.regeneratePage(name)
+	} else {
+		w.purgePage(page)
+	}
+	w.updateCategories(cats)
+}
+
+// PurgeImage deletes all generated versions of an image, including
+// those in other formats, so that they are generated again as needed.
+// The full-size image is not affected.
+func (w *Wiki) PurgeImage(name string) {
+	w.purgeImageCache(name)
+}
+
+// PurgeAll deletes all generated pages and images.
+//
+// Categories are not affected, since they are only updated as pages are
+// generated. Use Pregenerate to generate everything again at once.
+//
+func (w *Wiki) PurgeAll() error {
+	for _, sub := range []string{"page", "image"} {
+		if err := os.RemoveAll(filepath.Join(w.Opt().Dir.Cache, sub)); err != nil {
+			return err
+		}
+	}
+	w.pageMem.clear()
+
+	// pages are indexed again as they are generated
+	w.buildSearchIndex()
+	return nil
+}
+
+// CacheStats returns the number and size of files in the wiki cache.
+func (w *Wiki) CacheStats() CacheStats {
+	var stats CacheStats
+	stats.Pages = cacheDirUsage(filepath.Join(w.Opt().Dir.Cache, "page"))
+	stats.Categories = cacheDirUsage(filepath.Join(w.Opt().Dir.Cache, "category"))
+	meta := cacheDirUsage(filepath.Join(w.Opt().Dir.Cache, "meta"))
+	stats.Categories.Files += meta.Files
+	stats.Categories.Bytes += meta.Bytes
+	stats.Memory = w.pageMem.stats()
+	stats.Memory.MaxBytes = w.pageMemoryMax()
+	for _, c := range w.cachedImages() {
+		stats.Images.Files++
+		stats.Images.Bytes += c.fi.Size()
+		if !c.used {
+			stats.UnusedImages.Files++
+			stats.UnusedImages.Bytes += c.fi.Size()
+		}
+	}
+	return stats
+}
+
+// EvictImages deletes generated images which are no longer used anywhere
+// on the wiki, according to the options, and returns what was deleted.
+//
+// An image is unused if the original no longer exists, if it is in a
+// format which is no longer configured, or if no page displays it in its
+// dimensions and they are not otherwise allowed. These are the same rules
+// which restrict the images generated on request.
+//
+func (w *Wiki) EvictImages(opts EvictOpts) CacheUsage {
+	var evicted, total CacheUsage
+	images := w.cachedImages()
+	for _, c := range images {
+		total.Files++
+		total.Bytes += c.fi.Size()
+	}
+
+	// oldest first
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].fi.ModTime().Before(images[j].fi.ModTime())
+	})
+
+	for _, c := range images {
+		if c.used {
+			continue
+		}
+		old := opts.MaxAge != 0 && time.Since(c.fi.ModTime()) > opts.MaxAge
+		big := opts.MaxBytes != 0 && total.Bytes > opts.MaxBytes
+		all := opts.MaxAge == 0 && opts.MaxBytes == 0
+		if !old && !big && !all {
+			continue
+		}
+		w.Debug("evict image:", c.img.ScaleName())
+		if err := os.Remove(c.path); err != nil {
+			continue
+		}
+		evicted.Files++
+		evicted.Bytes += c.fi.Size()
+		total.Bytes -= c.fi.Size()
+	}
+
+	return evicted
+}
+
+// cachedImages returns all files in the image cache
+func (w *Wiki) cachedImages() []cachedImage {
+	var images []cachedImage
+	infos := make(map[string]ImageInfo)
+	dir := filepath.Join(w.Opt().Dir.Cache, "image")
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+
+		// skip images being written
+		if strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		img := SizedImageFromName(filepath.ToSlash(rel))
+		images = append(images, cachedImage{
+			path: path,
+			img:  img,
+			fi:   fi,
+			used: w.cachedImageUsed(img, infos),
+		})
+		return nil
+	})
+	return images
+}
+
+// cachedImageUsed returns whether a generated image is still used, according
+// to the same rules as on-demand generation. infos caches ImageInfo
+func (w *Wiki) cachedImageUsed(img SizedImage, infos map[string]ImageInfo) bool {
+
+	// the original no longer exists
+	_, srcExt, _, err := w.findImageSource(img)
+	if err != nil {
+		return false
+	}
+
+	// the format is no longer used
+	if srcExt != img.Ext && !w.imageFormatAllowed(img.Ext) {
+		return false
+	}
+
+	// full-size conversion
+	if img.Width == 0 && img.Height == 0 {
+		return true
+	}
+
+	src := img
+	src.Ext = srcExt
+	name := src.FullSizeName()
+	info, ok := infos[name]
+	if !ok {
+		info = w.ImageInfo(name)
+		infos[name] = info
+	}
+	sizes, ok := w.imageAllowedSizes(img, info)
+	return ok && imageSizeAllowed(img, sizes, w.Opt().Image.Retina)
+}
+
+// returns the number and size of all files in a directory
+func cacheDirUsage(dir string) CacheUsage {
+	var usage CacheUsage
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		usage.Files++
+		usage.Bytes += fi.Size()
+		return nil
+	})
+	return usage
+}
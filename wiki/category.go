@@ -7,6 +7,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Songmu/go-httpdate"
@@ -107,6 +108,10 @@ type CategoryEntry struct {
 	// always be even, since each occurrence of the image produces two (width and then height)
 	Dimensions [][]int `json:"dimensions,omitempty"`
 
+	// for CategoryTypeImage, an array of crops and fits applied to the image
+	// on this page, in any of the dimensions
+	Transforms []wikifier.ImageTransform `json:"transforms,omitempty"`
+
 	// for CategoryTypePage, an array of line numbers on which the tracked page is
 	// referenced on the page described by this entry
 	Lines []int `json:"lines,omitempty"`
@@ -139,6 +144,31 @@ func (w *Wiki) GetCategory(name string) *Category {
 
 // GetSpecialCategory loads or creates a special category given the type.
 func (w *Wiki) GetSpecialCategory(name string, typ CategoryType) *Category {
+	lock := w.categoryLock(name, typ)
+	lock.Lock()
+	defer lock.Unlock()
+	return w.loadCategory(name, typ)
+}
+
+// changeCategory loads a category and calls fn to change it while holding
+// its lock, so that changes from other goroutines are not lost
+func (w *Wiki) changeCategory(name string, typ CategoryType, fn func(cat *Category)) *Category {
+	lock := w.categoryLock(name, typ)
+	lock.Lock()
+	defer lock.Unlock()
+	cat := w.loadCategory(name, typ)
+	fn(cat)
+	return cat
+}
+
+// categoryLock returns the mutex for reading or writing a category
+func (w *Wiki) categoryLock(name string, typ CategoryType) *sync.Mutex {
+	return w.categoryLocks.get(string(typ) + ":" + wikifier.CategoryNameNE(name))
+}
+
+// loadCategory is like GetSpecialCategory, except the caller must hold
+// the category lock
+func (w *Wiki) loadCategory(name string, typ CategoryType) *Category {
 	name = wikifier.CategoryNameNE(name)
 	path := w.pathForCategory(name, typ, true)
 	metaPath := w.Dir("topics", name+".cat")
@@ -260,15 +290,39 @@ func (w *Wiki) readCategoryMeta(metaPath string, cat *Category) {
 	cat.write(w)
 }
 
+// ReloadCategory reads a category's metadata from the topics directory
+// again, or forgets it if the metadata file no longer exists.
+func (w *Wiki) ReloadCategory(name string) {
+
+	w.changeCategory(name, "", func(cat *Category) {
+
+		// metadata is read when loading if it changed
+		metaPath := w.Dir("topics", cat.Name+".cat")
+		if _, err := os.Lstat(metaPath); err == nil || !cat.Exists() {
+			return
+		}
+
+		// metadata was deleted, and the category may be empty without it
+		if cat.shouldPurge(w) {
+			os.Remove(cat.Path)
+			return
+		}
+		cat.Title = ""
+		cat.PerPage = 0
+		cat.Asof = nil
+		cat.write(w)
+	})
+}
+
 // AddPage adds a page to a category.
 //
 // If the page already belongs and any information has changed, the category is updated.
 // If force is true,
 func (cat *Category) AddPage(w *Wiki, page *wikifier.Page) {
-	cat.addPageExtras(w, page, nil, nil)
+	cat.addPageExtras(w, page, nil, nil, nil)
 }
 
-func (cat *Category) addPageExtras(w *Wiki, pageMaybe *wikifier.Page, dimensions [][]int, lines []int) {
+func (cat *Category) addPageExtras(w *Wiki, pageMaybe *wikifier.Page, dimensions [][]int, transforms []wikifier.ImageTransform, lines []int) {
 
 	// update existing info
 	cat.update(w)
@@ -305,6 +359,7 @@ func (cat *Category) addPageExtras(w *Wiki, pageMaybe *wikifier.Page, dimensions
 			Asof:       &now,
 			PageInfo:   pageMaybe.Info(),
 			Dimensions: dimensions,
+			Transforms: transforms,
 			Lines:      lines,
 		}
 	}
@@ -329,8 +384,8 @@ func (cat *Category) write(w *Wiki) {
 		return
 	}
 
-	// write
-	ioutil.WriteFile(cat.Path, jsonData, 0666)
+	// write all at once, since it may be read at the same time
+	writeFileAtomic(cat.Path, jsonData)
 }
 
 func (cat *Category) update(w *Wiki) {
@@ -362,7 +417,7 @@ func (cat *Category) update(w *Wiki) {
 			// let's create a page that only reads variables
 			// FIXME: will images, models, etc. be set?
 			page := w.FindPage(pageName)
-			page.VarsOnly = true
+			page.VarsOnly = cat.Type != CategoryTypePage
 
 			// parse variables. if errors occur, leave as-is
 			if err := page.Parse(); err != nil {
@@ -370,6 +425,11 @@ func (cat *Category) update(w *Wiki) {
 				continue
 			}
 
+			// page links are found while generating, so we need the HTML
+			if cat.Type == CategoryTypePage {
+				page.HTML()
+			}
+
 			// at this point, we're either removing or updating page info
 			changed = true
 
@@ -378,7 +438,9 @@ func (cat *Category) update(w *Wiki) {
 
 			// for page links, check if the page still references the other
 			case CategoryTypePage:
-				_, stillMember = page.PageLinks[wikifier.CategoryNameNE(cat.Name)]
+				var lines []int
+				lines, stillMember = page.PageLinks[wikifier.CategoryNameNE(cat.Name)]
+				entry.Lines = lines
 
 			// for images, check if the page still references the image
 			case CategoryTypeImage:
@@ -476,7 +538,7 @@ func (cat *Category) shouldPurge(w *Wiki) bool {
 	return !preserve
 }
 
-func (cat *Category) addImage(w *Wiki, imageName string, pageMaybe *wikifier.Page, dimensionsMaybe [][]int) {
+func (cat *Category) addImage(w *Wiki, imageName string, pageMaybe *wikifier.Page, dimensionsMaybe [][]int, transformsMaybe []wikifier.ImageTransform) {
 
 	// what !!
 	if cat.Type != CategoryTypeImage {
@@ -497,7 +559,7 @@ func (cat *Category) addImage(w *Wiki, imageName string, pageMaybe *wikifier.Pag
 		}
 	}
 
-	cat.addPageExtras(w, pageMaybe, dimensionsMaybe, nil)
+	cat.addPageExtras(w, pageMaybe, dimensionsMaybe, transformsMaybe, nil)
 }
 
 // cat_check_page
@@ -505,48 +567,57 @@ func (w *Wiki) updatePageCategories(page *wikifier.Page) {
 
 	// page metadata category
 	info := page.Info()
-	pageCat := w.GetSpecialCategory(page.NameNE(), CategoryTypePage)
-	pageCat.PageInfo = &info
-	pageCat.Preserve = true // keep until page no longer exists
-	pageCat.addPageExtras(w, nil, nil, nil)
+	w.changeCategory(page.NameNE(), CategoryTypePage, func(pageCat *Category) {
+		pageCat.PageInfo = &info
+		pageCat.Preserve = true // keep until page no longer exists
+		pageCat.addPageExtras(w, nil, nil, nil, nil)
+	})
 
 	// actual categories
 	for _, name := range page.Categories() {
-		w.GetCategory(name).AddPage(w, page)
+		w.changeCategory(name, "", func(cat *Category) {
+			cat.AddPage(w, page)
+		})
 	}
 
 	// image tracking categories
 	for imageName, dimensions := range page.Images {
-		imageCat := w.GetSpecialCategory(imageName, CategoryTypeImage)
-		imageCat.addImage(w, imageName, page, dimensions)
+		imageName, dimensions := imageName, dimensions
+		w.changeCategory(imageName, CategoryTypeImage, func(imageCat *Category) {
+			imageCat.addImage(w, imageName, page, dimensions, page.Transforms[imageName])
+		})
 	}
 
 	// page tracking categories
 	for pageName, lines := range page.PageLinks {
 		// note: if the page exists, the category should already exist also.
 		// however, we track references to not-yet-existent pages as well
-		pageCat := w.GetSpecialCategory(pageName, CategoryTypePage)
-		pageCat.Preserve = true // keep until there are no more references
-		pageCat.addPageExtras(w, page, nil, lines)
+		lines := lines
+		w.changeCategory(pageName, CategoryTypePage, func(pageCat *Category) {
+			pageCat.Preserve = true // keep until there are no more references
+			pageCat.addPageExtras(w, page, nil, nil, lines)
+		})
 	}
 
 	// model tracking categories
 	for modelName, modelInfo := range page.Models {
-		modelCat := w.GetSpecialCategory(modelName, CategoryTypeModel)
-		modelCat.Preserve = true // keep until there are no more references
-		modelCat.ModelInfo = &modelInfo
-		modelCat.AddPage(w, page)
+		modelInfo := modelInfo
+		w.changeCategory(modelName, CategoryTypeModel, func(modelCat *Category) {
+			modelCat.Preserve = true // keep until there are no more references
+			modelCat.ModelInfo = &modelInfo
+			modelCat.AddPage(w, page)
+		})
 	}
 }
 
 // DisplayCategoryPosts returns the display result for a category.
 func (w *Wiki) DisplayCategoryPosts(catName string, pageN int) interface{} {
-	cat := w.GetCategory(catName)
-	catName = cat.Name
-
 	// update info
 	// note: this needs to be before existence check because it may purge
-	cat.update(w)
+	cat := w.changeCategory(catName, "", func(cat *Category) {
+		cat.update(w)
+	})
+	catName = cat.Name
 
 	// category does not exist
 	if !cat.Exists() {
@@ -586,7 +657,7 @@ func (w *Wiki) DisplayCategoryPosts(catName string, pageN int) interface{} {
 	// decide on the limit of cats per page
 	limit := cat.PerPage // try local option
 	if limit == 0 {
-		limit = w.Opt.Category.PerPage // fall back to wiki option
+		limit = w.Opt().Category.PerPage // fall back to wiki option
 	}
 
 	// determine how many pages of pages we're gonna need
@@ -1,6 +1,8 @@
 package wiki
 
 import (
+	"math"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -12,6 +14,7 @@ var defaultWikiOpt = wikifier.PageOpt{
 	Page: wikifier.PageOptPage{
 		EnableTitle: true,
 		EnableCache: true,
+		CacheMemory: 32,
 		Code: wikifier.PageOptCode{
 			Style: "monokailight",
 		},
@@ -29,12 +32,17 @@ var defaultWikiOpt = wikifier.PageOpt{
 		Image:    "/images",
 		Category: "/topic",
 		File:     "", // (i.e., disabled)
+		Search:   "/search",
 	},
 	Image: wikifier.PageOptImage{
-		Retina:     []int{2, 3},
-		SizeMethod: "server",
-		Calc:       defaultImageCalc,
-		Sizer:      defaultImageSizer,
+		Retina:         []int{2, 3},
+		SizeMethod:     "server",
+		Calc:           defaultImageCalcPlain,
+		Sizer:          defaultImageSizerPlain,
+		Quality:        100,
+		UploadMaxSize:  10,
+		TransformCalc:  defaultImageCalc,
+		TransformSizer: defaultImageSizer,
 	},
 	Category: wikifier.PageOptCategory{
 		PerPage: 5,
@@ -54,7 +62,46 @@ var defaultWikiOpt = wikifier.PageOpt{
 	},
 }
 
-func (w *Wiki) readConfig(file string) error {
+// ReloadConfig reads the configuration file again, replacing the wiki
+// options with the new ones. If the configuration is invalid, an error is
+// returned and the current options are kept.
+//
+// Cached pages are regenerated as they are displayed, since they depend on
+// the configuration.
+//
+func (w *Wiki) ReloadConfig() error {
+
+	// load the new configuration from the defaults. pages being generated
+	// keep using the old options, so they are replaced rather than changed
+	opt := defaultWikiOpt
+	opt.Dir.Wiki = w.Opt().Dir.Wiki
+	if err := w.readConfig(w.ConfigFile, &opt); err != nil {
+		return err
+	}
+	w.opt.Store(&opt)
+
+	// pages in memory are not checked for a changed configuration
+	// every time they are displayed
+	w.pageMem.clear()
+
+	return nil
+}
+
+// Opt returns the wiki options.
+//
+// The options are shared with pages and must not be modified. ReloadConfig
+// replaces them, so the result may differ from one call to the next.
+//
+// This replaces the Opt field of earlier versions, which could not be
+// reloaded safely while the wiki was in use. Code which used w.Opt.X must
+// change to w.Opt().X.
+//
+func (w *Wiki) Opt() *wikifier.PageOpt {
+	return w.opt.Load().(*wikifier.PageOpt)
+}
+
+// readConfig reads the configuration file into opt
+func (w *Wiki) readConfig(file string, opt *wikifier.PageOpt) error {
 	// create a Page for the configuration file
 	// only compute the variables
 	confPage := wikifier.NewPage(file)
@@ -62,7 +109,7 @@ func (w *Wiki) readConfig(file string) error {
 
 	// set this variable for use in the config
 	// consider: is this needed anymore?
-	confPage.Set("dir.wiki", w.Opt.Dir.Wiki)
+	confPage.Set("dir.wiki", opt.Dir.Wiki)
 
 	// parse the config
 	if err := confPage.Parse(); err != nil {
@@ -70,17 +117,27 @@ func (w *Wiki) readConfig(file string) error {
 	}
 
 	// convert the config to wikifier.PageOpt
-	if err := wikifier.InjectPageOpt(confPage, &w.Opt); err != nil {
+	if err := wikifier.InjectPageOpt(confPage, opt); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func defaultImageCalc(name string, width, height int, page *wikifier.Page) (int, int, bool) {
+// the default Calc and Sizer, for callers which do not pass a transform
+func defaultImageCalcPlain(name string, width, height int, page *wikifier.Page) (int, int, bool) {
+	return defaultImageCalc(name, width, height, wikifier.ImageTransform{}, page)
+}
+
+func defaultImageSizerPlain(name string, width, height int, page *wikifier.Page) string {
+	return defaultImageSizer(name, width, height, wikifier.ImageTransform{}, page)
+}
+
+func defaultImageCalc(name string, width, height int, t wikifier.ImageTransform, page *wikifier.Page) (int, int, bool) {
+	cropped := len(t.Crop) == 4
 
 	// requesting 0x0 is same as requesting full-size
-	if width == 0 && height == 0 {
+	if width == 0 && height == 0 && !cropped {
 		return 0, 0, true
 	}
 
@@ -93,31 +150,69 @@ func defaultImageCalc(name string, width, height int, page *wikifier.Page) (int,
 	}
 
 	// requesting single full-size dimension is same as requesting full-size
-	if (width == bigW && height == 0) || (height == bigH && width == 0) {
+	if !cropped && ((width == bigW && height == 0) || (height == bigH && width == 0)) {
 		return 0, 0, true
 	}
 
-	// determine missing dimension
-	width, height = calculateImageDimensions(bigW, bigH, width, height)
+	// a cropped image has the dimensions of the crop
+	if cropped {
+		crop := cropRect(t.Crop, bigW, bigH)
+		bigW, bigH = crop.Dx(), crop.Dy()
+	}
+
+	// determine missing dimension, or fit into both
+	switch {
+	case width == 0 && height == 0:
+		width, height = bigW, bigH
+	case t.Fit == "contain" && width != 0 && height != 0:
+		scale := math.Min(float64(width)/float64(bigW), float64(height)/float64(bigH))
+		width = int(math.Max(1, math.Floor(float64(bigW)*scale+0.5)))
+		height = int(math.Max(1, math.Floor(float64(bigH)*scale+0.5)))
+	default:
+		width, height = calculateImageDimensions(bigW, bigH, width, height)
+	}
 
 	// also pregenerate the image maybe
 	w, ok := page.Wiki.(*Wiki)
-	if ok && w.pregenerating {
-		sized := SizedImageFromName(name)
-		sized.Width = width
-		sized.Height = height
+	if ok && w.pregen.generating(page.Name()) {
+		sized := sizedImageWith(name, width, height, t)
+		ext := sized.Ext
+		sized.Ext = outputImageExt(page.Opt.Image.Type, ext)
 		w.Debug("pregen image:", sized.ScaleName())
-		w.DisplaySizedImageGenerate(sized, true)
+		w.pregenerateImage(sized)
+
+		// also each format offered with <picture>
+		out := sized.Ext
+		for _, format := range wikifier.OfferedImageFormats(page.Opt, sized.ScaleName()) {
+			if imageTypes[out].imageType == format || outputImageExt(format, ext) != format {
+				continue
+			}
+			sized.Ext = format
+			w.Debug("pregen image:", sized.ScaleName())
+			w.pregenerateImage(sized)
+		}
 	}
 
 	return width, height, false
 }
 
-func defaultImageSizer(name string, width, height int, page *wikifier.Page) string {
+func defaultImageSizer(name string, width, height int, t wikifier.ImageTransform, page *wikifier.Page) string {
+	si := sizedImageWith(name, width, height, t)
+	si.Ext = outputImageExt(page.Opt.Image.Type, si.Ext)
+	return page.Opt.Root.Image + "/" + si.TrueName()
+}
+
+// sizedImageWith returns a SizedImage with dimensions and transform.
+// contain and fill are determined by the dimensions, so only cover is kept
+func sizedImageWith(name string, width, height int, t wikifier.ImageTransform) SizedImage {
 	si := SizedImageFromName(name)
 	si.Width = width
 	si.Height = height
-	return page.Opt.Root.Image + "/" + si.TrueName()
+	si.ImageTransform = t
+	if t.Fit != "cover" || width == 0 || height == 0 {
+		si.Fit = ""
+	}
+	return si
 }
 
 func linkPageExists(page *wikifier.Page, o *wikifier.PageOptLinkOpts) {
@@ -164,7 +259,11 @@ func linkCategoryExists(page *wikifier.Page, o *wikifier.PageOptLinkOpts) {
 		return
 	}
 	catName := wikifier.CategoryName(*o.DisplayDefault)
-	*o.Ok = w.GetCategory(catName).Exists()
+
+	// check the file directly, since this happens while generating pages
+	// and a category may be locked while it is updated
+	_, err := os.Lstat(w.pathForCategory(catName, "", false))
+	*o.Ok = err == nil
 	if !*o.Ok {
 		pageWarn(page, "Category target '"+wikifier.CategoryNameNE(catName)+"' does not exist", o.Pos)
 	}
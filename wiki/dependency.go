@@ -0,0 +1,91 @@
+package wiki
+
+import (
+	"os"
+	"time"
+
+	"github.com/cooper/quiki/wikifier"
+)
+
+// pageDependency is something a generated page depends on.
+// if it is created, deleted, or modified after the page is generated,
+// the page is out of date
+type pageDependency struct {
+	Type   CategoryType `json:"type"`             // CategoryTypePage, CategoryTypeImage, or CategoryTypeModel
+	Name   string       `json:"name"`             // page, image, or model name
+	Exists bool         `json:"exists,omitempty"` // true if it existed when the page was generated
+}
+
+// PurgeDependents purges the cached content and search text of every page
+// which depends on a page, image, or model, so that they are generated again
+// with its changes.
+//
+// Pages depend on the pages they link to, the images they display, and the
+// models they use, including those which do not exist yet. These are found
+// using the special categories of the given type.
+//
+// This is done automatically when content is changed using the wiki methods.
+// Pages are also checked for changed dependencies each time they are served
+// from the cache, so calling this is only necessary to regenerate pages ahead
+// of time.
+//
+func (w *Wiki) PurgeDependents(name string, typ CategoryType) {
+	cat := w.GetSpecialCategory(name, typ)
+	if !cat.Exists() {
+		return
+	}
+	for pageName := range cat.Pages {
+		w.Debugf("purge dependent: %s depends on %s %s", pageName, typ, name)
+		w.purgePage(w.FindPage(pageName))
+	}
+}
+
+// pageDependencies returns the pages, images, and models a page uses.
+// the page must have been generated
+func (w *Wiki) pageDependencies(page *wikifier.Page) []pageDependency {
+	var deps []pageDependency
+	for name := range page.PageLinks {
+		deps = append(deps, pageDependency{CategoryTypePage, name, w.FindPage(name).Exists()})
+	}
+	for name := range page.Images {
+		_, err := os.Stat(w.pathForImage(name))
+		deps = append(deps, pageDependency{CategoryTypeImage, name, err == nil})
+	}
+	for name := range page.Models {
+		_, err := os.Stat(w.pathForModel(name))
+		deps = append(deps, pageDependency{CategoryTypeModel, name, err == nil})
+	}
+	return deps
+}
+
+// dependencyChanged returns whether the configuration or any of a page's
+// dependencies changed since it was generated at the given time
+func (w *Wiki) dependencyChanged(deps []pageDependency, generated time.Time) bool {
+
+	// the configuration applies to every page
+	if fi, err := os.Stat(w.ConfigFile); err == nil && fi.ModTime().After(generated) {
+		return true
+	}
+
+	for _, dep := range deps {
+
+		// linked pages only matter if they are created or deleted
+		if dep.Type == CategoryTypePage {
+			if w.FindPage(dep.Name).Exists() != dep.Exists {
+				return true
+			}
+			continue
+		}
+
+		path := w.pathForImage(dep.Name)
+		if dep.Type == CategoryTypeModel {
+			path = w.pathForModel(dep.Name)
+		}
+		fi, err := os.Stat(path)
+		if (err == nil) != dep.Exists || (err == nil && fi.ModTime().After(generated)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -42,7 +42,7 @@ func (w *Wiki) DisplayFile(path string) interface{} {
 	if relPath == "" {
 		return DisplayError{
 			Error:         "Bad filepath",
-			DetailedError: "File '" + path + "' cannot be made relative to '" + w.Opt.Dir.Wiki + "'",
+			DetailedError: "File '" + path + "' cannot be made relative to '" + w.Opt().Dir.Wiki + "'",
 		}
 	}
 
@@ -148,12 +148,12 @@ func makeRelPath(absPath, base string) string {
 }
 
 func (w *Wiki) allPageFiles() []string {
-	files, _ := wikifier.UniqueFilesInDir(w.Opt.Dir.Page, []string{"page", "md"}, false)
+	files, _ := wikifier.UniqueFilesInDir(w.Opt().Dir.Page, pageExtensions, false)
 	return files
 }
 
 func (w *Wiki) allCategoryFiles(catType CategoryType) []string {
-	dir := w.Opt.Dir.Category
+	dir := w.Opt().Dir.Category
 	if catType != "" {
 		dir = w.Dir("cache", "meta", string(catType))
 	}
@@ -162,12 +162,12 @@ func (w *Wiki) allCategoryFiles(catType CategoryType) []string {
 }
 
 func (w *Wiki) allModelFiles() []string {
-	files, _ := wikifier.UniqueFilesInDir(w.Opt.Dir.Model, []string{"model"}, false)
+	files, _ := wikifier.UniqueFilesInDir(w.Opt().Dir.Model, modelExtensions, false)
 	return files
 }
 
 func (w *Wiki) allImageFiles() []string {
-	files, _ := wikifier.UniqueFilesInDir(w.Opt.Dir.Image, []string{"png", "jpg", "jpeg"}, false)
+	files, _ := wikifier.UniqueFilesInDir(w.Opt().Dir.Image, imageExtensions, false)
 	return files
 }
 
@@ -176,12 +176,12 @@ func (w *Wiki) pathForPage(pageName string) string {
 
 	// try lowercased version first (quiki style)
 	lcPageName := filepath.FromSlash(wikifier.PageName(pageName))
-	path, _ := filepath.Abs(filepath.Join(w.Opt.Dir.Page, lcPageName))
+	path, _ := filepath.Abs(filepath.Join(w.Opt().Dir.Page, lcPageName))
 
 	// it doesn't exist; try non-lowercased version (markdown/etc)
 	if _, err := os.Stat(path); err != nil {
 		normalPageName := filepath.FromSlash(wikifier.PageName(pageName))
-		normalPath, _ := filepath.Abs(filepath.Join(w.Opt.Dir.Page, normalPageName))
+		normalPath, _ := filepath.Abs(filepath.Join(w.Opt().Dir.Page, normalPageName))
 		if _, err := os.Stat(normalPath); err == nil {
 			return normalPath
 		}
@@ -198,9 +198,9 @@ func (w *Wiki) pathForCategory(catName string, catType CategoryType, createOK bo
 	// determine base dir
 	var dir string
 	if catType != "" {
-		dir = filepath.Join(w.Opt.Dir.Cache, "meta", string(catType))
+		dir = filepath.Join(w.Opt().Dir.Cache, "meta", string(catType))
 	} else {
-		dir = filepath.Join(w.Opt.Dir.Cache, "category")
+		dir = filepath.Join(w.Opt().Dir.Cache, "category")
 	}
 
 	// make subdirs
@@ -214,14 +214,14 @@ func (w *Wiki) pathForCategory(catName string, catType CategoryType, createOK bo
 
 // pathForImage returns the absolute path for an image.
 func (w *Wiki) pathForImage(imageName string) string {
-	path, _ := filepath.Abs(filepath.Join(w.Opt.Dir.Image, filepath.FromSlash(imageName)))
+	path, _ := filepath.Abs(filepath.Join(w.Opt().Dir.Image, filepath.FromSlash(imageName)))
 	return path
 }
 
 // pathForModel returns the absolute path for a model.
 func (w *Wiki) pathForModel(modelName string) string {
 	modelName = wikifier.PageNameExt(modelName, ".model")
-	path, _ := filepath.Abs(filepath.Join(w.Opt.Dir.Model, filepath.FromSlash(modelName)))
+	path, _ := filepath.Abs(filepath.Join(w.Opt().Dir.Model, filepath.FromSlash(modelName)))
 	return path
 }
 
@@ -231,7 +231,7 @@ func (w *Wiki) pathForModel(modelName string) string {
 // Optional path components can be passed as arguments to be joined
 // with the wiki root by the path separator.
 func (w *Wiki) Dir(dirs ...string) string {
-	wikiAbs, _ := filepath.Abs(w.Opt.Dir.Wiki)
+	wikiAbs, _ := filepath.Abs(w.Opt().Dir.Wiki)
 	return filepath.Join(append([]string{wikiAbs}, dirs...)...)
 }
 
@@ -0,0 +1,379 @@
+package wiki
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	httpdate "github.com/Songmu/go-httpdate"
+	"github.com/cooper/go-git/v4"
+	"github.com/cooper/go-git/v4/plumbing"
+	"github.com/cooper/go-git/v4/plumbing/object"
+	"github.com/cooper/go-git/v4/utils/diff"
+	"github.com/cooper/quiki/wikifier"
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// RevisionInfo contains information about a specific revision.
+type RevisionInfo struct {
+	Id      string     `json:"id"`              // revision hash
+	Author  string     `json:"author"`          // author's name
+	Email   string     `json:"email,omitempty"` // author's email address
+	Date    *time.Time `json:"date"`            // time of the revision
+	Message string     `json:"message"`         // commit message
+}
+
+// DiffLineType describes a line in a diff.
+type DiffLineType string
+
+const (
+	// DiffLineContext is a line present in both versions.
+	DiffLineContext DiffLineType = "context"
+
+	// DiffLineAdded is a line present only in the newer version.
+	DiffLineAdded DiffLineType = "added"
+
+	// DiffLineRemoved is a line present only in the older version.
+	DiffLineRemoved DiffLineType = "removed"
+)
+
+// DiffLine is a single line in a diff.
+type DiffLine struct {
+	Type    DiffLineType `json:"type"`               // added, removed, or context
+	OldLine int          `json:"old_line,omitempty"` // line number in the older version, if present
+	NewLine int          `json:"new_line,omitempty"` // line number in the newer version, if present
+	Text    string       `json:"text"`               // line content, without newline
+}
+
+// Diff represents the differences between two versions of a file.
+type Diff struct {
+	File  string     `json:"file"`            // file name relative to wiki root
+	From  string     `json:"from"`            // older revision hash
+	To    string     `json:"to,omitempty"`    // newer revision hash, or empty for the current file
+	Lines []DiffLine `json:"lines,omitempty"` // lines of the diff; empty if no changes
+}
+
+// PageHistory returns the revision history for a page, most recent first.
+func (w *Wiki) PageHistory(name string) ([]RevisionInfo, error) {
+	path, err := w.pageRepoPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return w.fileHistory(path)
+}
+
+// PageAtRevision returns the display result for a page as it was at
+// the given revision.
+//
+// The result is a DisplayPage or DisplayError. Results are never cached,
+// and no side effects such as category updates take place.
+//
+func (w *Wiki) PageAtRevision(name, hash string) interface{} {
+	var r DisplayPage
+
+	// find the page and its content at that revision
+	page := w.FindPage(name)
+	path, err := w.pageRepoPath(name)
+	if err != nil {
+		return DisplayError{Error: "Page does not exist.", DetailedError: err.Error()}
+	}
+	commit, err := w.commitAt(hash)
+	if err != nil {
+		return DisplayError{Error: "Revision does not exist.", DetailedError: err.Error()}
+	}
+	content, err := fileAtCommit(commit, path)
+	if err != nil {
+		return DisplayError{Error: "Page did not exist at this revision.", DetailedError: err.Error()}
+	}
+
+	// parse it from source, even if it was empty at that revision
+	page.Source = content
+	page.HasSource = true
+	if err := page.Parse(); err != nil {
+		var pos wikifier.Position
+		if page.Error != nil {
+			pos = page.Error.Pos
+		}
+		return DisplayError{Error: err.Error(), Pos: pos}
+	}
+
+	// generate HTML and metadata
+	mod := commit.Author.When
+	r.File = page.Name()
+	r.Name = page.NameNE()
+	r.Path = page.Path()
+	r.Generated = true
+	r.Title = page.Title()
+	r.FmtTitle = page.FmtTitle()
+	r.Author = page.Author()
+	r.Description = page.Description()
+	r.Keywords = page.Keywords()
+	r.Draft = page.Draft()
+	r.Modified = &mod
+	r.ModifiedHTTP = httpdate.Time2Str(mod)
+	r.Content = page.HTML()
+	r.CSS = page.CSS()
+	r.Warnings = page.Warnings
+	r.References = page.References()
+	r.Categories = page.Categories()
+
+	return r
+}
+
+// PageSourceAtRevision returns the source code of a page as it was at the
+// given revision.
+func (w *Wiki) PageSourceAtRevision(name, hash string) (string, error) {
+	path, err := w.pageRepoPath(name)
+	if err != nil {
+		return "", err
+	}
+	commit, err := w.commitAt(hash)
+	if err != nil {
+		return "", err
+	}
+	return fileAtCommit(commit, path)
+}
+
+// Diff returns the line differences of a page between two revisions.
+//
+// If to is empty, the revision is compared to the current file.
+// If the page did not exist at one of the revisions, it is treated as empty.
+//
+func (w *Wiki) Diff(name, from, to string) (*Diff, error) {
+	path, err := w.pageRepoPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// find older content
+	fromCommit, err := w.commitAt(from)
+	if err != nil {
+		return nil, err
+	}
+	fromContent, err := fileAtCommit(fromCommit, path)
+	if err != nil && err != object.ErrFileNotFound {
+		return nil, err
+	}
+
+	// find newer content
+	var toContent string
+	if to == "" {
+		data, err := ioutil.ReadFile(w.UnresolvedAbsFilePath(path))
+		if err != nil {
+			return nil, err
+		}
+		toContent = string(data)
+	} else {
+		toCommit, err := w.commitAt(to)
+		if err != nil {
+			return nil, err
+		}
+		toContent, err = fileAtCommit(toCommit, path)
+		if err != nil && err != object.ErrFileNotFound {
+			return nil, err
+		}
+		to = toCommit.Hash.String()
+	}
+
+	return &Diff{
+		File:  path,
+		From:  fromCommit.Hash.String(),
+		To:    to,
+		Lines: diffLines(fromContent, toContent),
+	}, nil
+}
+
+// RevertPage restores a page to its content at the given revision.
+// The restored content is written and committed as a new revision.
+func (w *Wiki) RevertPage(name, hash string, commit CommitOpts) error {
+	path, err := w.pageRepoPath(name)
+	if err != nil {
+		return err
+	}
+
+	// find content at that revision
+	c, err := w.commitAt(hash)
+	if err != nil {
+		return err
+	}
+	content, err := fileAtCommit(c, path)
+	if err != nil {
+		return errors.Wrap(err, "page did not exist at revision "+hash)
+	}
+
+	// default comment
+	if commit.Comment == "" {
+		commit.Comment = "revert to " + shortHash(c.Hash.String())
+	}
+
+	return w.WritePage(w.pageNameForRepoPath(path), []byte(content), true, commit)
+}
+
+// Unified returns the diff in unified format, suitable for
+// tools which read the output of diff -u.
+func (d *Diff) Unified() string {
+	if len(d.Lines) == 0 {
+		return ""
+	}
+
+	// header
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", d.File, d.File)
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", d.File, d.File)
+
+	// count lines in each version
+	oldN, newN := 0, 0
+	for _, line := range d.Lines {
+		if line.Type != DiffLineAdded {
+			oldN++
+		}
+		if line.Type != DiffLineRemoved {
+			newN++
+		}
+	}
+	oldStart, newStart := 1, 1
+	if oldN == 0 {
+		oldStart = 0
+	}
+	if newN == 0 {
+		newStart = 0
+	}
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldN, newStart, newN)
+
+	// lines
+	for _, line := range d.Lines {
+		switch line.Type {
+		case DiffLineAdded:
+			b.WriteByte('+')
+		case DiffLineRemoved:
+			b.WriteByte('-')
+		default:
+			b.WriteByte(' ')
+		}
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// fileHistory returns the commits which touched a file, most recent first
+func (w *Wiki) fileHistory(path string) ([]RevisionInfo, error) {
+	repo, err := w.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	// find commits affecting this file
+	iter, err := repo.Log(&git.LogOptions{FileName: &path, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, errors.Wrap(err, "git:repo:Log")
+	}
+	defer iter.Close()
+
+	var revs []RevisionInfo
+	err = iter.ForEach(func(c *object.Commit) error {
+		when := c.Author.When
+		revs = append(revs, RevisionInfo{
+			Id:      c.Hash.String(),
+			Author:  c.Author.Name,
+			Email:   c.Author.Email,
+			Date:    &when,
+			Message: strings.TrimSpace(c.Message),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "git:iter:ForEach")
+	}
+
+	return revs, nil
+}
+
+// find a commit by hash or other revision
+func (w *Wiki) commitAt(hash string) (*object.Commit, error) {
+	if hash == "" {
+		return nil, errors.New("no revision specified")
+	}
+	repo, err := w.repo()
+	if err != nil {
+		return nil, err
+	}
+	h, err := repo.ResolveRevision(plumbing.Revision(hash))
+	if err != nil {
+		return nil, errors.Wrap(err, "git:repo:ResolveRevision")
+	}
+	commit, err := repo.CommitObject(*h)
+	if err != nil {
+		return nil, errors.Wrap(err, "git:repo:CommitObject")
+	}
+	return commit, nil
+}
+
+// page path relative to the repository, with forward slashes
+func (w *Wiki) pageRepoPath(name string) (string, error) {
+	page := w.FindPage(name)
+	rel := w.RelPath(page.Path())
+	if rel == "" {
+		return "", errors.New("page is outside of the wiki directory")
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// content of a file at a commit
+func fileAtCommit(commit *object.Commit, path string) (string, error) {
+	file, err := commit.File(path)
+	if err != nil {
+		return "", err
+	}
+	return file.Contents()
+}
+
+// computes a line diff between two strings
+func diffLines(from, to string) []DiffLine {
+	var lines []DiffLine
+	oldN, newN := 0, 0
+	changed := false
+
+	for _, d := range diff.Do(from, to) {
+		if d.Text == "" {
+			continue
+		}
+		text := strings.TrimSuffix(d.Text, "\n")
+		for _, s := range strings.Split(text, "\n") {
+			line := DiffLine{Text: s}
+			switch d.Type {
+			case diffmatchpatch.DiffInsert:
+				newN++
+				line.Type, line.NewLine = DiffLineAdded, newN
+				changed = true
+			case diffmatchpatch.DiffDelete:
+				oldN++
+				line.Type, line.OldLine = DiffLineRemoved, oldN
+				changed = true
+			default:
+				oldN++
+				newN++
+				line.Type, line.OldLine, line.NewLine = DiffLineContext, oldN, newN
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	// no differences
+	if !changed {
+		return nil
+	}
+
+	return lines
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
@@ -0,0 +1,264 @@
+package wiki
+
+import (
+	"bufio"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cooper/quiki/internal/webp"
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+	_ "golang.org/x/image/webp" // for webps
+)
+
+// image types by extension
+var imageTypes = map[string]struct {
+	imageType string // value for DisplayImage.ImageType
+	mime      string // Content-Type
+}{
+	"png":  {"png", "image/png"},
+	"jpg":  {"jpeg", "image/jpeg"},
+	"jpeg": {"jpeg", "image/jpeg"},
+	"gif":  {"gif", "image/gif"},
+	"webp": {"webp", "image/webp"},
+	"svg":  {"svg", "image/svg+xml"},
+}
+
+// extensions of images which may be converted to another format. a request
+// for an image with one of these extensions can be fulfilled by converting
+// an image of the same name with any of the others
+var imageConvertExtensions = []string{"png", "jpg", "jpeg", "webp", "gif"}
+
+// outputImageExt returns the extension for generated versions of an image,
+// according to image.type. vector and animated formats are preserved
+func outputImageExt(imageType, ext string) string {
+	if imageType == "" || imageTypes[ext].imageType == imageType {
+		return ext
+	}
+	switch ext {
+	case "png", "jpg", "jpeg", "webp":
+		return imageType
+	}
+	return ext
+}
+
+// isConvertibleImage returns whether an image extension can be converted
+func isConvertibleImage(ext string) bool {
+	for _, e := range imageConvertExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// findImageSource finds the full-size image for a sized image. if it does
+// not exist, another format by the same name is used if the image can be
+// converted. returns the path, extension, and file info
+func (w *Wiki) findImageSource(img SizedImage) (string, string, os.FileInfo, error) {
+	path := w.pathForImage(img.FullSizeName())
+	fi, err := os.Lstat(path)
+	if err == nil || !isConvertibleImage(img.Ext) {
+		return path, img.Ext, fi, err
+	}
+	for _, ext := range imageConvertExtensions {
+		if ext == img.Ext {
+			continue
+		}
+		src := img
+		src.Ext = ext
+		srcPath := w.pathForImage(src.FullSizeName())
+		if srcFi, srcErr := os.Lstat(srcPath); srcErr == nil {
+			return srcPath, ext, srcFi, nil
+		}
+	}
+	return path, img.Ext, fi, err
+}
+
+// encodeImage writes an image in the format for an extension
+func encodeImage(out io.Writer, img image.Image, ext string, quality int) error {
+	switch ext {
+	case "png":
+		return png.Encode(out, img)
+	case "jpg", "jpeg":
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(out, flattenImage(img), &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(out, img, nil)
+	case "webp":
+		return webp.Encode(out, img)
+	}
+	return errors.New("cannot encode ." + ext + " images")
+}
+
+// flattenImage composites an image with transparency onto a white
+// background for formats without an alpha channel. otherwise, transparent
+// areas would turn black
+func flattenImage(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, image.White, image.ZP, draw.Src)
+	draw.Draw(flat, b, img, b.Min, draw.Over)
+	return flat
+}
+
+// saveImage writes an image to a file in the format for an extension.
+// the file is replaced atomically, so it is never seen partially written
+func saveImage(path string, img image.Image, ext string, quality int) error {
+	file, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+	err = encodeImage(buf, img, ext, quality)
+	if err == nil {
+		err = buf.Flush()
+	}
+	return finishAtomic(file, path, err)
+}
+
+// resizes each frame of a GIF, preserving the animation
+func resizeGIF(in io.Reader, out io.Writer, width, height int) error {
+	g, err := gif.DecodeAll(in)
+	if err != nil {
+		return err
+	}
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewNRGBA(bounds)
+	previous := image.NewNRGBA(bounds)
+	disposals := make([]byte, len(g.Image))
+
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		// frames may only cover part of the image, so draw each onto the
+		// canvas and resize the whole thing
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		resized := imaging.Resize(canvas, width, height, imaging.Lanczos)
+
+		// reduce to the frame's palette, adding transparency if needed
+		pal := color.Palette(append([]color.Color(nil), frame.Palette...))
+		if !hasTransparent(pal) && !isOpaque(resized) {
+			if len(pal) < 256 {
+				pal = append(pal, color.Transparent)
+			} else {
+				pal[len(pal)-1] = color.Transparent
+			}
+		}
+		paletted := image.NewPaletted(resized.Bounds(), pal)
+		draw.Draw(paletted, paletted.Bounds(), resized, image.ZP, draw.Src)
+		g.Image[i] = paletted
+
+		// dispose of the frame as specified
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+
+		// each new frame is complete, so clear it before drawing the next
+		disposals[i] = gif.DisposalBackground
+	}
+	g.Disposal = disposals
+
+	g.Config.Width, g.Config.Height = width, height
+	g.Config.ColorModel = nil
+	g.BackgroundIndex = 0
+	return gif.EncodeAll(out, g)
+}
+
+func hasTransparent(pal color.Palette) bool {
+	for _, c := range pal {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isOpaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// determines SVG dimensions from width and height or the viewBox
+func getSVGDimensions(in io.Reader) (w, h int) {
+	dec := xml.NewDecoder(in)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "svg" {
+			return
+		}
+
+		var width, height, vbWidth, vbHeight float64
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "width":
+				width = parseSVGLength(attr.Value)
+			case "height":
+				height = parseSVGLength(attr.Value)
+			case "viewBox":
+				fields := strings.Fields(strings.Replace(attr.Value, ",", " ", -1))
+				if len(fields) == 4 {
+					vbWidth, _ = strconv.ParseFloat(fields[2], 64)
+					vbHeight, _ = strconv.ParseFloat(fields[3], 64)
+				}
+			}
+		}
+
+		// fill in missing dimensions from the viewBox
+		if vbWidth > 0 && vbHeight > 0 {
+			switch {
+			case width == 0 && height == 0:
+				width, height = vbWidth, vbHeight
+			case width == 0:
+				width = height * vbWidth / vbHeight
+			case height == 0:
+				height = width * vbHeight / vbWidth
+			}
+		}
+		return int(width + 0.5), int(height + 0.5)
+	}
+}
+
+// parses an SVG length in pixels. relative units are not supported
+func parseSVGLength(s string) float64 {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,88 @@
+package wiki
+
+import (
+	"runtime"
+	"sync"
+)
+
+// imageQueue generates images with a limited number of workers.
+// a job for an image which is already queued or being generated is not
+// added again; instead, it shares the result of the existing job
+type imageQueue struct {
+	mu      sync.Mutex
+	workers int
+	jobs    chan *imageJob
+	pending map[string]*imageJob // cache path -> job
+}
+
+// imageJob is an image waiting to be generated.
+type imageJob struct {
+	name   string
+	gen    func() interface{}
+	result interface{}
+	done   chan struct{}
+}
+
+// size of the job buffer; beyond this, adding a job blocks until a worker
+// is available
+const imageQueueSize = 256
+
+// all wikis share one queue, so that the number of workers does not grow
+// with the number of wikis and branches
+var imageWorkers = newImageQueue(0)
+
+func newImageQueue(workers int) *imageQueue {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &imageQueue{
+		workers: workers,
+		pending: make(map[string]*imageJob),
+	}
+}
+
+// add queues an image to be generated with gen, unless it is already
+// queued, and returns the job
+func (q *imageQueue) add(name string, gen func() interface{}) *imageJob {
+	q.mu.Lock()
+
+	// already queued
+	if job, exist := q.pending[name]; exist {
+		q.mu.Unlock()
+		return job
+	}
+
+	// start workers the first time
+	if q.jobs == nil {
+		q.jobs = make(chan *imageJob, imageQueueSize)
+		for i := 0; i < q.workers; i++ {
+			go q.work()
+		}
+	}
+
+	job := &imageJob{name: name, gen: gen, done: make(chan struct{})}
+	q.pending[name] = job
+	q.mu.Unlock()
+
+	q.jobs <- job
+	return job
+}
+
+// generate queues an image and waits for its result
+func (q *imageQueue) generate(name string, gen func() interface{}) interface{} {
+	job := q.add(name, gen)
+	<-job.done
+	return job.result
+}
+
+func (q *imageQueue) work() {
+	for job := range q.jobs {
+		job.result = job.gen()
+
+		q.mu.Lock()
+		delete(q.pending, job.name)
+		q.mu.Unlock()
+
+		close(job.done)
+	}
+}
@@ -1,8 +1,10 @@
 package wiki
 
 import (
+	"bufio"
 	"fmt"
 	"image"
+	_ "image/gif"  // for gifs
 	_ "image/jpeg" // for jpegs
 	_ "image/png"  // for pngs
 	"math"
@@ -20,8 +22,9 @@ import (
 )
 
 var (
-	imageNameRegex  = regexp.MustCompile(`^(\d+)x(\d+)-(.+)$`)
+	imageNameRegex  = regexp.MustCompile(`^(\d+)x(\d+)((?:~[a-z]+[\d,]*)*)-(.+)$`)
 	imageScaleRegex = regexp.MustCompile(`^(.+)\@(\d+)x$`)
+	imageOptRegex   = regexp.MustCompile(`^([a-z]+)([\d,]*)$`)
 )
 
 // ImageInfo represents a full-size image on the wiki.
@@ -32,6 +35,9 @@ type ImageInfo struct {
 	Created    *time.Time `json:"created,omitempty"`  // creation time
 	Modified   *time.Time `json:"modified,omitempty"` // modify time
 	Dimensions [][]int    `json:"-"`                  // dimensions used throughout the wiki
+
+	// crops and fits used throughout the wiki
+	Transforms []wikifier.ImageTransform `json:"-"`
 }
 
 // SizedImage represents an image in specific dimensions.
@@ -43,6 +49,11 @@ type SizedImage struct {
 	RelNameNE     string // myimage (name without extension)
 	Ext           string // png (extension)
 	zeroByZero    bool   // true when created from 0x0-name
+
+	// crop and fit, for example 100x200~crop0,0,50,50~cover~focus20,30-myimage.png.
+	// Fit is only ever cover, since contain is determined by dimensions,
+	// and fill is the default
+	wikifier.ImageTransform
 }
 
 // SizedImageFromName returns a SizedImage given an image name.
@@ -60,11 +71,13 @@ func SizedImageFromName(name string) SizedImage {
 	}
 
 	// width and height were given, so it's a resized image
+	var t wikifier.ImageTransform
 	if matches := imageNameRegex.FindStringSubmatch(name); len(matches) != 0 {
 		w, _ = strconv.Atoi(matches[1])
 		h, _ = strconv.Atoi(matches[2])
-		zeroByZero = w == 0 && h == 0
-		name = matches[3]
+		zeroByZero = w == 0 && h == 0 && matches[3] == ""
+		t = parseImageTransform(matches[3])
+		name = matches[4]
 	}
 
 	// extract extension
@@ -93,9 +106,52 @@ func SizedImageFromName(name string) SizedImage {
 		RelNameNE:  nameNE,
 		Ext:        ext,
 		zeroByZero: zeroByZero,
+
+		ImageTransform: t,
 	}
 }
 
+// parses ~crop0,0,50,50~cover~focus20,30 from an image name
+func parseImageTransform(opts string) (t wikifier.ImageTransform) {
+	for _, opt := range strings.Split(opts, "~") {
+		matches := imageOptRegex.FindStringSubmatch(opt)
+		if len(matches) == 0 {
+			continue
+		}
+		var ints []int
+		if matches[2] != "" {
+			for _, s := range strings.Split(matches[2], ",") {
+				i, _ := strconv.Atoi(s)
+				ints = append(ints, i)
+			}
+		}
+		switch {
+		case matches[1] == "cover" && ints == nil:
+			t.Fit = "cover"
+		case matches[1] == "crop" && len(ints) == 4 && ints[2] != 0 && ints[3] != 0:
+			t.Crop = ints
+		case matches[1] == "focus" && len(ints) == 2:
+			t.Focus = ints
+		}
+	}
+	return
+}
+
+// transformString returns the crop and fit part of the image name
+func (img SizedImage) transformString() string {
+	s := ""
+	if len(img.Crop) == 4 {
+		s += fmt.Sprintf("~crop%d,%d,%d,%d", img.Crop[0], img.Crop[1], img.Crop[2], img.Crop[3])
+	}
+	if img.Fit == "cover" {
+		s += "~cover"
+		if len(img.Focus) == 2 {
+			s += fmt.Sprintf("~focus%d,%d", img.Focus[0], img.Focus[1])
+		}
+	}
+	return s
+}
+
 // TrueWidth returns the actual image width when the Scale is taken into consideration.
 func (img SizedImage) TrueWidth() int {
 	return img.Width * img.Scale
@@ -117,10 +173,11 @@ func (img SizedImage) TrueNameNE() string {
 		return img.Prefix + img.RelNameNE
 	}
 	return fmt.Sprintf(
-		"%s%dx%d-%s",
+		"%s%dx%d%s-%s",
 		img.Prefix,
 		img.TrueWidth(),
 		img.TrueHeight(),
+		img.transformString(),
 		img.RelNameNE,
 	)
 }
@@ -135,10 +192,11 @@ func (img SizedImage) ScaleName() string {
 	if img.Scale <= 1 {
 		return img.TrueName()
 	}
-	return fmt.Sprintf("%s%dx%d-%s@%dx.%s",
+	return fmt.Sprintf("%s%dx%d%s-%s@%dx.%s",
 		img.Prefix,
 		img.Width,
 		img.Height,
+		img.transformString(),
 		img.RelNameNE,
 		img.Scale,
 		img.Ext,
@@ -160,10 +218,10 @@ type DisplayImage struct {
 	FullsizePath string `json:"fullsize_path,omitempty"`
 
 	// image type
-	// 'png' or 'jpeg'
+	// 'png', 'jpeg', 'gif', 'webp', or 'svg'
 	ImageType string `json:"image_type,omitempty"`
 
-	// mime 'image/png' or 'image/jpeg'
+	// mime type such as 'image/png' or 'image/svg+xml'
 	// suitable for the Content-Type header
 	Mime string `json:"mime,omitempty"`
 
@@ -204,13 +262,25 @@ func (w *Wiki) DisplaySizedImage(img SizedImage) interface{} {
 // DisplaySizedImageGenerate returns the display result for an image in specific dimensions
 // and allows images to be generated in any dimension.
 func (w *Wiki) DisplaySizedImageGenerate(img SizedImage, generateOK bool) interface{} {
+	return w.displaySizedImage(img, generateOK, true)
+}
+
+// pregenerateImage queues an image to be generated without waiting for it.
+// the job is added to the current pregeneration, which waits for it
+func (w *Wiki) pregenerateImage(img SizedImage) {
+	w.displaySizedImage(img, true, false)
+}
+
+// displaySizedImage implements DisplaySizedImageGenerate. if wait is false
+// and the image has to be generated, it is queued, and the result is nil
+func (w *Wiki) displaySizedImage(img SizedImage, generateOK, wait bool) interface{} {
 	var r DisplayImage
 	logName := img.ScaleName()
 	w.Debug("display image:", logName)
 
-	// check if the file exists
-	bigPath := w.pathForImage(img.FullSizeName())
-	fi, err := os.Lstat(bigPath)
+	// check if the file exists. if this is a request for another format,
+	// it might exist with a different extension
+	bigPath, srcExt, fi, err := w.findImageSource(img)
 	if err != nil {
 		return DisplayError{
 			Error:         "Image does not exist.",
@@ -218,18 +288,34 @@ func (w *Wiki) DisplaySizedImageGenerate(img SizedImage, generateOK bool) interf
 		}
 	}
 
-	// one dimension is missing
+	// cover only matters if both dimensions are given
+	if img.Width == 0 || img.Height == 0 {
+		img.Fit = ""
+	}
+
+	// one dimension is missing, or both are missing but the image is cropped
 	var bigW, bigH int
 	oldName := img.TrueName()
-	if (img.Width == 0 && img.Height != 0) || (img.Height == 0 && img.Width != 0) {
+	cropped := len(img.Crop) == 4
+	if (img.Width == 0) != (img.Height == 0) || (img.Width == 0 && cropped) {
 		w.Debugf("display image: %s: missing a dimension; have to open", logName)
 
 		// get full size dimensions
 		bigW, bigH = getImageDimensions(bigPath)
 
+		// a cropped image has the dimensions of the crop
+		srcW, srcH := bigW, bigH
+		if cropped {
+			crop := cropRect(img.Crop, bigW, bigH)
+			srcW, srcH = crop.Dx(), crop.Dy()
+		}
+
 		// find missing dimension
-		// note: we haven't checked if both are 0 yet, but this will return 0, 0 in that case
-		img.Width, img.Height = calculateImageDimensions(bigW, bigH, img.Width, img.Height)
+		if img.Width == 0 && img.Height == 0 {
+			img.Width, img.Height = srcW, srcH
+		} else {
+			img.Width, img.Height = calculateImageDimensions(srcW, srcH, img.Width, img.Height)
+		}
 	}
 
 	// check if the name has changed after this adjustment.
@@ -246,25 +332,31 @@ func (w *Wiki) DisplaySizedImageGenerate(img SizedImage, generateOK bool) interf
 	r.File = filepath.Base(r.Path)
 
 	// image type and mime type
-	if img.Ext == "jpg" || img.Ext == "jpeg" {
-		r.ImageType = "jpeg"
-		r.Mime = "image/jpeg"
-	} else if img.Ext == "png" {
-		r.ImageType = "png"
-		r.Mime = "image/png"
-	} else {
+	typ, ok := imageTypes[img.Ext]
+	if !ok {
 		return DisplayError{
 			Error:         "Unknown image type.",
-			DetailedError: "Image '" + bigPath + "' is neither png nor jpeg",
+			DetailedError: "Image '" + bigPath + "' is not a supported type",
 		}
 	}
+	r.ImageType = typ.imageType
+	r.Mime = typ.mime
+
+	// vector images are served as-is in any dimensions
+	if srcExt == "svg" {
+		img.Width, img.Height = 0, 0
+	}
 
 	// create or update image category
 	// consider: do we need to do this here, and does it write every time?
-	w.GetSpecialCategory(r.File, CategoryTypeImage).addImage(w, r.File, nil, nil)
-
-	// if both dimensions are missing, display the full-size version of the image
-	if img.Width == 0 && img.Height == 0 {
+	w.changeCategory(r.File, CategoryTypeImage, func(cat *Category) {
+		cat.addImage(w, r.File, nil, nil, nil)
+	})
+
+	// if both dimensions are missing, display the full-size version of the
+	// image, unless it has to be converted to another format
+	convert := srcExt != img.Ext
+	if img.Width == 0 && img.Height == 0 && !convert {
 		w.Debugf("display image: %s: using full-size", logName)
 		mod := fi.ModTime()
 		r.Modified = &mod
@@ -283,12 +375,12 @@ func (w *Wiki) DisplaySizedImageGenerate(img SizedImage, generateOK bool) interf
 	// this is not a retina request, but retina is enabled, and
 	// this is a pregeneration request of the normal-scale image.
 	// so, commit a pregeneration request for each scaled version.
-	if img.Scale <= 1 && generateOK {
-		for _, scale := range w.Opt.Image.Retina {
+	if img.Scale <= 1 && generateOK && img.Width != 0 {
+		for _, scale := range w.Opt().Image.Retina {
 			w.Debugf("display image: %s: also generating retina @%dx", logName, scale)
 			scaledImage := img        // copy
 			scaledImage.Scale = scale // set scale
-			w.DisplaySizedImageGenerate(scaledImage, generateOK)
+			w.displaySizedImage(scaledImage, generateOK, wait)
 		}
 	}
 
@@ -297,8 +389,8 @@ func (w *Wiki) DisplaySizedImageGenerate(img SizedImage, generateOK bool) interf
 	// #=========================#
 
 	// look for cached version
-	cachePath := w.Opt.Dir.Cache + "/image/" + trueName
-	wikifier.MakeDir(w.Opt.Dir.Cache+"/image/", trueName)
+	cachePath := w.Opt().Dir.Cache + "/image/" + trueName
+	wikifier.MakeDir(w.Opt().Dir.Cache+"/image/", trueName)
 	cacheFi, err := os.Lstat(cachePath)
 
 	// it exists
@@ -334,23 +426,50 @@ func (w *Wiki) DisplaySizedImageGenerate(img SizedImage, generateOK bool) interf
 	// generate the image in specific dimensions
 
 	// we're not allowed to do this if this is a legit (non-pregeneration)
-	// request. because like, we would've served a cached image if it were
-	// actually used somewhere on the wiki
+	// request, unless the image is actually used in these dimensions
+	// somewhere on the wiki. otherwise anyone could fill the cache with
+	// images in arbitrary dimensions
+	if !generateOK {
+		if dispErr := w.checkImageAllowed(img, srcExt); dispErr != nil {
+			return dispErr
+		}
+	}
 
-	// FIXME: disabled for now
-	// if !generateOK {
-	// 	dimensions := strconv.Itoa(img.TrueWidth()) + "x" + strconv.Itoa(img.TrueHeight())
-	// 	return DisplayError{Error: "Image does not exist at " + dimensions + "."}
-	// }
+	// an image in another format which is the same size or larger than the
+	// original is just the full-size image in that format
+	transform := len(img.Crop) == 4 || img.Fit == "cover"
+	if convert && !transform && img.Width != 0 {
+		if bigW == 0 || bigH == 0 {
+			bigW, bigH = getImageDimensions(bigPath)
+		}
+		if img.TrueWidth() >= bigW || img.TrueHeight() >= bigH {
+			full := img
+			full.Width, full.Height, full.Scale = 0, 0, 1
+			w.Debugf("display image: %s: too big; using full-size %s", logName, full.TrueName())
+			res := w.displaySizedImage(full, true, wait)
+			w.symlinkScaledImage(img, full.TrueName())
+			return res
+		}
+	}
 
-	// generate the image
+	// generate the image, or wait for it if another request is already
+	// generating it
 	// note: bigW and bigH might still be empty
-	if dispErr := w.generateImage(img, bigPath, bigW, bigH, &r); dispErr != nil {
-		return dispErr
+	gen := func() interface{} {
+		if dispErr := w.generateImage(img, bigPath, srcExt, bigW, bigH, &r); dispErr != nil {
+			return dispErr
+		}
+		return r
 	}
-
-	w.symlinkScaledImage(img, trueName)
-	return r
+	if !wait {
+		w.pregen.addImage(imageWorkers.add(cachePath, gen))
+		return nil
+	}
+	res := imageWorkers.generate(cachePath, gen)
+	if _, ok := res.(DisplayImage); ok {
+		w.symlinkScaledImage(img, trueName)
+	}
+	return res
 }
 
 // Images returns info about all the images in the wiki.
@@ -434,12 +553,13 @@ func (w *Wiki) ImageInfo(name string) (info ImageInfo) {
 	info.Modified = &mod // actual image mod time
 
 	// find image category
-	imageCat := w.GetSpecialCategory(name, CategoryTypeImage)
+	imageCat := w.changeCategory(name, CategoryTypeImage, func(imageCat *Category) {
 
-	// it doesn't exist. let's create it
-	if !imageCat.Exists() {
-		imageCat.addImage(w, name, nil, nil)
-	}
+		// it doesn't exist. let's create it
+		if !imageCat.Exists() {
+			imageCat.addImage(w, name, nil, nil, nil)
+		}
+	})
 
 	// it should exist at this point
 	if imageCat.Exists() {
@@ -450,6 +570,7 @@ func (w *Wiki) ImageInfo(name string) (info ImageInfo) {
 		info.Created = imageCat.Created // category creation time, not image
 		for _, entry := range imageCat.Pages {
 			info.Dimensions = append(info.Dimensions, entry.Dimensions...)
+			info.Transforms = append(info.Transforms, entry.Transforms...)
 		}
 		return
 	}
@@ -461,8 +582,152 @@ func (w *Wiki) ImageInfo(name string) (info ImageInfo) {
 	return
 }
 
-func (w *Wiki) generateImage(img SizedImage, bigPath string, bigW, bigH int, r *DisplayImage) interface{} {
+// AllowImageSize permits an image to be generated on request in specific
+// dimensions, in addition to those in which it is used on the wiki. Either
+// dimension may be zero, in which case it is determined by the aspect ratio.
+func (w *Wiki) AllowImageSize(name string, width, height int) {
+	w.sizesMu.Lock()
+	defer w.sizesMu.Unlock()
+	if w.allowedImageSizes == nil {
+		w.allowedImageSizes = make(map[string][][]int)
+	}
+	w.allowedImageSizes[name] = append(w.allowedImageSizes[name], []int{width, height})
+}
+
+// checkImageAllowed returns nil if an image may be generated on request,
+// a redirect if it may be generated in similar dimensions, or an error
+func (w *Wiki) checkImageAllowed(img SizedImage, srcExt string) interface{} {
+	logName := img.ScaleName()
+	dimensions := strconv.Itoa(img.TrueWidth()) + "x" + strconv.Itoa(img.TrueHeight())
+
+	// other formats are allowed only if the wiki uses them
+	if srcExt != img.Ext && !w.imageFormatAllowed(img.Ext) {
+		return DisplayError{
+			Error:         "Image does not exist in this format.",
+			DetailedError: "Image '" + logName + "' is not generated in ." + img.Ext + " format",
+		}
+	}
+
+	// full-size conversion
+	if img.Width == 0 && img.Height == 0 {
+		return nil
+	}
+
+	src := img
+	src.Ext = srcExt
+	sizes, ok := w.imageAllowedSizes(img, w.ImageInfo(src.FullSizeName()))
+	if !ok {
+		return DisplayError{
+			Error:         "Image does not exist at " + dimensions + ".",
+			DetailedError: "Image '" + logName + "' is not used with " + img.transformString(),
+		}
+	}
+	if imageSizeAllowed(img, sizes, w.Opt().Image.Retina) {
+		return nil
+	}
+
+	// not allowed and nothing similar
+	if len(sizes) == 0 {
+		return DisplayError{
+			Error:         "Image does not exist at " + dimensions + ".",
+			DetailedError: "Image '" + logName + "' is not used on the wiki",
+		}
+	}
+
+	// redirect to the nearest allowed size
+	nearest, nearestDiff := sizes[0], -1
+	for _, size := range sizes {
+		diff := absInt(size[0]-img.Width) + absInt(size[1]-img.Height)
+		if nearestDiff == -1 || diff < nearestDiff {
+			nearest, nearestDiff = size, diff
+		}
+	}
+	img.Width, img.Height = nearest[0], nearest[1]
+	if !imageScaleAllowed(img.Scale, w.Opt().Image.Retina) {
+		img.Scale = 1
+	}
+	w.Debugf("display image: %s: not allowed; redirect to %s", logName, img.ScaleName())
+	return DisplayRedirect{filepath.Base(img.ScaleName())}
+}
+
+// imageAllowedSizes returns the dimensions in which an image may be
+// generated with its crop and fit. ok is false if the crop and fit are
+// not used on the wiki
+func (w *Wiki) imageAllowedSizes(img SizedImage, info ImageInfo) (sizes [][]int, ok bool) {
+	sizes = append([][]int(nil), info.Dimensions...)
+
+	// crop and fit must match one used on the wiki. the configured sizes
+	// are only for the plain image
+	if transform := img.transformString(); transform != "" {
+		for _, t := range info.Transforms {
+			if (SizedImage{ImageTransform: t}).transformString() == transform {
+				return sizes, true
+			}
+		}
+		return nil, false
+	}
+
+	w.sizesMu.RLock()
+	allowed := append([][]int(nil), w.allowedImageSizes[info.File]...)
+	w.sizesMu.RUnlock()
+
+	for _, size := range append(allowed, w.Opt().Image.AllowedSizes...) {
+		width, height := size[0], size[1]
+		if width == 0 || height == 0 {
+			width, height = calculateImageDimensions(info.Width, info.Height, width, height)
+		}
+		if width == 0 || height == 0 {
+			continue
+		}
+		sizes = append(sizes, []int{width, height})
+	}
+	return sizes, true
+}
+
+// imageSizeAllowed returns whether an image has one of the sizes at normal
+// scale or any retina scale
+func imageSizeAllowed(img SizedImage, sizes [][]int, retina []int) bool {
+	for _, scale := range append([]int{1}, retina...) {
+		for _, size := range sizes {
+			if size[0]*scale == img.TrueWidth() && size[1]*scale == img.TrueHeight() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imageScaleAllowed returns whether a scale is 1 or a retina scale
+func imageScaleAllowed(scale int, retina []int) bool {
+	for _, s := range append([]int{1}, retina...) {
+		if s == scale {
+			return true
+		}
+	}
+	return false
+}
+
+// imageFormatAllowed returns whether images may be converted to the format
+// for an extension, according to image.type and image.formats
+func (w *Wiki) imageFormatAllowed(ext string) bool {
+	typ := imageTypes[ext].imageType
+	if typ == "" {
+		return false
+	}
+	if typ == w.Opt().Image.Type {
+		return true
+	}
+	for _, format := range w.Opt().Image.Formats {
+		if typ == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Wiki) generateImage(img SizedImage, bigPath, srcExt string, bigW, bigH int, r *DisplayImage) interface{} {
 	width, height := img.TrueWidth(), img.TrueHeight()
+	convert := srcExt != img.Ext
 
 	// open the full-size image
 	bigImage, err := imaging.Open(bigPath)
@@ -481,25 +746,38 @@ func (w *Wiki) generateImage(img SizedImage, bigPath string, bigW, bigH int, r *
 		bigH = b.Max.Y
 	}
 
-	// the request is to generate an image the same or larger than the original
-	if width >= bigW || height >= bigH {
+	// the request is to generate an image the same or larger than the original.
+	// if it's cropped, we still have to generate it, but we won't enlarge it
+	transform := len(img.Crop) == 4 || img.Fit == "cover"
+	if !transform && (width == 0 || width >= bigW || height >= bigH) {
 
-		// symlink this to the full-size image
-		w.symlinkScaledImage(img, img.FullSizeName())
+		// if it's the same format, symlink this to the full-size image
+		if !convert {
+			w.symlinkScaledImage(img, img.FullSizeName())
+			return nil // success
+		}
 
-		return nil // success
+		// otherwise, convert it at full size
+		width, height = bigW, bigH
 	}
 
 	// safe point - we will resize the image
 
 	w.Debug("generate image:", img.TrueName())
+	newImagePath := filepath.FromSlash(w.Opt().Dir.Cache + "/image/" + img.TrueName())
 
-	// create resized image
-	newImage := imaging.Resize(bigImage, width, height, imaging.Lanczos)
-
-	// generate the image in the source format and write
-	newImagePath := filepath.FromSlash(w.Opt.Dir.Cache + "/image/" + img.TrueName())
-	err = imaging.Save(newImage, newImagePath)
+	// resize every frame of a GIF, or just resize the image.
+	// then generate the image in the requested format and write
+	if srcExt == "gif" && img.Ext == "gif" && !transform {
+		err = w.generateGIF(bigPath, newImagePath, width, height)
+	} else {
+		if transform {
+			bigImage = transformImage(bigImage, img.ImageTransform, width, height)
+		} else if width != bigW || height != bigH {
+			bigImage = imaging.Resize(bigImage, width, height, imaging.Lanczos)
+		}
+		err = saveImage(newImagePath, bigImage, img.Ext, w.Opt().Image.Quality)
+	}
 	if err != nil {
 		return DisplayError{
 			Error:         "Failed to generate image.",
@@ -522,6 +800,94 @@ func (w *Wiki) generateImage(img SizedImage, bigPath string, bigW, bigH int, r *
 	return nil // success
 }
 
+// resizes an animated GIF
+func (w *Wiki) generateGIF(bigPath, newImagePath string, width, height int) error {
+	in, err := os.Open(bigPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createAtomic(newImagePath)
+	if err != nil {
+		return err
+	}
+	err = resizeGIF(bufio.NewReader(in), out, width, height)
+	return finishAtomic(out, newImagePath, err)
+}
+
+// crops an image and fits it into the given dimensions, without enlarging it
+func transformImage(img image.Image, t wikifier.ImageTransform, width, height int) image.Image {
+	b := img.Bounds()
+	region := image.Rect(0, 0, b.Dx(), b.Dy())
+	if len(t.Crop) == 4 {
+		region = cropRect(t.Crop, b.Dx(), b.Dy())
+	}
+
+	// for cover, crop to the aspect ratio of the requested dimensions,
+	// as close to centered on the focal point as possible
+	if t.Fit == "cover" && width != 0 && height != 0 {
+		fx, fy := region.Min.X+region.Dx()/2, region.Min.Y+region.Dy()/2
+		if len(t.Focus) == 2 {
+			fx, fy = t.Focus[0], t.Focus[1]
+		}
+		region = coverRect(region, width, height, fx, fy)
+	}
+
+	img = imaging.Crop(img, region.Add(b.Min))
+
+	// fit into the dimensions, unless they're larger than what we have
+	if width == 0 || height == 0 || width > region.Dx() || height > region.Dy() {
+		return img
+	}
+	if width == region.Dx() && height == region.Dy() {
+		return img
+	}
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+// returns the largest rectangle within r with the aspect ratio of
+// width:height, centered on x, y where possible
+func coverRect(r image.Rectangle, width, height, x, y int) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if w*height > h*width {
+		w = int(math.Max(1, math.Floor(float64(h)*float64(width)/float64(height)+0.5)))
+	} else {
+		h = int(math.Max(1, math.Floor(float64(w)*float64(height)/float64(width)+0.5)))
+	}
+	x0 := clampInt(x-w/2, r.Min.X, r.Max.X-w)
+	y0 := clampInt(y-h/2, r.Min.Y, r.Max.Y-h)
+	return image.Rect(x0, y0, x0+w, y0+h)
+}
+
+// returns the crop region within an image of the given dimensions
+func cropRect(crop []int, bigW, bigH int) image.Rectangle {
+	r := image.Rect(crop[0], crop[1], crop[0]+crop[2], crop[1]+crop[3])
+	r = r.Intersect(image.Rect(0, 0, bigW, bigH))
+
+	// nothing left, so use the whole image
+	if r.Empty() {
+		return image.Rect(0, 0, bigW, bigH)
+	}
+	return r
+}
+
+func clampInt(i, min, max int) int {
+	if i > max {
+		i = max
+	}
+	if i < min {
+		i = min
+	}
+	return i
+}
+
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
 // symlinks scaled cache file e.g. 100x200-asdf@2x.jpg -> 200x400-asdf.jpg
 func (w *Wiki) symlinkScaledImage(img SizedImage, name string) {
 
@@ -532,7 +898,7 @@ func (w *Wiki) symlinkScaledImage(img SizedImage, name string) {
 
 	// only symlink if this is a supported scale
 	ok := false
-	for _, scale := range w.Opt.Image.Retina {
+	for _, scale := range w.Opt().Image.Retina {
 		if scale == img.Scale {
 			ok = true
 			break
@@ -543,7 +909,7 @@ func (w *Wiki) symlinkScaledImage(img SizedImage, name string) {
 	}
 
 	w.Debugf("symlink image: %s -> %s", name, img.ScaleName())
-	scalePath := filepath.FromSlash(w.Opt.Dir.Cache + "/image/" + img.ScaleName())
+	scalePath := filepath.FromSlash(w.Opt().Dir.Cache + "/image/" + img.ScaleName())
 	os.Symlink(filepath.Base(name), scalePath)
 }
 
@@ -553,6 +919,12 @@ func getImageDimensions(path string) (w, h int) {
 	if err != nil {
 		return
 	}
+
+	// vector images
+	if strings.ToLower(filepath.Ext(path)) == ".svg" {
+		return getSVGDimensions(file)
+	}
+
 	c, _, _ := image.DecodeConfig(file)
 	w = c.Width
 	h = c.Height
@@ -0,0 +1,151 @@
+package wiki
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how long to wait for each external URL
+const linkCheckTimeout = 10 * time.Second
+
+// LinkCheckReport describes the last broken link check.
+type LinkCheckReport struct {
+	Running  bool         `json:"running"`            // true if the check is in progress
+	External bool         `json:"external"`           // true if external URLs were requested
+	Links    []BrokenLink `json:"links,omitempty"`    // broken links found
+	Started  *time.Time   `json:"started,omitempty"`  // time the check started
+	Finished *time.Time   `json:"finished,omitempty"` // time the check finished
+}
+
+// linkChecker keeps track of broken link checks in the background
+type linkChecker struct {
+	mu     sync.Mutex
+	report LinkCheckReport
+	done   chan struct{} // closed when the current check finishes
+}
+
+// networks which external link checks may not request, so that page
+// content cannot be used to probe the server's own network
+var privateNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+// StartLinkCheck begins checking for broken links in the background and
+// returns a channel which is closed when it finishes. See LinkCheckReport
+// for the results.
+//
+// If external is true, external URLs are requested as well, except those
+// on loopback and private networks.
+//
+// If a check is already running, its channel is returned instead.
+//
+func (w *Wiki) StartLinkCheck(external bool) <-chan struct{} {
+	c := &w.linkCheck
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// already running
+	if c.report.Running {
+		return c.done
+	}
+
+	now := time.Now()
+	c.report = LinkCheckReport{Running: true, External: external, Started: &now}
+	c.done = make(chan struct{})
+	go w.checkLinks(external, c.done)
+	return c.done
+}
+
+// LinkCheckReport returns the results of the current or last broken link
+// check.
+func (w *Wiki) LinkCheckReport() LinkCheckReport {
+	w.linkCheck.mu.Lock()
+	defer w.linkCheck.mu.Unlock()
+	report := w.linkCheck.report
+	report.Links = append([]BrokenLink(nil), report.Links...)
+	return report
+}
+
+// checkLinks runs a broken link check and stores the results
+func (w *Wiki) checkLinks(external bool, done chan struct{}) {
+	var client HTTPClient
+	if external {
+		client = publicHTTPClient()
+	}
+	links := w.BrokenLinksExternal(client)
+
+	c := &w.linkCheck
+	c.mu.Lock()
+	now := time.Now()
+	c.report.Running = false
+	c.report.Links = links
+	c.report.Finished = &now
+	c.mu.Unlock()
+	close(done)
+}
+
+// publicHTTPClient returns an HTTP client which refuses to connect to
+// loopback, private, and link-local addresses. the address is checked after
+// it is resolved, so this applies to redirects and DNS names too
+func publicHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: linkCheckTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return errors.New("refusing to connect to non-public address " + host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: linkCheckTimeout,
+		Transport: &http.Transport{
+			Proxy:               nil, // the proxy could reach private addresses
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: linkCheckTimeout,
+		},
+	}
+}
+
+// returns whether an IP address is on the public internet
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
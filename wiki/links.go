@@ -0,0 +1,313 @@
+package wiki
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cooper/quiki/wikifier"
+)
+
+// LinkKind describes what a link points to.
+type LinkKind string
+
+const (
+	// LinkKindPage is a link to a page on the wiki.
+	LinkKindPage LinkKind = "page"
+
+	// LinkKindCategory is a link to a category on the wiki.
+	LinkKindCategory LinkKind = "category"
+
+	// LinkKindExternal is a link to another website or external wiki.
+	LinkKindExternal LinkKind = "external"
+
+	// LinkKindAnchor is a link to a #section on a page.
+	LinkKindAnchor LinkKind = "anchor"
+)
+
+// BrokenLink describes a link which does not lead anywhere.
+type BrokenLink struct {
+	Page   string   `json:"page"`   // name of the page containing the link
+	Line   int      `json:"line"`   // line number of the link
+	Column int      `json:"column"` // column of the link
+	Target string   `json:"target"` // page name, category name, or URL
+	Kind   LinkKind `json:"kind"`   // type of link
+	Reason string   `json:"reason"` // why the link is broken
+}
+
+// Backlink describes a page which links to another page.
+type Backlink struct {
+	wikifier.PageInfo       // info for the page containing the links
+	Lines             []int `json:"lines,omitempty"` // line numbers on which the links occur
+}
+
+// HTTPClient sends HTTP requests. *http.Client satisfies this interface.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// number of external links checked at once
+const linkCheckWorkers = 8
+
+// a link found on a page, while checking for broken links
+type pageLink struct {
+	page string
+	wikifier.Link
+}
+
+// Backlinks returns the pages which link to the given page, sorted by title,
+// along with the line numbers of the links.
+//
+// Links are tracked as pages are generated, so pages which have not yet been
+// generated are not included. Pages which stopped linking here are removed
+// when they are changed through the wiki methods or the monitor.
+//
+// This only reads the tracking category, so it is cheap enough to call
+// while displaying a page.
+//
+func (w *Wiki) Backlinks(pageName string) []Backlink {
+	page := w.FindPage(pageName)
+
+	// no pages link here
+	cat := w.GetSpecialCategory(page.NameNE(), CategoryTypePage)
+	if !cat.Exists() {
+		return nil
+	}
+
+	backlinks := make([]Backlink, 0, len(cat.Pages))
+	for name, entry := range cat.Pages {
+
+		// the page was deleted
+		if _, err := os.Lstat(w.pathForPage(name)); err != nil {
+			continue
+		}
+
+		backlinks = append(backlinks, Backlink{entry.PageInfo, entry.Lines})
+	}
+	sort.Slice(backlinks, func(i, j int) bool {
+		iTitle, jTitle := backlinks[i].Title, backlinks[j].Title
+		if iTitle == "" {
+			iTitle = backlinks[i].FileNE
+		}
+		if jTitle == "" {
+			jTitle = backlinks[j].FileNE
+		}
+		return strings.ToLower(iTitle) < strings.ToLower(jTitle)
+	})
+	return backlinks
+}
+
+// BrokenLinks returns a report of the links on all pages which point to
+// pages, categories, or #section anchors that do not exist, as well as
+// links to undefined external wikis.
+//
+// Every page is parsed, so this can take a while on large wikis.
+// External URLs are not requested; see BrokenLinksExternal. To check in the
+// background and keep the results, use StartLinkCheck.
+//
+func (w *Wiki) BrokenLinks() []BrokenLink {
+	return w.BrokenLinksExternal(nil)
+}
+
+// BrokenLinksExternal is like BrokenLinks, except that if client is non-nil,
+// it is also used to request each external URL. Those which fail or respond
+// with an HTTP error status are reported as broken.
+//
+// The client may request any URL found in page content, so it should refuse
+// addresses which are not meant to be reachable by wiki editors.
+//
+func (w *Wiki) BrokenLinksExternal(client HTTPClient) []BrokenLink {
+	var links []pageLink
+	var broken []BrokenLink
+	headings := make(map[string]map[string]bool)
+
+	// parse each page, collecting its links and heading IDs
+	for _, name := range w.allPageFiles() {
+		page := w.FindPage(name)
+
+		// redirects have no content
+		if page.IsSymlink() {
+			continue
+		}
+		if err := page.Parse(); err != nil {
+			continue
+		}
+		page.HTML()
+
+		ids := make(map[string]bool)
+		for _, id := range page.HeadingIDs() {
+			ids[id] = true
+		}
+		headings[page.NameNE()] = ids
+
+		for _, link := range page.Links() {
+			links = append(links, pageLink{page.NameNE(), link})
+		}
+	}
+
+	// check each link
+	var external []pageLink
+	for _, link := range links {
+		report := BrokenLink{
+			Page:   link.page,
+			Line:   link.Pos.Line,
+			Column: link.Pos.Column,
+			Target: link.Target,
+		}
+		switch link.Type {
+
+		// page, maybe with section
+		case "internal":
+			target := strings.TrimPrefix(link.Target, w.Opt().Root.Page+"/")
+			pageName, sec := target, ""
+			if hashIdx := strings.IndexByte(target, '#'); hashIdx != -1 {
+				pageName, sec = target[:hashIdx], target[hashIdx+1:]
+			}
+
+			// page does not exist
+			if !link.Ok {
+				report.Target = pageName
+				report.Kind = LinkKindPage
+				report.Reason = "page does not exist"
+				broken = append(broken, report)
+				continue
+			}
+
+			// section on the same page
+			if pageName == "" {
+				pageName = link.page
+			}
+
+			// section does not exist. if the page is not in the map, it is
+			// a redirect or failed to parse, so we can't check
+			if ids, ok := headings[pageName]; ok && sec != "" && !ids[sec] {
+				report.Target = pageName + "#" + sec
+				report.Kind = LinkKindAnchor
+				report.Reason = "section does not exist"
+				broken = append(broken, report)
+			}
+
+		// category
+		case "category":
+			if !link.Ok {
+				report.Target = strings.TrimPrefix(link.Target, w.Opt().Root.Category+"/")
+				report.Kind = LinkKindCategory
+				report.Reason = "category does not exist"
+				broken = append(broken, report)
+			}
+
+		// external wiki
+		case "external":
+			if !link.Ok {
+				report.Kind = LinkKindExternal
+				report.Reason = "external wiki does not exist"
+				broken = append(broken, report)
+				continue
+			}
+			external = append(external, link)
+
+		// other URL
+		case "other":
+			external = append(external, link)
+		}
+	}
+
+	// check external URLs
+	if client != nil {
+		broken = append(broken, checkExternalLinks(client, external)...)
+	}
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].Page != broken[j].Page {
+			return broken[i].Page < broken[j].Page
+		}
+		return broken[i].Line < broken[j].Line
+	})
+	return broken
+}
+
+// requests each external URL once, returning the links which failed
+func checkExternalLinks(client HTTPClient, links []pageLink) []BrokenLink {
+
+	// find unique URLs
+	var urls []string
+	results := make(map[string]string)
+	for _, link := range links {
+		if !strings.HasPrefix(link.Target, "http://") && !strings.HasPrefix(link.Target, "https://") {
+			continue
+		}
+		if _, exist := results[link.Target]; !exist {
+			results[link.Target] = ""
+			urls = append(urls, link.Target)
+		}
+	}
+
+	// check them in parallel
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	queue := make(chan string)
+	for i := 0; i < linkCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range queue {
+				reason := checkExternalLink(client, target)
+				mu.Lock()
+				results[target] = reason
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, target := range urls {
+		queue <- target
+	}
+	close(queue)
+	wg.Wait()
+
+	// report each link to a failed URL
+	var broken []BrokenLink
+	for _, link := range links {
+		if reason := results[link.Target]; reason != "" {
+			broken = append(broken, BrokenLink{
+				Page:   link.page,
+				Line:   link.Pos.Line,
+				Column: link.Pos.Column,
+				Target: link.Target,
+				Kind:   LinkKindExternal,
+				Reason: reason,
+			})
+		}
+	}
+	return broken
+}
+
+// requests a URL, returning the reason it is broken or an empty string
+func checkExternalLink(client HTTPClient, target string) string {
+
+	// try HEAD first, falling back to GET for servers which don't allow it
+	var res *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return err.Error()
+		}
+		res, err = client.Do(req)
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err.Error()
+		} else if err != nil {
+			return err.Error()
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusMethodNotAllowed && res.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+
+	if res.StatusCode >= 400 {
+		return "HTTP " + res.Status
+	}
+	return ""
+}
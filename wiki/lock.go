@@ -0,0 +1,112 @@
+package wiki
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cooper/quiki/wikifier"
+)
+
+// lockTable provides a mutex for each name in a set, such as pages or
+// categories, so that each can be changed by only one goroutine at a time.
+// the zero value is ready to use
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// get returns the mutex for a name, creating it if needed
+func (t *lockTable) get(name string) *sync.Mutex {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.locks == nil {
+		t.locks = make(map[string]*sync.Mutex)
+	}
+	lock, exist := t.locks[name]
+	if !exist {
+		lock = new(sync.Mutex)
+		t.locks[name] = lock
+	}
+	return lock
+}
+
+// createAtomic creates a temporary file in the same directory as path.
+// call finishAtomic with it when done writing
+func createAtomic(path string) (*os.File, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	file.Chmod(0644)
+	return file, nil
+}
+
+// finishAtomic closes a file from createAtomic and, if there were no
+// errors, moves it to path. otherwise it is removed
+func finishAtomic(file *os.File, path string, err error) error {
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// writeFileAtomic is like ioutil.WriteFile, except the file is replaced
+// atomically, so readers never see it partially written
+func writeFileAtomic(path string, data []byte) error {
+	file, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return finishAtomic(file, path, err)
+}
+
+// fileState is the content of a file at some point, so that it can be
+// written again later
+type fileState struct {
+	exist   bool   // false if there was no file
+	link    bool   // true if the file is a symlink to content
+	content []byte // file content or symlink target
+}
+
+// readFileState reads the state of a file, which need not exist.
+// symlinks are not followed
+func readFileState(path string) (fileState, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return fileState{}, nil
+	}
+	if err != nil {
+		return fileState{}, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return fileState{exist: true, link: true, content: []byte(target)}, err
+	}
+	content, err := ioutil.ReadFile(path)
+	return fileState{exist: true, content: content}, err
+}
+
+// write replaces the file at path with this state, or removes it if there
+// was no file
+func (s fileState) write(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !s.exist {
+		return nil
+	}
+	wikifier.MakeDir(filepath.Dir(path), "")
+	if s.link {
+		return os.Symlink(string(s.content), path)
+	}
+	return ioutil.WriteFile(path, s.content, 0644)
+}
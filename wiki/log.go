@@ -31,6 +31,14 @@ func (w *Wiki) Debugf(format string, i ...interface{}) {
 }
 
 func (w *Wiki) logger() *log.Logger {
+	w.logMu.Lock()
+	defer w.logMu.Unlock()
+
+	// we've already opened the log
+	if w._logger != nil {
+		return w._logger
+	}
+
 	// consider: if wiki is ever destoryed, need to close this
 	f, err := os.OpenFile(w.Dir("cache", "wiki.log"),
 		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
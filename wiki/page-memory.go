@@ -0,0 +1,214 @@
+package wiki
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cooper/quiki/wikifier"
+)
+
+// how often pages held in memory are checked for changed dependencies.
+// changes made through the wiki or noticed by the monitor are purged
+// immediately; this only catches those made some other way
+const pageMemoryRecheck = time.Second
+
+// MemoryCacheStats describes the pages held in memory.
+type MemoryCacheStats struct {
+	Pages    int   `json:"pages"`     // number of pages in memory
+	Bytes    int64 `json:"bytes"`     // size of their content and CSS
+	MaxBytes int64 `json:"max_bytes"` // limit from page.cache.memory
+	Hits     int64 `json:"hits"`      // requests served from memory
+	Misses   int64 `json:"misses"`    // requests which were not
+}
+
+// pageMemory is a size-bounded, least-recently-used set of cached page
+// display results, so that popular pages are served without reading and
+// decoding their cache files. the zero value is ready to use
+type pageMemory struct {
+	mu     sync.Mutex
+	list   *list.List               // most recently used first
+	items  map[string]*list.Element // page name -> element
+	bytes  int64
+	hits   int64
+	misses int64
+}
+
+// pageMemoryEntry is a page held in memory
+type pageMemoryEntry struct {
+	name    string
+	page    DisplayPage
+	deps    []pageDependency
+	cached  time.Time // modified time of the cache file it was read from
+	checked time.Time // last time deps were checked
+	size    int64
+}
+
+// displayPageFromMemory returns the display result for a page held in
+// memory, or nil if it is not there or is out of date
+func (w *Wiki) displayPageFromMemory(page *wikifier.Page, draftOK bool) interface{} {
+	if w.pageMemoryMax() == 0 {
+		return nil
+	}
+	name := page.Name()
+	entry, ok := w.pageMem.get(name)
+	if ok && !w.pageMemoryCurrent(page, entry) {
+		w.Debugf("display page: %s: discarding outdated copy in memory", name)
+		w.pageMem.remove(name)
+		ok = false
+	}
+	w.pageMem.count(ok)
+	if !ok {
+		return nil
+	}
+
+	// if this is a draft and we're not serving drafts, pretend
+	// that the page does not exist
+	if !draftOK && entry.page.Draft {
+		return DisplayError{Error: "Page has not yet been published.", Draft: true}
+	}
+
+	return entry.page
+}
+
+// pageMemoryCurrent returns whether a page held in memory is the same as its
+// cache file, and whether it is still up-to-date
+func (w *Wiki) pageMemoryCurrent(page *wikifier.Page, entry pageMemoryEntry) bool {
+
+	// the cache file was deleted or written again
+	fi, err := os.Lstat(page.CachePath())
+	if err != nil || !fi.ModTime().Equal(entry.cached) {
+		return false
+	}
+
+	// the page's file is more recent than the cache file
+	if page.Modified().After(entry.cached) {
+		return false
+	}
+
+	// something the page uses has changed since it was generated
+	if time.Since(entry.checked) < pageMemoryRecheck {
+		return true
+	}
+	if w.dependencyChanged(entry.deps, entry.cached) {
+		return false
+	}
+	w.pageMem.setChecked(entry.name, time.Now())
+	return true
+}
+
+// rememberPage holds a page display result read from its cache file in
+// memory, if it fits
+func (w *Wiki) rememberPage(r DisplayPage, deps []pageDependency, cached time.Time) {
+	max := w.pageMemoryMax()
+	if max == 0 {
+		return
+	}
+	w.pageMem.add(pageMemoryEntry{
+		name:    r.File,
+		page:    r,
+		deps:    deps,
+		cached:  cached,
+		checked: time.Now(),
+		size:    int64(len(r.Content) + len(r.CSS)),
+	}, max)
+}
+
+// pageMemoryMax returns the maximum size of pages held in memory in bytes
+func (w *Wiki) pageMemoryMax() int64 {
+	if !w.Opt().Page.EnableCache {
+		return 0
+	}
+	return int64(w.Opt().Page.CacheMemory) << 20
+}
+
+// get returns a page and marks it as most recently used
+func (m *pageMemory) get(name string) (pageMemoryEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[name]
+	if !ok {
+		return pageMemoryEntry{}, false
+	}
+	m.list.MoveToFront(el)
+	return *el.Value.(*pageMemoryEntry), true
+}
+
+// add adds or replaces a page, removing the least recently used pages
+// until there is room for it
+func (m *pageMemory) add(entry pageMemoryEntry, max int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(entry.name)
+	if entry.size > max {
+		return
+	}
+	if m.list == nil {
+		m.list = list.New()
+		m.items = make(map[string]*list.Element)
+	}
+	for m.bytes+entry.size > max {
+		m.removeLocked(m.list.Back().Value.(*pageMemoryEntry).name)
+	}
+	m.items[entry.name] = m.list.PushFront(&entry)
+	m.bytes += entry.size
+}
+
+// remove removes a page if it is present
+func (m *pageMemory) remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(name)
+}
+
+// removeLocked removes a page. the lock must be held
+func (m *pageMemory) removeLocked(name string) {
+	el, ok := m.items[name]
+	if !ok {
+		return
+	}
+	m.list.Remove(el)
+	delete(m.items, name)
+	m.bytes -= el.Value.(*pageMemoryEntry).size
+}
+
+// clear removes all pages. the counters are kept
+func (m *pageMemory) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.list = nil
+	m.items = nil
+	m.bytes = 0
+}
+
+// setChecked updates the last time a page's dependencies were checked
+func (m *pageMemory) setChecked(name string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[name]; ok {
+		el.Value.(*pageMemoryEntry).checked = t
+	}
+}
+
+// count counts a hit or a miss
+func (m *pageMemory) count(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+}
+
+func (m *pageMemory) stats() MemoryCacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return MemoryCacheStats{
+		Pages:  len(m.items),
+		Bytes:  m.bytes,
+		Hits:   m.hits,
+		Misses: m.misses,
+	}
+}
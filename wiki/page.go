@@ -1,6 +1,7 @@
 package wiki
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -9,7 +10,6 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	httpdate "github.com/Songmu/go-httpdate"
@@ -92,11 +92,15 @@ type DisplayPage struct {
 
 	// first formatting-stripped 25 words of page, up to 150 chars
 	Preview string `json:"preview,omitempty"`
+
+	// references cited on the page, as collected from [ref: ...], [1], and ref{}
+	References []wikifier.Reference `json:"references,omitempty"`
 }
 
 type pageJSONManifest struct {
-	CSS        string   `json:"css,omitempty"`
-	Categories []string `json:"categories,omitempty"`
+	CSS          string           `json:"css,omitempty"`
+	Categories   []string         `json:"categories,omitempty"`
+	Dependencies []pageDependency `json:"deps,omitempty"`
 	wikifier.PageInfo
 }
 
@@ -122,7 +126,7 @@ func (w *Wiki) FindPage(name string) (p *wikifier.Page) {
 	}
 	path := ""
 	for _, try := range tryFiles {
-		path = filepath.Join(w.Opt.Dir.Page, pfx, try)
+		path = filepath.Join(w.Opt().Dir.Page, pfx, try)
 		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
 			break
 		}
@@ -140,12 +144,7 @@ func (w *Wiki) FindPage(name string) (p *wikifier.Page) {
 
 	// these are available to all pages
 	p.Wiki = w
-	p.Opt = &w.Opt
-
-	// create page lock
-	if _, exist := w.pageLocks[p.Name()]; !exist {
-		w.pageLocks[p.Name()] = new(sync.Mutex)
-	}
+	p.Opt = w.Opt()
 
 	return
 }
@@ -186,13 +185,20 @@ func (w *Wiki) DisplayPageDraft(name string, draftOK bool) interface{} {
 	}
 
 	// caching is enabled, so serve the cached copy if available
-	if w.Opt.Page.EnableCache && page.CacheExists() {
-		if errOrRedir := w.displayCachedPage(page, &r, draftOK); errOrRedir != nil {
-			return errOrRedir
-		}
-		if r.FromCache {
-			return r
-		}
+	if res := w.displayPageFromMemory(page, draftOK); res != nil {
+		return res
+	}
+	if res := w.displayPageFromCache(page, &r, draftOK); res != nil {
+		return res
+	}
+
+	// only generate once at a time. if another request generated the page
+	// while we were waiting, serve that instead
+	lock := w.pageLocks.get(r.File)
+	lock.Lock()
+	defer lock.Unlock()
+	if res := w.displayPageFromCache(page, &r, draftOK); res != nil {
+		return res
 	}
 
 	// Safe point - we will be generating the page right now.
@@ -242,10 +248,6 @@ func (w *Wiki) DisplayPageDraft(name string, draftOK bool) interface{} {
 		return DisplayRedirect{Redirect: redir}
 	}
 
-	// only generate once at a time
-	w.pageLocks[r.File].Lock()
-	defer w.pageLocks[r.File].Unlock()
-
 	// generate HTML and metadata
 	create := page.Created()
 	if !create.IsZero() {
@@ -265,6 +267,7 @@ func (w *Wiki) DisplayPageDraft(name string, draftOK bool) interface{} {
 	r.Content = page.HTML()
 	r.CSS = page.CSS()
 	r.Warnings = page.Warnings
+	r.References = page.References()
 
 	// update categories
 	w.updatePageCategories(page)
@@ -400,21 +403,13 @@ func (w *Wiki) writeVarsCache(page *wikifier.Page) {
 		return
 	}
 
-	// open the cache file for writing
-	cacheFile, err := os.Create(page.CachePath())
-	defer cacheFile.Close()
-	if err != nil {
-		return
-	}
-
 	// create manifest with just page info (includes redirect/error)
 	j, err := json.Marshal(pageJSONManifest{PageInfo: page.Info()})
 	if err != nil {
 		return
 	}
 
-	cacheFile.Write(j)
-	cacheFile.Write([]byte{'\n'})
+	writeFileAtomic(page.CachePath(), append(j, '\n'))
 }
 
 func (w *Wiki) writePageCache(page *wikifier.Page, r *DisplayPage) interface{} {
@@ -424,9 +419,9 @@ func (w *Wiki) writePageCache(page *wikifier.Page, r *DisplayPage) interface{} {
 		return nil
 	}
 
-	// open the cache file for writing
-	cacheFile, err := os.Create(page.CachePath())
-	defer cacheFile.Close()
+	// open the cache file for writing.
+	// it is replaced all at once so that it is never read partially written
+	cacheFile, err := createAtomic(page.CachePath())
 	if err != nil {
 		return DisplayError{
 			Error:         "Could not write page cache file.",
@@ -436,14 +431,16 @@ func (w *Wiki) writePageCache(page *wikifier.Page, r *DisplayPage) interface{} {
 
 	// generate page info
 	info := pageJSONManifest{
-		CSS:        r.CSS,
-		Categories: r.Categories,
-		PageInfo:   page.Info(),
+		CSS:          r.CSS,
+		Categories:   r.Categories,
+		Dependencies: w.pageDependencies(page),
+		PageInfo:     page.Info(),
 	}
 
 	// encode as json
 	j, err := json.Marshal(info)
 	if err != nil {
+		finishAtomic(cacheFile, page.CachePath(), err)
 		return DisplayError{
 			Error:         "Could not write page cache file.",
 			DetailedError: "JSON encode error: " + err.Error(),
@@ -460,6 +457,12 @@ func (w *Wiki) writePageCache(page *wikifier.Page, r *DisplayPage) interface{} {
 	if len(content) != 0 && content[len(content)-1] != '\n' {
 		cacheFile.Write([]byte{'\n'})
 	}
+	if err := finishAtomic(cacheFile, page.CachePath(), nil); err != nil {
+		return DisplayError{
+			Error:         "Could not write page cache file.",
+			DetailedError: "Write '" + page.CachePath() + "' error: " + err.Error(),
+		}
+	}
 
 	// update result with real cache modified times
 	mod := page.CacheModified()
@@ -477,23 +480,54 @@ func (w *Wiki) writePageText(page *wikifier.Page, r *DisplayPage) interface{} {
 		return nil
 	}
 
-	// open the text file for writing
-	textFile, err := os.Create(page.SearchPath())
-	defer textFile.Close()
-	if err != nil {
+	// save the content with HTML tags stripped
+	text := page.Text()
+	if err := writeFileAtomic(page.SearchPath(), []byte(text)); err != nil {
 		return DisplayError{
 			Error:         "Could not write page text file.",
-			DetailedError: "Open '" + page.SearchPath() + "' for write error: " + err.Error(),
+			DetailedError: "Write '" + page.SearchPath() + "' error: " + err.Error(),
 		}
 	}
 
-	// save the content with HTML tags stripped
-	textFile.WriteString(page.Text())
+	// update the search index
+	w.search.add(page.Name(), text)
 
 	r.TextGenerated = true
 	return nil // success
 }
 
+// displayPageFromCache returns the display result for a page from its
+// cache, or nil if caching is disabled or the page must be generated
+func (w *Wiki) displayPageFromCache(page *wikifier.Page, r *DisplayPage, draftOK bool) interface{} {
+	if !w.Opt().Page.EnableCache || !page.CacheExists() {
+		return nil
+	}
+	if errOrRedir := w.displayCachedPage(page, r, draftOK); errOrRedir != nil {
+		return errOrRedir
+	}
+	if r.FromCache {
+		return *r
+	}
+	return nil
+}
+
+// readPageCacheManifest reads only the manifest from the first line of a
+// page cache file
+func readPageCacheManifest(path string) (pageJSONManifest, error) {
+	var info pageJSONManifest
+	file, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+	jsonData, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(jsonData, &info)
+	return info, err
+}
+
 func (w *Wiki) displayCachedPage(page *wikifier.Page, r *DisplayPage, draftOK bool) interface{} {
 	cacheModify := page.CacheModified()
 	timeStr := httpdate.Time2Str(cacheModify)
@@ -545,6 +579,14 @@ func (w *Wiki) displayCachedPage(page *wikifier.Page, r *DisplayPage, draftOK bo
 		}
 	}
 
+	// something the page uses has changed since it was generated.
+	// discard the outdated cached copy
+	if w.dependencyChanged(info.Dependencies, cacheModify) {
+		w.Debugf("display page: %s: dependency changed; discarding cache", page.Name())
+		os.Remove(page.CachePath())
+		return nil // OK
+	}
+
 	// cached error
 	if info.Error != nil {
 		return DisplayError{
@@ -570,12 +612,16 @@ func (w *Wiki) displayCachedPage(page *wikifier.Page, r *DisplayPage, draftOK bo
 	r.Description = info.Description
 	r.Keywords = info.Keywords
 	r.Warnings = info.Warnings
+	r.References = info.References
 	r.FromCache = true
 	r.CSS = info.CSS
 	r.Content = wikifier.HTML(content)
 	r.Modified = &cacheModify
 	r.ModifiedHTTP = httpdate.Time2Str(cacheModify)
 
+	// serve it from memory next time
+	w.rememberPage(*r, info.Dependencies, cacheModify)
+
 	return nil // success
 }
 
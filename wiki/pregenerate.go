@@ -1,14 +1,292 @@
 package wiki
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cooper/quiki/wikifier"
+)
+
+// PregenerateProgress describes the progress of pregeneration.
+type PregenerateProgress struct {
+	Running  bool               `json:"running"`            // true if pregeneration is in progress
+	Total    int                `json:"total"`              // number of pages
+	Done     int                `json:"done"`               // number of pages finished, including skipped
+	Skipped  int                `json:"skipped"`            // number of pages unchanged since last time
+	Errors   []PregenerateError `json:"errors,omitempty"`   // pages which could not be generated
+	Started  *time.Time         `json:"started,omitempty"`  // time pregeneration started
+	Finished *time.Time         `json:"finished,omitempty"` // time pregeneration finished
+}
+
+// PregenerateError is an error which occurred while pregenerating a page.
+type PregenerateError struct {
+	Page  string `json:"page"`  // page filename
+	Error string `json:"error"` // error message
+}
+
+// pregenerator keeps track of pregeneration in the background
+type pregenerator struct {
+	mu       sync.Mutex
+	progress PregenerateProgress
+	done     chan struct{}   // closed when the current run finishes
+	again    bool            // run again after the current run finishes
+	pages    map[string]bool // pages currently being pregenerated
+	images   []*imageJob     // images queued by the current run
+}
+
+// pregenManifestEntry is the last pregenerated state of a page
+type pregenManifestEntry struct {
+	Hash  string `json:"hash"`            // SHA-256 of the page source
+	Error string `json:"error,omitempty"` // error from generating the page
+}
+
 // Pregenerate simulates requests for all wiki resources
 // such that content caches can be pregenerated and stored.
+//
+// Pages are generated in parallel. Pages whose source has not changed since
+// they were last pregenerated and whose cache is still up-to-date are
+// skipped. Images used on each page are generated as well.
+//
+// This blocks until finished. Use StartPregenerate to pregenerate in the
+// background.
+//
 func (w *Wiki) Pregenerate() {
-	w.pregenerating = true
+	<-w.StartPregenerate()
+}
+
+// StartPregenerate begins pregenerating in the background and returns a
+// channel which is closed when it finishes. See PregenerateProgress to check
+// on it.
+//
+// If pregeneration is already running, it runs once more afterward, so that
+// anything which changed in the meantime is included.
+//
+func (w *Wiki) StartPregenerate() <-chan struct{} {
+	p := &w.pregen
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// already running
+	if p.progress.Running {
+		p.again = true
+		return p.done
+	}
+
+	p.done = make(chan struct{})
+	p.reset()
+	go w.pregenerate(p.done)
+	return p.done
+}
+
+// PregenerateProgress returns the progress of the current or last
+// pregeneration.
+func (w *Wiki) PregenerateProgress() PregenerateProgress {
+	w.pregen.mu.Lock()
+	defer w.pregen.mu.Unlock()
+	progress := w.pregen.progress
+	progress.Errors = append([]PregenerateError(nil), progress.Errors...)
+	return progress
+}
+
+// pregenerate runs until there are no more requests to pregenerate
+func (w *Wiki) pregenerate(done chan struct{}) {
+	p := &w.pregen
+	for {
+		w.pregenerateAll()
+
+		p.mu.Lock()
+		if p.again {
+			p.again = false
+			p.reset()
+			p.mu.Unlock()
+			continue
+		}
+		now := time.Now()
+		p.progress.Running = false
+		p.progress.Finished = &now
+		p.mu.Unlock()
+
+		close(done)
+		return
+	}
+}
+
+// pregenerateAll generates all pages with a pool of workers
+func (w *Wiki) pregenerateAll() {
+	p := &w.pregen
+	names := w.allPageFiles()
+	p.mu.Lock()
+	p.progress.Total = len(names)
+	p.mu.Unlock()
+
+	// pages are skipped if they have not changed since last time
+	last := w.readPregenManifest()
+	manifest := make(map[string]pregenManifestEntry, len(names))
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				entry, skipped := w.pregeneratePage(name, last[name])
+
+				p.mu.Lock()
+				manifest[name] = entry
+				p.progress.Done++
+				if skipped {
+					p.progress.Skipped++
+				}
+				if entry.Error != "" {
+					p.progress.Errors = append(p.progress.Errors, PregenerateError{name, entry.Error})
+				}
+				p.mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	// wait for the images used on those pages
+	p.mu.Lock()
+	images := p.images
+	p.images = nil
+	p.mu.Unlock()
+	for _, job := range images {
+		<-job.done
+	}
+
+	// rebuild the search index from the fresh text files
+	if w.Opt().Search.Enable {
+		w.buildSearchIndex()
+	}
 
-	for _, pageName := range w.allPageFiles() {
-		w.Debug("pregen page:", pageName)
-		w.DisplayPageDraft(pageName, true)
+	w.writePregenManifest(manifest)
+}
+
+// pregeneratePage generates a page unless its source has not changed since
+// the last entry and its cache is still up-to-date
+func (w *Wiki) pregeneratePage(name string, last pregenManifestEntry) (entry pregenManifestEntry, skipped bool) {
+	page := w.FindPage(name)
+
+	// hash the source
+	content, err := ioutil.ReadFile(page.Path())
+	if err != nil {
+		entry.Error = err.Error()
+		return
+	}
+	sum := sha256.Sum256(content)
+	entry.Hash = hex.EncodeToString(sum[:])
+
+	// unchanged
+	if entry.Hash == last.Hash && w.pageCacheCurrent(page) {
+		return last, true
 	}
 
-	w.pregenerating = false
+	w.Debug("pregen page:", name)
+	w.pregen.setGenerating(page.Name(), true)
+	res := w.DisplayPageDraft(name, true)
+	w.pregen.setGenerating(page.Name(), false)
+
+	if dispErr, ok := res.(DisplayError); ok {
+		entry.Error = dispErr.Error
+	}
+	return
+}
+
+// pageCacheCurrent returns whether a page's cache is up-to-date, given that
+// its source has not changed. if the source was only touched, as happens
+// when switching branches, the cache is touched too so that it is still used
+func (w *Wiki) pageCacheCurrent(page *wikifier.Page) bool {
+	if !w.Opt().Page.EnableCache {
+		return false
+	}
+	fi, err := os.Stat(page.CachePath())
+	if err != nil {
+		return false
+	}
+	info, err := readPageCacheManifest(page.CachePath())
+	if err != nil || w.dependencyChanged(info.Dependencies, fi.ModTime()) {
+		return false
+	}
+	if page.Modified().After(fi.ModTime()) {
+		now := time.Now()
+		os.Chtimes(page.CachePath(), now, now)
+	}
+	return true
+}
+
+func (w *Wiki) pregenManifestPath() string {
+	return filepath.Join(w.Opt().Dir.Cache, "pregenerate.json")
+}
+
+// readPregenManifest returns the state of each page when it was last
+// pregenerated. if there is none, it is empty
+func (w *Wiki) readPregenManifest() map[string]pregenManifestEntry {
+	manifest := make(map[string]pregenManifestEntry)
+	data, err := ioutil.ReadFile(w.pregenManifestPath())
+	if err != nil {
+		return manifest
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		w.Logf("pregenerate: discarding bad manifest: %v", err)
+		return make(map[string]pregenManifestEntry)
+	}
+	return manifest
+}
+
+func (w *Wiki) writePregenManifest(manifest map[string]pregenManifestEntry) {
+	data, err := json.Marshal(manifest)
+	if err == nil {
+		err = writeFileAtomic(w.pregenManifestPath(), data)
+	}
+	if err != nil {
+		w.Logf("pregenerate: write manifest: %v", err)
+	}
+}
+
+// reset starts progress over. the lock must be held
+func (p *pregenerator) reset() {
+	now := time.Now()
+	p.progress = PregenerateProgress{Running: true, Started: &now}
+}
+
+// setGenerating marks whether a page is being generated by pregeneration
+func (p *pregenerator) setGenerating(name string, generating bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pages == nil {
+		p.pages = make(map[string]bool)
+	}
+	if generating {
+		p.pages[name] = true
+	} else {
+		delete(p.pages, name)
+	}
+}
+
+// addImage adds an image job which the current run waits for
+func (p *pregenerator) addImage(job *imageJob) {
+	p.mu.Lock()
+	p.images = append(p.images, job)
+	p.mu.Unlock()
+}
+
+// generating returns whether a page is being generated by pregeneration,
+// in which case the images it uses are generated too
+func (p *pregenerator) generating(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pages[name]
 }
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cooper/quiki/wikifier"
@@ -14,7 +16,10 @@ import (
 	"github.com/cooper/go-git/v4"
 	"github.com/cooper/go-git/v4/config"
 	"github.com/cooper/go-git/v4/plumbing"
+	"github.com/cooper/go-git/v4/plumbing/filemode"
+	"github.com/cooper/go-git/v4/plumbing/format/index"
 	"github.com/cooper/go-git/v4/plumbing/object"
+	"github.com/cooper/go-git/v4/utils/merkletrie"
 	"github.com/pkg/errors"
 )
 
@@ -46,6 +51,8 @@ var quikiCommitOpts = &git.CommitOptions{
 
 // repo fetches the wiki's git repository, creating it if needed.
 func (w *Wiki) repo() (repo *git.Repository, err error) {
+	w.repoMu.Lock()
+	defer w.repoMu.Unlock()
 
 	// we've already loaded the repository
 	if w._repo != nil {
@@ -175,31 +182,40 @@ func (w *Wiki) checkoutBranch(name string) (string, error) {
 	// TODO: make sure name is a simple string with no path elements
 
 	// make cache/branch/ if needed
-	wikifier.MakeDir(filepath.Join(w.Opt.Dir.Cache, "branch"), "")
+	wikifier.MakeDir(filepath.Join(w.Opt().Dir.Cache, "branch"), "")
 
 	// e.g. cache/branch/mybranchname
-	targetDir := filepath.Join(w.Opt.Dir.Cache, "branch", name)
-
-	// directory already exists, so I'm good with saying the branch is there
-	if fi, err := os.Stat(targetDir); err == nil && fi.IsDir() {
-		return targetDir, nil
-	}
+	targetDir := filepath.Join(w.Opt().Dir.Cache, "branch", name)
 
 	repo, err := w.repo()
 	if err != nil {
 		return "", err
 	}
 
+	// directory already exists, so I'm good with saying the branch is there.
+	// it may have been created before linked repos were repaired, though
+	if fi, err := os.Stat(targetDir); err == nil && fi.IsDir() {
+		if err = w.repairWorktree(repo, name, targetDir); err != nil {
+			return "", err
+		}
+		return targetDir, nil
+	}
+
 	// create the linked repository
 	if _, err = repo.PlainAddWorktree(name, targetDir, &git.AddWorktreeOptions{}); err != nil {
 		return "", err
 	}
 
+	// fix up the link
+	if err = w.repairWorktree(repo, name, targetDir); err != nil {
+		return "", err
+	}
+
 	return targetDir, nil
 }
 
 // the "and commit" portion of the *andCommit functions
-func (w *Wiki) andCommit(wt *git.Worktree, comment string, commit CommitOpts) error {
+func (w *Wiki) andCommit(wt *git.Worktree, comment string, commit CommitOpts, parents ...plumbing.Hash) error {
 	if commit.Comment != "" {
 		comment += ": " + commit.Comment
 	}
@@ -216,6 +232,7 @@ func (w *Wiki) andCommit(wt *git.Worktree, comment string, commit CommitOpts) er
 			Email: commit.Email,
 			When:  commit.Time,
 		},
+		Parents: parents,
 	})
 	if err != nil {
 		return errors.Wrap(err, "git:worktree:Commit")
@@ -239,6 +256,15 @@ func (w *Wiki) addAndCommit(path string, commit CommitOpts) error {
 		return errors.Wrap(err, "git:repo:Worktree")
 	}
 
+	// start from this branch. the index is shared with other branches,
+	// so nothing else may stage changes until this is committed
+	lock := indexLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := resetIndex(repo); err != nil {
+		return err
+	}
+
 	// add the file
 	_, err = wt.Add(path)
 	if err != nil {
@@ -263,6 +289,15 @@ func (w *Wiki) removeAndCommit(path string, commit CommitOpts) error {
 		return errors.Wrap(err, "git:repo:Worktree")
 	}
 
+	// start from this branch. the index is shared with other branches,
+	// so nothing else may stage changes until this is committed
+	lock := indexLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := resetIndex(repo); err != nil {
+		return err
+	}
+
 	// remove the file
 	_, err = wt.Remove(path)
 	if err != nil {
@@ -272,6 +307,49 @@ func (w *Wiki) removeAndCommit(path string, commit CommitOpts) error {
 	return w.andCommit(wt, "Delete "+filepath.Base(path), commit)
 }
 
+// changeAndCommit adds and removes several files, then commits the changes
+// as a single revision
+func (w *Wiki) changeAndCommit(add, remove []string, comment string, commit CommitOpts) error {
+
+	// get repo
+	repo, err := w.repo()
+	if err != nil {
+		return err
+	}
+
+	// get worktree
+	wt, err := repo.Worktree()
+	if err != nil {
+		return errors.Wrap(err, "git:repo:Worktree")
+	}
+
+	// start from this branch. the index is shared with other branches,
+	// so nothing else may stage changes until this is committed
+	lock := indexLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := resetIndex(repo); err != nil {
+		return err
+	}
+
+	// remove files first, in case any are being replaced
+	// untracked files only need to be removed from the filesystem
+	for _, path := range remove {
+		if _, err := wt.Remove(path); err == index.ErrEntryNotFound {
+			os.Remove(w.UnresolvedAbsFilePath(path))
+		} else if err != nil {
+			return errors.Wrap(err, "git:worktree:Remove")
+		}
+	}
+	for _, path := range add {
+		if _, err := wt.Add(path); err != nil {
+			return errors.Wrap(err, "git:worktree:Add")
+		}
+	}
+
+	return w.andCommit(wt, comment, commit)
+}
+
 // Branch returns a Wiki instance for this wiki at another branch.
 // If the branch does not exist, an error is returned.
 func (w *Wiki) Branch(name string) (*Wiki, error) {
@@ -351,6 +429,386 @@ func (w *Wiki) NewBranch(name string) (*Wiki, error) {
 	return w.Branch(name)
 }
 
+// ChangeType describes how a file was changed.
+type ChangeType string
+
+const (
+	// ChangeAdded means the file was created.
+	ChangeAdded ChangeType = "added"
+
+	// ChangeModified means the file content was changed.
+	ChangeModified ChangeType = "modified"
+
+	// ChangeDeleted means the file was deleted.
+	ChangeDeleted ChangeType = "deleted"
+)
+
+// BranchChange describes a file which was changed on a branch.
+type BranchChange struct {
+	File     string     `json:"file"`               // path relative to wiki directory
+	Page     string     `json:"page,omitempty"`     // page name, if the file is a page
+	Type     ChangeType `json:"type"`               // added, modified, or deleted
+	Conflict string     `json:"conflict,omitempty"` // reason the file cannot be merged, if any
+}
+
+// MergeConflictError is returned by MergeBranch when one or more files were
+// changed on both the branch and master.
+type MergeConflictError struct {
+	Branch    string         // branch name
+	Conflicts []BranchChange // files which cannot be merged
+}
+
+func (e *MergeConflictError) Error() string {
+	files := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		files[i] = c.File
+	}
+	return fmt.Sprintf("cannot merge %s: conflicts in %s", e.Branch, strings.Join(files, ", "))
+}
+
+// branchMerge is the state of a branch relative to master
+type branchMerge struct {
+	master  *object.Commit
+	branch  *object.Commit
+	changes []BranchChange
+}
+
+// CompareBranch returns the files changed on a branch since it diverged
+// from master.
+//
+// Files which were also changed on master in a different way have
+// Conflict set to the reason they cannot be merged.
+//
+func (w *Wiki) CompareBranch(name string) ([]BranchChange, error) {
+	m, err := w.prepareMerge(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.changes, nil
+}
+
+// MergeBranch merges the changes on a branch into master.
+//
+// If any file was changed on both the branch and master, nothing is merged,
+// and a *MergeConflictError listing each conflicting file is returned.
+// Otherwise, the changes are written to the master working tree and committed
+// as a merge. If the branch has no new changes, no commit is made.
+//
+func (w *Wiki) MergeBranch(name string, commit CommitOpts) error {
+	m, err := w.prepareMerge(name)
+	if err != nil {
+		return err
+	}
+
+	// already up to date
+	if len(m.changes) == 0 {
+		return nil
+	}
+
+	// refuse to merge if anything conflicts
+	var conflicts []BranchChange
+	for _, c := range m.changes {
+		if c.Conflict != "" {
+			conflicts = append(conflicts, c)
+		}
+	}
+	if len(conflicts) != 0 {
+		return &MergeConflictError{Branch: name, Conflicts: conflicts}
+	}
+
+	// read the files from the branch first, so that nothing is written if
+	// any of them cannot be read
+	files := make(map[string]fileState, len(m.changes))
+	for _, c := range m.changes {
+		if c.Type == ChangeDeleted {
+			continue
+		}
+		file, err := readCommitFile(m.branch, c.File)
+		if err != nil {
+			return err
+		}
+		files[c.File] = file
+	}
+
+	// get worktree
+	repo, err := w.repo()
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return errors.Wrap(err, "git:repo:Worktree")
+	}
+	lock := indexLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := resetIndex(repo); err != nil {
+		return err
+	}
+
+	// save the files on master, so they can be restored if the merge fails
+	saved := make(map[string]fileState, len(m.changes))
+	for _, c := range m.changes {
+		file, err := readFileState(w.UnresolvedAbsFilePath(c.File))
+		if err != nil {
+			return err
+		}
+		saved[c.File] = file
+	}
+
+	// apply the changes and commit
+	err = w.applyMerge(wt, m.changes, files)
+	if err == nil {
+		err = w.andCommit(wt, "Merge branch "+name, commit, m.master.Hash, m.branch.Hash)
+	}
+
+	// something went wrong; put master back how it was
+	if err != nil {
+		for path, file := range saved {
+			file.write(w.UnresolvedAbsFilePath(path))
+		}
+		resetIndex(repo)
+	}
+	return err
+}
+
+// applyMerge writes and stages the changes from a branch
+func (w *Wiki) applyMerge(wt *git.Worktree, changes []BranchChange, files map[string]fileState) error {
+	for _, c := range changes {
+		if c.Type == ChangeDeleted {
+			if _, err := wt.Remove(c.File); err != nil {
+				return errors.Wrap(err, "git:worktree:Remove")
+			}
+			continue
+		}
+		if err := files[c.File].write(w.UnresolvedAbsFilePath(c.File)); err != nil {
+			return err
+		}
+		if _, err := wt.Add(c.File); err != nil {
+			return errors.Wrap(err, "git:worktree:Add")
+		}
+	}
+	return nil
+}
+
+// DeleteBranch deletes a branch and its linked working tree.
+// Any changes on the branch which were not merged are lost.
+func (w *Wiki) DeleteBranch(name string) error {
+
+	// never delete master
+	if name == "master" {
+		return errors.New("cannot delete master")
+	}
+	if !ValidBranchName(name) {
+		return errors.New("invalid branch name: " + name)
+	}
+
+	// find branch
+	if exist, err := w.hasBranch(name); !exist {
+		if err != nil {
+			return err
+		}
+		return git.ErrBranchNotFound
+	}
+
+	repo, err := w.repo()
+	if err != nil {
+		return err
+	}
+
+	// remove the linked worktree and its metadata in .git/worktrees
+	if err := os.RemoveAll(filepath.Join(w.Opt().Dir.Cache, "branch", name)); err != nil {
+		return err
+	}
+	fs := repo.Storer.(interface{ Filesystem() billy.Filesystem }).Filesystem()
+	if err := os.RemoveAll(filepath.Join(fs.Root(), "worktrees", name)); err != nil {
+		return err
+	}
+
+	// remove the branch ref and config
+	if err := repo.Storer.RemoveReference(plumbing.NewBranchReferenceName(name)); err != nil {
+		return errors.Wrap(err, "git:repo:RemoveReference")
+	}
+	if err := repo.DeleteBranch(name); err != nil && err != git.ErrBranchNotFound {
+		return errors.Wrap(err, "git:repo:DeleteBranch")
+	}
+
+	return nil
+}
+
+// finds the changes on a branch since its merge base with master
+func (w *Wiki) prepareMerge(name string) (*branchMerge, error) {
+	if name == "master" {
+		return nil, errors.New("cannot compare master to itself")
+	}
+
+	repo, err := w.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	// find the head of each branch
+	master, err := branchHead(repo, "master")
+	if err != nil {
+		return nil, err
+	}
+	branch, err := branchHead(repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// find where they diverged
+	bases, err := branch.MergeBase(master)
+	if err != nil {
+		return nil, errors.Wrap(err, "git:commit:MergeBase")
+	}
+	if len(bases) == 0 {
+		return nil, errors.New("branch " + name + " has no history in common with master")
+	}
+
+	// find changes on each side
+	branchChanges, err := treeChanges(bases[0], branch)
+	if err != nil {
+		return nil, err
+	}
+	masterChanges, err := treeChanges(bases[0], master)
+	if err != nil {
+		return nil, err
+	}
+
+	masterTypes := make(map[string]ChangeType, len(masterChanges))
+	for _, c := range masterChanges {
+		masterTypes[c.File] = c.Type
+	}
+
+	m := &branchMerge{master: master, branch: branch}
+	for _, c := range branchChanges {
+		c.Page = w.pageNameForRepoPath(c.File)
+
+		// also changed on master; ok only if the result is identical
+		if masterType, ok := masterTypes[c.File]; ok {
+			same, err := sameFile(master, branch, c.File)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				c.Conflict = conflictReason(c.Type, masterType)
+			}
+		}
+
+		m.changes = append(m.changes, c)
+	}
+
+	return m, nil
+}
+
+// reads a file from a commit
+func readCommitFile(commit *object.Commit, path string) (fileState, error) {
+	file, err := commit.File(path)
+	if err != nil {
+		return fileState{}, errors.Wrap(err, "git:commit:File")
+	}
+
+	// symlinks store the target as their content
+	content, err := file.Contents()
+	if err != nil {
+		return fileState{}, errors.Wrap(err, "git:file:Contents")
+	}
+	return fileState{exist: true, link: file.Mode == filemode.Symlink, content: []byte(content)}, nil
+}
+
+// page name for a path relative to the wiki directory, if it is a page
+func (w *Wiki) pageNameForRepoPath(path string) string {
+	pageDir, err := w.relDir(w.Opt().Dir.Page)
+	if err != nil {
+		return ""
+	}
+	prefix := filepath.ToSlash(pageDir) + "/"
+	if !strings.HasPrefix(path, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(path, prefix)
+}
+
+// finds the head commit of a branch
+func branchHead(repo *git.Repository, name string) (*object.Commit, error) {
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(name), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "git:repo:Reference")
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, errors.Wrap(err, "git:repo:CommitObject")
+	}
+	return commit, nil
+}
+
+// finds the files changed between two commits, in order
+func treeChanges(from, to *object.Commit) ([]BranchChange, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "git:commit:Tree")
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "git:commit:Tree")
+	}
+	diffs, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, errors.Wrap(err, "git:DiffTree")
+	}
+
+	changes := make([]BranchChange, 0, len(diffs))
+	for _, d := range diffs {
+		action, err := d.Action()
+		if err != nil {
+			return nil, errors.Wrap(err, "git:change:Action")
+		}
+		switch action {
+		case merkletrie.Insert:
+			changes = append(changes, BranchChange{File: d.To.Name, Type: ChangeAdded})
+		case merkletrie.Delete:
+			changes = append(changes, BranchChange{File: d.From.Name, Type: ChangeDeleted})
+		default:
+			changes = append(changes, BranchChange{File: d.To.Name, Type: ChangeModified})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].File < changes[j].File
+	})
+	return changes, nil
+}
+
+// true if a file is identical (or absent) in both commits
+func sameFile(a, b *object.Commit, path string) (bool, error) {
+	fa, errA := a.File(path)
+	fb, errB := b.File(path)
+	if errA != nil && errA != object.ErrFileNotFound {
+		return false, errA
+	}
+	if errB != nil && errB != object.ErrFileNotFound {
+		return false, errB
+	}
+	if fa == nil || fb == nil {
+		return fa == nil && fb == nil, nil
+	}
+	return fa.Hash == fb.Hash && fa.Mode == fb.Mode, nil
+}
+
+func conflictReason(branchType, masterType ChangeType) string {
+	switch {
+	case branchType == ChangeDeleted:
+		return "deleted on branch but modified on master"
+	case masterType == ChangeDeleted:
+		return "modified on branch but deleted on master"
+	case branchType == ChangeAdded && masterType == ChangeAdded:
+		return "added on both branch and master"
+	default:
+		return "modified on both branch and master"
+	}
+}
+
 var branchNameRgx = regexp.MustCompile(`^[\w]+[\w\-/]*[\w]+$`)
 
 // ValidBranchName returns whether a branch name is valid.
@@ -362,8 +820,6 @@ func ValidBranchName(name string) bool {
 	return branchNameRgx.MatchString(name)
 }
 
-// WritePage writes a page file.
-
 // WriteFile writes a file in the wiki.
 //
 // The filename must be relative to the wiki directory.
@@ -426,5 +882,5 @@ func (w *Wiki) DeleteFile(name string, commit CommitOpts) error {
 	}
 
 	// delete the file and commit the change
-	return w.removeAndCommit(path, commit)
+	return w.removeAndCommit(name, commit)
 }
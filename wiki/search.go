@@ -0,0 +1,434 @@
+package wiki
+
+import (
+	"html"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/cooper/quiki/wikifier"
+	"github.com/pkg/errors"
+)
+
+// SearchOpts describes options for a wiki search.
+type SearchOpts struct {
+
+	// maximum number of results to return.
+	// if zero, all matching pages are returned
+	Limit int
+
+	// number of results to skip, for pagination
+	Offset int
+
+	// if true, pages marked as draft are included in the results
+	Drafts bool
+
+	// approximate length of each snippet in characters.
+	// if zero, a default of 200 is used
+	SnippetLength int
+}
+
+// SearchResult represents a page matching a search query.
+type SearchResult struct {
+
+	// relevance of the page to the query. higher is better
+	Score float64 `json:"score"`
+
+	// excerpt of the page text, with matched terms wrapped in <mark>
+	Snippet wikifier.HTML `json:"snippet,omitempty"`
+
+	// info for the matching page
+	wikifier.PageInfo
+}
+
+// bm25 tuning parameters
+const (
+	searchK1         = 1.2
+	searchB          = 0.75
+	searchTitleBoost = 2.0
+)
+
+// searchIndex is an inverted index of page text.
+type searchIndex struct {
+	buildMu   sync.Mutex // held while building
+	mu        sync.RWMutex
+	built     bool
+	pending   map[string]*string        // changes made during a build; nil text means removed
+	terms     map[string]map[string]int // term -> page name -> occurrences
+	docs      map[string]int            // page name -> number of terms
+	pageTerms map[string][]string       // page name -> distinct terms
+	total     int                       // sum of all document lengths
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		terms:     make(map[string]map[string]int),
+		docs:      make(map[string]int),
+		pageTerms: make(map[string][]string),
+	}
+}
+
+// add indexes the text of a page, replacing any existing entry
+func (idx *searchIndex) add(name, text string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx._add(name, text)
+	if idx.pending != nil {
+		idx.pending[name] = &text
+	}
+}
+
+func (idx *searchIndex) _add(name, text string) {
+	idx._remove(name)
+
+	terms := searchTerms(text)
+	var distinct []string
+	for _, term := range terms {
+		if idx.terms[term] == nil {
+			idx.terms[term] = make(map[string]int)
+		}
+		if idx.terms[term][name] == 0 {
+			distinct = append(distinct, term)
+		}
+		idx.terms[term][name]++
+	}
+	idx.docs[name] = len(terms)
+	idx.pageTerms[name] = distinct
+	idx.total += len(terms)
+}
+
+// remove deletes a page from the index
+func (idx *searchIndex) remove(name string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx._remove(name)
+	if idx.pending != nil {
+		idx.pending[name] = nil
+	}
+}
+
+func (idx *searchIndex) _remove(name string) {
+	length, exist := idx.docs[name]
+	if !exist {
+		return
+	}
+	for _, term := range idx.pageTerms[name] {
+		postings := idx.terms[term]
+		delete(postings, name)
+		if len(postings) == 0 {
+			delete(idx.terms, term)
+		}
+	}
+	delete(idx.docs, name)
+	delete(idx.pageTerms, name)
+	idx.total -= length
+}
+
+// score returns the BM25 score of each page containing all of the terms
+func (idx *searchIndex) score(terms []string) map[string]float64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// nothing indexed
+	n := float64(len(idx.docs))
+	if n == 0 {
+		return nil
+	}
+	avgLen := float64(idx.total) / n
+
+	scores := make(map[string]float64)
+	for i, term := range terms {
+		postings := idx.terms[term]
+
+		// a term matches nothing, so no page can contain all of them
+		if len(postings) == 0 {
+			return nil
+		}
+
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for name, occurrences := range postings {
+
+			// only pages which matched all previous terms are candidates
+			if _, ok := scores[name]; !ok && i != 0 {
+				continue
+			}
+
+			tf := float64(occurrences)
+			norm := 1 - searchB + searchB*float64(idx.docs[name])/avgLen
+			scores[name] += idf * tf * (searchK1 + 1) / (tf + searchK1*norm)
+		}
+
+		// drop candidates missing this term
+		if i != 0 {
+			for name := range scores {
+				if _, ok := postings[name]; !ok {
+					delete(scores, name)
+				}
+			}
+		}
+	}
+
+	return scores
+}
+
+// Search finds pages containing all of the words in the query.
+//
+// Results are ordered by relevance, with the most relevant first.
+// Search requires that search optimization (@search.enable) is on, since the
+// index is built from the page text files it produces.
+//
+func (w *Wiki) Search(query string, opts SearchOpts) ([]SearchResult, error) {
+
+	// search optimization isn't enabled
+	if !w.Opt().Search.Enable {
+		return nil, errors.New("search is not enabled")
+	}
+
+	// no usable words in the query
+	terms := uniqueStrings(searchTerms(query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	termSet := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		termSet[term] = true
+	}
+
+	// collect matching pages
+	var results []SearchResult
+	for name, score := range w.searchIndex().score(terms) {
+		info := w.PageInfo(name)
+
+		// page no longer exists
+		if info.File == "" {
+			continue
+		}
+
+		// drafts only on request
+		if info.Draft && !opts.Drafts {
+			continue
+		}
+
+		// words in the title are worth more
+		for _, term := range searchTerms(info.Title) {
+			if termSet[term] {
+				score += searchTitleBoost
+			}
+		}
+
+		results = append(results, SearchResult{Score: score, PageInfo: info})
+	}
+
+	// most relevant first, then alphabetical
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return strings.ToLower(results[i].Title) < strings.ToLower(results[j].Title)
+	})
+
+	// apply offset and limit
+	if opts.Offset > 0 {
+		if opts.Offset >= len(results) {
+			return nil, nil
+		}
+		results = results[opts.Offset:]
+	}
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	// generate snippets only for the results we're returning
+	length := opts.SnippetLength
+	if length <= 0 {
+		length = 200
+	}
+	for i, res := range results {
+		text, err := ioutil.ReadFile(w.FindPage(res.File).SearchPath())
+		if err != nil {
+			continue
+		}
+		results[i].Snippet = searchSnippet(string(text), termSet, length)
+	}
+
+	return results, nil
+}
+
+// UnindexPage removes a page from the search index.
+// It should be called when a page is deleted or renamed.
+func (w *Wiki) UnindexPage(name string) {
+	w.searchIndex().remove(filepath.ToSlash(name))
+}
+
+// searchIndex returns the wiki search index, building it if necessary.
+func (w *Wiki) searchIndex() *searchIndex {
+	w.search.mu.RLock()
+	built := w.search.built
+	w.search.mu.RUnlock()
+	if built {
+		return w.search
+	}
+
+	// another caller may have built it while we waited
+	w.search.buildMu.Lock()
+	defer w.search.buildMu.Unlock()
+	w.search.mu.RLock()
+	built = w.search.built
+	w.search.mu.RUnlock()
+	if !built {
+		w._buildSearchIndex()
+	}
+	return w.search
+}
+
+// buildSearchIndex (re)indexes the text file of every page in the wiki.
+//
+// Only one build runs at a time. Pages indexed or removed while the build is
+// in progress are applied again to the new index, so those changes are not
+// lost when it is swapped in.
+//
+func (w *Wiki) buildSearchIndex() {
+	w.search.buildMu.Lock()
+	defer w.search.buildMu.Unlock()
+	w._buildSearchIndex()
+}
+
+func (w *Wiki) _buildSearchIndex() {
+
+	// start recording changes made during the build
+	w.search.mu.Lock()
+	w.search.pending = make(map[string]*string)
+	w.search.mu.Unlock()
+
+	idx := newSearchIndex()
+	for _, name := range w.allPageFiles() {
+		page := w.FindPage(name)
+		text, err := ioutil.ReadFile(page.SearchPath())
+		if err != nil {
+			continue
+		}
+		idx.add(page.Name(), string(text))
+	}
+
+	// swap in the new index, replaying the changes it may have missed
+	w.search.mu.Lock()
+	for name, text := range w.search.pending {
+		if text == nil {
+			idx._remove(name)
+		} else {
+			idx._add(name, *text)
+		}
+	}
+	w.search.terms = idx.terms
+	w.search.docs = idx.docs
+	w.search.pageTerms = idx.pageTerms
+	w.search.total = idx.total
+	w.search.pending = nil
+	w.search.built = true
+	w.search.mu.Unlock()
+}
+
+// searchTerms splits text into lowercase words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isSearchRune(r)
+	})
+}
+
+func isSearchRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func uniqueStrings(strs []string) []string {
+	seen := make(map[string]bool, len(strs))
+	var unique []string
+	for _, s := range strs {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// searchSnippet extracts an excerpt of text around the first matched term
+// and highlights each occurrence of the terms within it
+func searchSnippet(text string, terms map[string]bool, length int) wikifier.HTML {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return ""
+	}
+
+	// find the first word containing a match
+	first := 0
+	for i, word := range words {
+		if _, matched := highlightWord(word, terms); matched {
+			first = i
+			break
+		}
+	}
+
+	// back up a few words for context
+	start := first
+	for chars := 0; start > 0 && chars < length/4; {
+		start--
+		chars += len(words[start]) + 1
+	}
+
+	// add words until we reach the desired length
+	var parts []string
+	end, chars := start, 0
+	for ; end < len(words) && chars < length; end++ {
+		highlighted, _ := highlightWord(words[end], terms)
+		parts = append(parts, highlighted)
+		chars += len(words[end]) + 1
+	}
+
+	snippet := strings.Join(parts, " ")
+	if start > 0 {
+		snippet = "&hellip;" + snippet
+	}
+	if end < len(words) {
+		snippet += "&hellip;"
+	}
+	return wikifier.HTML(snippet)
+}
+
+// highlightWord escapes a word and wraps any matching terms in <mark>
+func highlightWord(word string, terms map[string]bool) (string, bool) {
+	var b strings.Builder
+	matched := false
+	last, start := 0, -1
+
+	flush := func(end int) {
+		if start == -1 {
+			return
+		}
+		if terms[strings.ToLower(word[start:end])] {
+			b.WriteString(html.EscapeString(word[last:start]))
+			b.WriteString("<mark>" + html.EscapeString(word[start:end]) + "</mark>")
+			last = end
+			matched = true
+		}
+		start = -1
+	}
+
+	for i, r := range word {
+		if isSearchRune(r) {
+			if start == -1 {
+				start = i
+			}
+		} else {
+			flush(i)
+		}
+	}
+	flush(len(word))
+
+	b.WriteString(html.EscapeString(word[last:]))
+	return b.String(), matched
+}
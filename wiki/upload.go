@@ -0,0 +1,285 @@
+package wiki
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/cooper/quiki/wikifier"
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+)
+
+// ImageUpload describes the result of uploading an image.
+type ImageUpload struct {
+	File      string `json:"file"`                // image name, relative to the image directory
+	Width     int    `json:"width"`               // image width, after resizing
+	Height    int    `json:"height"`              // image height, after resizing
+	Duplicate bool   `json:"duplicate,omitempty"` // true if an identical image already existed
+	Resized   bool   `json:"resized,omitempty"`   // true if the image was downsized
+}
+
+// largest image which may be uploaded, in pixels. larger images would take
+// too much memory to decode
+const uploadMaxPixels = 50 * 1000 * 1000
+
+// content types accepted for each image extension
+var imageContentTypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+}
+
+// UploadImage validates and stores a new image.
+//
+// The name is the original filename; it is normalized, and only the base
+// name is used. The content must be a PNG or JPEG image matching the
+// extension and no larger than image.upload.max_size. Metadata such as EXIF
+// is removed, and if the image exceeds image.upload.max_dimension,
+// it is scaled down to fit.
+//
+// If an image with the same content already exists, nothing is written and
+// the existing image is returned with Duplicate set. If a different image
+// by the same name exists, an error is returned.
+//
+func (w *Wiki) UploadImage(name string, content []byte, commit CommitOpts) (*ImageUpload, error) {
+
+	// check size
+	if max := w.Opt().Image.UploadMaxSize; max != 0 && len(content) > max*1024*1024 {
+		return nil, errors.New("image is larger than " + strconv.Itoa(max) + " MB")
+	}
+
+	// normalize name
+	name = path.Base(strings.TrimSpace(strings.Replace(name, "\\", "/", -1)))
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	name = wikifier.PageNameLink(strings.TrimSuffix(name, path.Ext(name))) + "." + ext
+	name, err := imageFileName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// check that content matches the extension
+	contentType := http.DetectContentType(content)
+	if contentType != imageContentTypes[ext] {
+		return nil, errors.New("file is " + contentType + ", not " + imageContentTypes[ext])
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid image")
+	}
+	if int64(config.Width)*int64(config.Height) > uploadMaxPixels {
+		return nil, errors.New("image is larger than " + strconv.Itoa(uploadMaxPixels/1000000) + " megapixels")
+	}
+
+	// remove metadata
+	var orientation int
+	original := content
+	if ext == "png" {
+		content, err = stripPNGMetadata(content)
+	} else {
+		content, orientation, err = stripJPEGMetadata(content)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid image")
+	}
+
+	// downsize or apply rotation from metadata. the original is decoded
+	// so the orientation is available, but the result has no metadata
+	resized := false
+	max := w.Opt().Image.UploadMaxDimension
+	if (max != 0 && (config.Width > max || config.Height > max)) || orientation > 1 {
+		img, err := imaging.Decode(bytes.NewReader(original), imaging.AutoOrientation(true))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid image")
+		}
+		if b := img.Bounds(); max != 0 && (b.Dx() > max || b.Dy() > max) {
+			img = imaging.Fit(img, max, max, imaging.Lanczos)
+			resized = true
+		}
+		format, _ := imaging.FormatFromExtension(ext)
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, img, format, imaging.JPEGQuality(95)); err != nil {
+			return nil, err
+		}
+		content = buf.Bytes()
+	}
+
+	// check for an identical image
+	if existing := w.findImageContent(content); existing != "" {
+		info := w.ImageInfo(existing)
+		return &ImageUpload{File: existing, Width: info.Width, Height: info.Height, Duplicate: true}, nil
+	}
+	if _, err := os.Lstat(w.pathForImage(name)); err == nil {
+		return nil, errors.New("a different image named '" + name + "' already exists")
+	}
+
+	// write and commit
+	if err := w.WriteImage(name, content, true, commit); err != nil {
+		return nil, err
+	}
+
+	// refresh the image category so that it shows up right away
+	w.changeCategory(name, CategoryTypeImage, func(cat *Category) {
+		cat.ImageInfo = nil
+		cat.addImage(w, name, nil, nil, nil)
+	})
+
+	info := w.ImageInfo(name)
+	return &ImageUpload{File: name, Width: info.Width, Height: info.Height, Resized: resized}, nil
+}
+
+// findImageContent returns the name of an image with exactly this content,
+// or an empty string if there is none. only images of the same size are hashed
+func (w *Wiki) findImageContent(content []byte) string {
+	hash := sha256.Sum256(content)
+	for _, name := range w.allImageFiles() {
+		path := w.pathForImage(name)
+		fi, err := os.Stat(path)
+		if err != nil || fi.Size() != int64(len(content)) {
+			continue
+		}
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && sha256.Sum256(existing) == hash {
+			return name
+		}
+	}
+	return ""
+}
+
+// removes EXIF, XMP, and IPTC segments from a JPEG, returning the new
+// content and the EXIF orientation, if any
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errors.New("missing JPEG start of image")
+	}
+
+	out := []byte{0xFF, 0xD8}
+	orientation := 0
+	i := 2
+	for i+2 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, errors.New("bad JPEG marker")
+		}
+
+		// any number of 0xFF fill bytes may precede a marker
+		start := i
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+2 > len(data) {
+			break
+		}
+		marker := data[i+1]
+
+		// start of scan or end of image; the rest is image data
+		if marker == 0xDA || marker == 0xD9 {
+			i = start
+			break
+		}
+
+		// TEM and RSTn have no length
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			out = append(out, 0xFF, marker)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, 0, errors.New("bad JPEG segment length")
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errors.New("bad JPEG segment length")
+		}
+
+		switch marker {
+
+		// APP1 holds EXIF and XMP
+		case 0xE1:
+			segment := data[i+4 : end]
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(segment[6:])
+			}
+
+		// APP13 holds IPTC and other Photoshop metadata
+		case 0xED:
+
+		default:
+			out = append(out, 0xFF, marker)
+			out = append(out, data[i+2:end]...)
+		}
+		i = end
+	}
+
+	return append(out, data[i:]...), orientation, nil
+}
+
+// finds the orientation tag in EXIF TIFF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	// first IFD. compare before converting, since a large offset would
+	// overflow int on 32-bit platforms
+	offset32 := order.Uint32(tiff[4:])
+	if uint64(offset32)+2 > uint64(len(tiff)) {
+		return 0
+	}
+	offset := int(offset32)
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// removes eXIf and text chunks from a PNG
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return nil, errors.New("missing PNG signature")
+	}
+
+	out := []byte(sig)
+	i := len(sig)
+	for i+12 <= len(data) {
+		length := binary.BigEndian.Uint32(data[i:])
+		if uint64(length) > uint64(len(data)-i-12) {
+			return nil, errors.New("bad PNG chunk length")
+		}
+		end := i + 12 + int(length)
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			// metadata; drop it
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	return out, nil
+}
@@ -5,21 +5,34 @@ import (
 	"log"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	"github.com/cooper/go-git/v4"
 	"github.com/cooper/quiki/authenticator"
-	"github.com/cooper/quiki/wikifier"
 )
 
 // A Wiki represents a quiki website.
+//
+// Its methods may be called from multiple goroutines. The exported fields
+// are set when the wiki is created and must not be changed afterward; the
+// options are available from Opt.
+//
 type Wiki struct {
-	ConfigFile    string
-	Opt           wikifier.PageOpt
-	Auth          *authenticator.Authenticator
-	pageLocks     map[string]*sync.Mutex
-	pregenerating bool
-	_repo         *git.Repository
-	_logger       *log.Logger
+	ConfigFile        string
+	Auth              *authenticator.Authenticator
+	opt               atomic.Value // *wikifier.PageOpt, see Opt
+	pageLocks         lockTable    // page name -> generating
+	categoryLocks     lockTable    // type:name -> reading or writing
+	pregen            pregenerator
+	linkCheck         linkChecker
+	pageMem           pageMemory // recently displayed pages
+	search            *searchIndex
+	allowedImageSizes map[string][][]int // see AllowImageSize
+	sizesMu           sync.RWMutex       // protects allowedImageSizes
+	_repo             *git.Repository
+	repoMu            sync.Mutex // protects _repo
+	_logger           *log.Logger
+	logMu             sync.Mutex // protects _logger
 }
 
 // NewWiki creates a Wiki given its directory path.
@@ -35,8 +48,7 @@ func NewWikiConfig(confPath string) (*Wiki, error) {
 	confPath = filepath.FromSlash(confPath)
 	w := &Wiki{
 		ConfigFile: confPath,
-		Opt:        defaultWikiOpt,
-		pageLocks:  make(map[string]*sync.Mutex),
+		search:     newSearchIndex(),
 	}
 
 	// there's no config!
@@ -46,13 +58,15 @@ func NewWikiConfig(confPath string) (*Wiki, error) {
 
 	// guess dir.wiki from config location
 	// (if the conf specifies an absolute path, this will be overwritten)
-	w.Opt.Dir.Wiki = filepath.Dir(confPath)
+	opt := defaultWikiOpt
+	opt.Dir.Wiki = filepath.Dir(confPath)
 
 	// parse the config
-	err := w.readConfig(confPath)
+	err := w.readConfig(confPath, &opt)
 	if err != nil {
 		return nil, err
 	}
+	w.opt.Store(&opt)
 
 	// create authenticator
 	w.Auth, err = authenticator.Open(filepath.Join(filepath.Dir(confPath), "auth.json"))
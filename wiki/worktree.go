@@ -0,0 +1,108 @@
+package wiki
+
+// worktree.go - workarounds for linked repositories in go-git
+//
+// branches are checked out in linked repositories under cache/branch/, which
+// share the object database and index with the main repository. go-git gets
+// two things wrong about them:
+//
+//   - PlainAddWorktree points the linked repository at the main one, so the
+//     checkout moves the main HEAD to the branch. repairWorktree fixes this.
+//
+//   - the index is shared, so staging in one repository starts from whatever
+//     the other left there. resetIndex is called before staging anything,
+//     and the lock from indexLock is held from then until the commit.
+//
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cooper/go-git/v4"
+	"github.com/cooper/go-git/v4/plumbing"
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// locks for the shared index by the main repository's .git directory. each
+// branch has its own Wiki, so the lock cannot belong to one
+var indexLocks lockTable
+
+// indexLock returns the lock for the index shared by a repository and the
+// repositories linked to it
+func indexLock(repo *git.Repository) *sync.Mutex {
+	fs := repo.Storer.(interface{ Filesystem() billy.Filesystem }).Filesystem()
+	dir := fs.Root()
+
+	// linked repositories point to the main one with commondir
+	if common, err := ioutil.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(dir, common)
+		}
+		dir = common
+	}
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return indexLocks.get(filepath.Clean(dir))
+}
+
+// repairWorktree points a linked repository's .git file to
+// .git/worktrees/<name> rather than the main repository, and restores the
+// main HEAD and index to master. it does nothing if the link is already
+// correct, so it is safe to call for existing linked repositories
+func (w *Wiki) repairWorktree(repo *git.Repository, name, dir string) error {
+
+	// find .git/worktrees/<name>
+	fs := repo.Storer.(interface{ Filesystem() billy.Filesystem }).Filesystem()
+	gitDir, err := filepath.Abs(filepath.Join(fs.Root(), "worktrees", name))
+	if err != nil {
+		return err
+	}
+
+	// already correct
+	dotGit := filepath.Join(dir, ".git")
+	link := []byte("gitdir: " + gitDir + "\n")
+	if current, err := ioutil.ReadFile(dotGit); err == nil && bytes.Equal(current, link) {
+		return nil
+	}
+
+	// point .git to .git/worktrees/<name>
+	if err := ioutil.WriteFile(dotGit, link, 0644); err != nil {
+		return err
+	}
+
+	// restore the main HEAD and index to master
+	lock := indexLock(repo)
+	lock.Lock()
+	defer lock.Unlock()
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+	if err != nil {
+		return errors.Wrap(err, "git:repo:SetReference")
+	}
+	return resetIndex(repo)
+}
+
+// resets a repository index to its HEAD without touching the worktree.
+//
+// linked repos share the index with the main repository, so this must be
+// done before staging changes to make sure the commit is based on the
+// correct branch
+//
+func resetIndex(repo *git.Repository) error {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return errors.Wrap(err, "git:repo:Worktree")
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return errors.Wrap(err, "git:repo:Head")
+	}
+	err = wt.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.MixedReset})
+	return errors.Wrap(err, "git:worktree:Reset")
+}
@@ -0,0 +1,647 @@
+package wiki
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cooper/quiki/wikifier"
+	"github.com/pkg/errors"
+)
+
+// RenameOpts describes the options for renaming a page.
+type RenameOpts struct {
+
+	// Redirect leaves a symbolic link in place of the old page,
+	// so that requests for it redirect to the new name.
+	Redirect bool
+
+	// UpdateLinks rewrites [[links]] to the old page in all other pages
+	// so that they point to the new name.
+	UpdateLinks bool
+
+	// Commit describes the revision.
+	Commit CommitOpts
+}
+
+// file extensions permitted in each directory
+var (
+	pageExtensions  = []string{"page", "md"}
+	modelExtensions = []string{"model"}
+	imageExtensions = []string{"png", "jpg", "jpeg", "gif", "webp", "svg"}
+)
+
+var linkRgx = regexp.MustCompile(`\[\[(.*?)\]\]`)
+
+// WritePage writes a page file and commits the change.
+//
+// The name is relative to the page directory. If it has no extension,
+// .page is added. Names which would escape the page directory are refused.
+//
+// Afterward, the page is regenerated so that its cache, search text, and
+// categories are up-to-date, including those of pages it no longer links to.
+// If the page is new, pages which link to it are purged from the cache.
+//
+func (w *Wiki) WritePage(name string, content []byte, createOK bool, commit CommitOpts) error {
+	name, err := pageFileName(name)
+	if err != nil {
+		return err
+	}
+	relPath, err := w.checkWritePath(w.Opt().Dir.Page, name)
+	if err != nil {
+		return err
+	}
+	page := w.FindPage(name)
+	existed := page.Exists()
+
+	// find categories before the content changes
+	var cats []*Category
+	if existed {
+		cats = w.pageCategories(page)
+	}
+
+	if err := w.WriteFile(relPath, content, createOK, commit); err != nil {
+		return err
+	}
+	w.regeneratePage(name)
+	w.updateCategories(cats)
+	if !existed {
+		w.PurgeDependents(page.NameNE(), CategoryTypePage)
+	}
+	return nil
+}
+
+// WriteModel writes a model file and commits the change.
+//
+// The name is relative to the model directory. If it has no extension,
+// .model is added. Names which would escape the model directory are refused.
+//
+// Pages which use the model are purged from the cache so that they
+// are regenerated with the new model content.
+//
+func (w *Wiki) WriteModel(name string, content []byte, createOK bool, commit CommitOpts) error {
+	name, err := modelFileName(name)
+	if err != nil {
+		return err
+	}
+	relPath, err := w.checkWritePath(w.Opt().Dir.Model, name)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteFile(relPath, content, createOK, commit); err != nil {
+		return err
+	}
+	w.PurgeDependents(name, CategoryTypeModel)
+	return nil
+}
+
+// WriteImage writes an image file and commits the change.
+//
+// The name is relative to the image directory and must have a supported
+// image extension. Names which would escape the image directory are refused.
+//
+// Scaled versions of the image and pages which display it are purged
+// from the cache.
+//
+func (w *Wiki) WriteImage(name string, content []byte, createOK bool, commit CommitOpts) error {
+	name, err := imageFileName(name)
+	if err != nil {
+		return err
+	}
+	relPath, err := w.checkWritePath(w.Opt().Dir.Image, name)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteFile(relPath, content, createOK, commit); err != nil {
+		return err
+	}
+	w.purgeImageCache(name)
+	w.PurgeDependents(name, CategoryTypeImage)
+	return nil
+}
+
+// WriteConfig writes the wiki configuration file and commits the change.
+//
+// The configuration is parsed before it is written, and if it is invalid,
+// an error is returned and nothing is written. Otherwise, the new
+// configuration is loaded and all pages are purged from the cache.
+//
+func (w *Wiki) WriteConfig(content []byte, commit CommitOpts) error {
+	relPath := w.RelPath(w.ConfigFile)
+	if relPath == "" {
+		return errors.New("configuration file is outside of the wiki directory")
+	}
+
+	// make sure it parses
+	confPage := wikifier.NewPageSource(string(content))
+	confPage.VarsOnly = true
+	if err := confPage.Parse(); err != nil {
+		return errors.Wrap(err, "invalid configuration")
+	}
+
+	if err := w.WriteFile(relPath, content, false, commit); err != nil {
+		return err
+	}
+
+	if err := w.ReloadConfig(); err != nil {
+		return err
+	}
+
+	// pages need to be regenerated with the new options
+	for _, name := range w.allPageFiles() {
+		w.purgePage(w.FindPage(name))
+	}
+	return nil
+}
+
+// DeletePage deletes a page file and commits the change.
+//
+// The page's cache and search text are deleted, and it is removed from
+// all categories. Pages which link to it are purged from the cache.
+//
+func (w *Wiki) DeletePage(name string, commit CommitOpts) error {
+	name, err := pageFileName(name)
+	if err != nil {
+		return err
+	}
+	page := w.FindPage(name)
+	if !page.Exists() {
+		return errors.New("page does not exist")
+	}
+	relPath, err := w.checkWritePath(w.Opt().Dir.Page, page.Name())
+	if err != nil {
+		return err
+	}
+
+	// find categories before the page is gone
+	cats := w.pageCategories(page)
+
+	if err := w.DeleteFile(relPath, commit); err != nil {
+		return err
+	}
+
+	w.purgePage(page)
+	w.updateCategories(cats)
+	w.PurgeDependents(page.NameNE(), CategoryTypePage)
+	return nil
+}
+
+// DeleteModel deletes a model file and commits the change.
+//
+// Pages which use the model are purged from the cache.
+//
+func (w *Wiki) DeleteModel(name string, commit CommitOpts) error {
+	name, err := modelFileName(name)
+	if err != nil {
+		return err
+	}
+	relPath, err := w.checkWritePath(w.Opt().Dir.Model, name)
+	if err != nil {
+		return err
+	}
+	if err := w.DeleteFile(relPath, commit); err != nil {
+		return err
+	}
+	w.PurgeDependents(name, CategoryTypeModel)
+	return nil
+}
+
+// DeleteImage deletes an image file and commits the change.
+//
+// Scaled versions of the image and pages which display it are purged
+// from the cache.
+//
+func (w *Wiki) DeleteImage(name string, commit CommitOpts) error {
+	name, err := imageFileName(name)
+	if err != nil {
+		return err
+	}
+	relPath, err := w.checkWritePath(w.Opt().Dir.Image, name)
+	if err != nil {
+		return err
+	}
+	if err := w.DeleteFile(relPath, commit); err != nil {
+		return err
+	}
+	w.purgeImageCache(name)
+	w.PurgeDependents(name, CategoryTypeImage)
+	return nil
+}
+
+// RenamePage moves a page to a new name in a single revision.
+//
+// If the new name has no extension, the extension of the old page is kept.
+// If a page by the new name already exists, an error is returned.
+// See RenameOpts for leaving a redirect and updating links.
+//
+// If any step fails, the files are put back how they were, and no revision
+// is made.
+//
+func (w *Wiki) RenamePage(oldName, newName string, opts RenameOpts) error {
+
+	// find the old page
+	oldName, err := pageFileName(oldName)
+	if err != nil {
+		return err
+	}
+	oldPage := w.FindPage(oldName)
+	if !oldPage.Exists() {
+		return errors.New("page does not exist")
+	}
+	if oldPage.IsSymlink() {
+		return errors.New("page is a redirect")
+	}
+	oldName = oldPage.Name()
+	oldRel, err := w.checkWritePath(w.Opt().Dir.Page, oldName)
+	if err != nil {
+		return err
+	}
+
+	// determine new name, keeping the extension
+	if path.Ext(newName) == "" && path.Ext(oldName) == ".md" {
+		newName += ".md"
+	}
+	newName, err = pageFileName(newName)
+	if err != nil {
+		return err
+	}
+	newRel, err := w.checkWritePath(w.Opt().Dir.Page, newName)
+	if err != nil {
+		return err
+	}
+	newPath := w.UnresolvedAbsFilePath(newRel)
+	if _, err := os.Lstat(newPath); err == nil {
+		return errors.New("page '" + newName + "' already exists")
+	}
+	newNameNE := wikifier.PageNameNE(newName)
+
+	// find categories before the page is gone
+	cats := w.pageCategories(oldPage)
+
+	// find links to rewrite
+	var rewrites map[string][]byte
+	if opts.UpdateLinks {
+		rewrites = w.rewritePageLinks(oldPage.NameNE(), newNameNE)
+	}
+
+	// use rewritten content for the page if it links to itself
+	content, err := ioutil.ReadFile(oldPage.Path())
+	if err != nil {
+		return err
+	}
+	if rewritten, ok := rewrites[oldName]; ok {
+		content = rewritten
+		delete(rewrites, oldName)
+	}
+
+	// find every path before changing anything
+	oldPath := w.UnresolvedAbsFilePath(oldRel)
+	var target string
+	if opts.Redirect {
+		target, err = filepath.Rel(filepath.Dir(oldPath), newPath)
+		if err != nil {
+			return err
+		}
+	}
+	rewritePaths := make(map[string]string, len(rewrites))
+	for name := range rewrites {
+		relPath, err := w.checkWritePath(w.Opt().Dir.Page, name)
+		if err != nil {
+			return err
+		}
+		rewritePaths[name] = relPath
+	}
+
+	// save the files which will change, so they can be restored if
+	// anything fails
+	paths := []string{oldPath, newPath}
+	for _, relPath := range rewritePaths {
+		paths = append(paths, w.UnresolvedAbsFilePath(relPath))
+	}
+	saved := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		file, err := readFileState(path)
+		if err != nil {
+			return err
+		}
+		saved[path] = file
+	}
+	restore := func(err error) error {
+		for path, file := range saved {
+			file.write(path)
+		}
+		return err
+	}
+
+	// move the page
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(newPath, content, 0644); err != nil {
+		return restore(err)
+	}
+	if err := os.Remove(oldPath); err != nil {
+		return restore(err)
+	}
+
+	add, remove := []string{newRel}, []string{}
+	if opts.Redirect {
+		// leave a symlink to the new page
+		if err := os.Symlink(target, oldPath); err != nil {
+			return restore(err)
+		}
+		add = append(add, oldRel)
+	} else {
+		remove = append(remove, oldRel)
+	}
+
+	// write pages with updated links
+	for name, content := range rewrites {
+		relPath := rewritePaths[name]
+		if err := ioutil.WriteFile(w.UnresolvedAbsFilePath(relPath), content, 0644); err != nil {
+			return restore(err)
+		}
+		add = append(add, relPath)
+	}
+
+	// commit it all at once
+	comment := "Rename " + oldName + " to " + newName
+	if err := w.changeAndCommit(add, remove, comment, opts.Commit); err != nil {
+		return restore(err)
+	}
+
+	// remove the old page from the cache and categories
+	w.purgePage(oldPage)
+	w.updateCategories(cats)
+
+	// generate the new page and those with updated links
+	w.regeneratePage(newName)
+	for name := range rewrites {
+		w.regeneratePage(name)
+	}
+
+	// links to either name may now point somewhere else
+	w.PurgeDependents(oldPage.NameNE(), CategoryTypePage)
+	w.PurgeDependents(newNameNE, CategoryTypePage)
+
+	return nil
+}
+
+// rewritePageLinks finds [[links]] to a page in all page sources and
+// returns the updated content of each page which changed, keyed by name.
+// the display text of each link is preserved
+func (w *Wiki) rewritePageLinks(oldNameNE, newNameNE string) map[string][]byte {
+	rewrites := make(map[string][]byte)
+	for _, name := range w.allPageFiles() {
+		page := w.FindPage(name)
+
+		// only quiki source; skip redirects
+		if page.Markdown || page.IsSymlink() {
+			continue
+		}
+		source, err := ioutil.ReadFile(page.Path())
+		if err != nil {
+			continue
+		}
+
+		changed := false
+		newSource := linkRgx.ReplaceAllStringFunc(string(source), func(match string) string {
+			inner := match[2 : len(match)-2]
+			linked, sec, ok := page.LinkedPage(inner)
+			if !ok || !strings.EqualFold(linked, oldNameNE) {
+				return match
+			}
+			changed = true
+
+			// determine the new target
+			target := newNameNE
+			if page.Prefix() != "" {
+				target = "/" + target
+			}
+			if sec != "" {
+				target += "#" + sec
+			}
+
+			// keep the display text, which defaults to the old target
+			display := inner
+			if split := strings.SplitN(inner, "|", 2); len(split) == 2 {
+				display = split[0]
+			}
+			return "[[ " + strings.TrimSpace(display) + " | " + target + " ]]"
+		})
+
+		if changed {
+			rewrites[page.Name()] = []byte(newSource)
+		}
+	}
+	return rewrites
+}
+
+// pageCategories returns all categories which may contain a page, including
+// image, model, and page link tracking categories
+func (w *Wiki) pageCategories(page *wikifier.Page) []*Category {
+	page = w.FindPage(page.Name())
+	if err := page.Parse(); err != nil {
+		return nil
+	}
+	page.HTML()
+
+	var cats []*Category
+	for _, name := range page.Categories() {
+		cats = append(cats, w.GetCategory(name))
+	}
+	for name := range page.Images {
+		cats = append(cats, w.GetSpecialCategory(name, CategoryTypeImage))
+	}
+	for name := range page.Models {
+		cats = append(cats, w.GetSpecialCategory(name, CategoryTypeModel))
+	}
+	for name := range page.PageLinks {
+		cats = append(cats, w.GetSpecialCategory(name, CategoryTypePage))
+	}
+	return cats
+}
+
+// cachedPageCategories is like pageCategories, except it uses the page's
+// cache file, so it works after the source has already changed
+func (w *Wiki) cachedPageCategories(page *wikifier.Page) []*Category {
+	info, err := readPageCacheManifest(page.CachePath())
+	if err != nil {
+		return nil
+	}
+	var cats []*Category
+	for _, name := range info.Categories {
+		cats = append(cats, w.GetCategory(name))
+	}
+	for _, dep := range info.Dependencies {
+		cats = append(cats, w.GetSpecialCategory(dep.Name, dep.Type))
+	}
+	return cats
+}
+
+// updateCategories removes pages which no longer belong from categories
+func (w *Wiki) updateCategories(cats []*Category) {
+	for _, cat := range cats {
+		w.changeCategory(cat.Name, cat.Type, func(cat *Category) {
+			cat.update(w)
+		})
+	}
+}
+
+// purgePage deletes a page's cache and search text
+func (w *Wiki) purgePage(page *wikifier.Page) {
+	w.pageMem.remove(page.Name())
+	os.Remove(page.CachePath())
+	os.Remove(page.SearchPath())
+	w.UnindexPage(page.Name())
+}
+
+// regeneratePage purges a page and generates it again, updating its
+// cache, search text, and categories
+func (w *Wiki) regeneratePage(name string) {
+	w.purgePage(w.FindPage(name))
+	w.DisplayPageDraft(name, true)
+}
+
+// purgeImageCache deletes the scaled and converted versions of an image
+func (w *Wiki) purgeImageCache(name string) {
+	img := SizedImageFromName(name)
+	dir := filepath.Join(w.Opt().Dir.Cache, "image", filepath.FromSlash(img.Prefix))
+
+	// versions in other formats may have been generated from this one
+	exts := []string{img.Ext}
+	if isConvertibleImage(img.Ext) {
+		exts = imageConvertExtensions
+	}
+
+	for _, ext := range exts {
+		for _, pattern := range []string{
+			"*x*-" + img.RelNameNE + "." + ext,    // 100x200-image.png
+			"*x*-" + img.RelNameNE + "@*x." + ext, // 100x200-image@2x.png
+			img.RelNameNE + "." + ext,             // image.webp
+		} {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, match := range matches {
+				// the pattern also matches longer names ending in this one
+				if SizedImageFromName(filepath.Base(match)).RelNameNE != img.RelNameNE {
+					continue
+				}
+				os.Remove(match)
+			}
+		}
+	}
+}
+
+// checkWritePath ensures a name within one of the wiki directories, such as
+// w.Opt().Dir.Page, does not escape it, and returns the path relative to the
+// wiki directory
+func (w *Wiki) checkWritePath(dir, name string) (string, error) {
+	relDir, err := w.relDir(dir)
+	if err != nil {
+		return "", err
+	}
+	relPath := filepath.Join(relDir, filepath.FromSlash(name))
+
+	// the directory itself may be a symlink, so compare the
+	// resolved parent of the file to the resolved directory
+	base, err := filepath.EvalSymlinks(w.Dir(relDir))
+	if err != nil {
+		return "", err
+	}
+	parent := filepath.Dir(w.Dir(relPath))
+	for {
+		if resolved, err := filepath.EvalSymlinks(parent); err == nil {
+			parent = resolved
+			break
+		}
+		// doesn't exist yet; check the nearest existing directory
+		next := filepath.Dir(parent)
+		if next == parent {
+			break
+		}
+		parent = next
+	}
+	if rel, err := filepath.Rel(base, parent); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", errors.New("path '" + name + "' is outside of the " + relDir + " directory")
+	}
+
+	return relPath, nil
+}
+
+// relDir returns one of the wiki directories, such as w.Opt().Dir.Page,
+// relative to the wiki directory
+func (w *Wiki) relDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel := w.RelPath(abs)
+	if rel == "" || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", errors.New("directory '" + dir + "' is outside of the wiki directory")
+	}
+	return rel, nil
+}
+
+// cleanFileName validates a file name relative to one of the wiki
+// directories, returning it cleaned with forward slashes
+func cleanFileName(name string) (string, error) {
+	name = strings.TrimSpace(filepath.ToSlash(name))
+	if name == "" {
+		return "", errors.New("no file name provided")
+	}
+	if strings.ContainsRune(name, 0) {
+		return "", errors.New("invalid file name")
+	}
+	if path.IsAbs(name) {
+		return "", errors.New("file name '" + name + "' must be relative")
+	}
+	for _, part := range strings.Split(path.Clean(name), "/") {
+		if part == ".." {
+			return "", errors.New("file name '" + name + "' is outside of its directory")
+		}
+		if strings.HasPrefix(part, ".") {
+			return "", errors.New("file name '" + name + "' has a hidden component")
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// checks that a file name has one of the given extensions
+func checkExtension(name string, exts []string) error {
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	for _, ok := range exts {
+		if strings.EqualFold(ext, ok) {
+			return nil
+		}
+	}
+	return errors.New("file name '" + name + "' must have extension " + strings.Join(exts, ", "))
+}
+
+func pageFileName(name string) (string, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return "", err
+	}
+	name = wikifier.PageName(name)
+	return name, checkExtension(name, pageExtensions)
+}
+
+func modelFileName(name string) (string, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return "", err
+	}
+	name = wikifier.ModelName(name)
+	return name, checkExtension(name, modelExtensions)
+}
+
+func imageFileName(name string) (string, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return "", err
+	}
+	return name, checkExtension(name, imageExtensions)
+}
@@ -0,0 +1,278 @@
+package wikifier
+
+import "fmt"
+
+// Block is the interface implemented by custom block types.
+//
+// Custom blocks are registered with RegisterBlock. Parse is called once after
+// the page source has been read, and HTML is called when the page is
+// generated. Both receive the page being rendered.
+//
+type Block interface {
+	Parse(page *Page)            // parse hook
+	HTML(page *Page, el Element) // html hook
+}
+
+// BlockFactory creates a custom block.
+//
+// The BlockContext provides access to the block's content and should be
+// stored by the Block for use in its parse and html hooks.
+type BlockFactory func(ctx *BlockContext) Block
+
+// Element is an HTML element being generated for a block.
+type Element interface {
+
+	// ID
+	ID() string
+
+	// tag/type
+	Tag() string
+	SetTag(tag string)
+	Type() string
+
+	// attributes
+	Attr(name string) string
+	SetAttr(name, value string)
+	BoolAttr(name string) bool
+	SetBoolAttr(name string, value bool)
+
+	// styles
+	Style(name string) string
+	SetStyle(name, value string)
+
+	// classes
+	AddClass(class ...string)
+	RemoveClass(class string) bool
+
+	// adding content
+	Add(i interface{})
+	AddText(s string)
+	AddHTML(h HTML)
+	CreateChild(tag, typ string) Element
+
+	// invisibility
+	Hide()
+}
+
+// BlockContext provides a custom block with access to its underlying
+// content, position, and the page it belongs to.
+type BlockContext struct {
+	b        *customBlock
+	m        *Map
+	htmlPage *Page   // set once the html phase has started
+	htmlEl   element // element being generated in the html phase
+}
+
+// customBlock adapts a Block to the internal block interface
+type customBlock struct {
+	impl Block
+	ctx  *BlockContext
+	*parserBlock
+}
+
+// RegisterBlock registers a custom block type by name.
+//
+// Once registered, the block can be used in any page like the built-in ones:
+//
+//	callout {
+//	    title: Note;
+//	    text: Blocks can be added by other packages.;
+//	}
+//
+// RegisterBlock should be called before any pages are parsed, typically from
+// an init function. It panics if the factory is nil or if a block by the same
+// name already exists.
+//
+func RegisterBlock(name string, factory BlockFactory) {
+	blocksMu.Lock()
+	defer blocksMu.Unlock()
+
+	if factory == nil {
+		panic("wikifier: RegisterBlock factory is nil")
+	}
+	if _, exist := blockInitializers[name]; exist {
+		panic("wikifier: RegisterBlock called twice for " + name + "{}")
+	}
+	if _, exist := blockAliases[name]; exist {
+		panic("wikifier: RegisterBlock called with alias " + name + "{}")
+	}
+
+	blockInitializers[name] = func(_ string, b *parserBlock) block {
+		cb := &customBlock{parserBlock: b}
+		cb.ctx = &BlockContext{b: cb}
+		cb.impl = factory(cb.ctx)
+		return cb
+	}
+}
+
+func (b *customBlock) parse(page *Page) {
+	b.impl.Parse(page)
+}
+
+func (b *customBlock) html(page *Page, el element) {
+	b.ctx.htmlPage, b.ctx.htmlEl = page, el
+
+	// if the content was used as a map, prepare its values. if Map is
+	// first called from the html hook, it does this itself
+	if b.ctx.m != nil {
+		b.ctx.m.html(page, el)
+	}
+
+	b.impl.HTML(page, publicElement{el, b})
+}
+
+// Type returns the block type, such as "callout".
+func (ctx *BlockContext) Type() string {
+	return ctx.b.blockType()
+}
+
+// Name returns the block name, if any.
+//
+// For example, the name of sec [Introduction] {} is "Introduction".
+func (ctx *BlockContext) Name() string {
+	return ctx.b.blockName()
+}
+
+// Classes returns the user-defined classes on the block.
+func (ctx *BlockContext) Classes() []string {
+	return ctx.b.classes
+}
+
+// Position returns the position at which the block was opened.
+func (ctx *BlockContext) Position() Position {
+	return ctx.b.openPosition()
+}
+
+// Text returns the text content of the block, excluding child blocks.
+func (ctx *BlockContext) Text() []string {
+	return ctx.b.textContent()
+}
+
+// Map returns the content of the block interpreted as a map.
+//
+// The content is parsed as key-value pairs on first call; any resulting
+// parser warnings are associated with the page. Values are prepared for
+// HTML when the html hook is called, or immediately if that has already
+// happened. Blocks which accept free-form text should use Text or
+// AddContent instead.
+//
+func (ctx *BlockContext) Map() *Map {
+	if ctx.m == nil {
+		ctx.m = newMapBlock(ctx.b.blockName(), ctx.b.parserBlock).(*Map)
+		ctx.m.parse(ctx.b._page)
+		if ctx.htmlPage != nil {
+			ctx.m.html(ctx.htmlPage, ctx.htmlEl)
+		}
+	}
+	return ctx.m
+}
+
+// KeyPosition returns the position of a key within the block when its content
+// is interpreted as a map. If the key is not present, it returns the position
+// of the block itself.
+func (ctx *BlockContext) KeyPosition(key string) Position {
+	return ctx.Map().getKeyPos(key)
+}
+
+// AddContent formats the text content of the block and adds it to an element,
+// along with the HTML of any child blocks.
+func (ctx *BlockContext) AddContent(page *Page, el Element) {
+	generated := ctx.mapElements()
+	for _, pc := range ctx.b.posContent() {
+		switch item := pc.content.(type) {
+		case block:
+
+			// child blocks were already parsed if the content was used as a
+			// map, and those which are map values were generated too
+			if ctx.m == nil {
+				item.parse(page)
+			}
+			if !generated[item.el()] {
+				item.html(page, item.el())
+			}
+			el.Add(item.el())
+		case string:
+			if item == "" {
+				continue
+			}
+			el.AddHTML(page.Fmt(item, pc.pos))
+		}
+	}
+}
+
+// mapElements returns the elements of child blocks which were generated as
+// map values
+func (ctx *BlockContext) mapElements() map[element]bool {
+	generated := make(map[element]bool)
+	if ctx.m == nil {
+		return generated
+	}
+	var add func(value interface{})
+	add = func(value interface{}) {
+		switch v := value.(type) {
+		case element:
+			generated[v] = true
+		case []interface{}:
+			for _, val := range v {
+				add(val)
+			}
+		}
+	}
+	for _, entry := range ctx.m.mapList {
+		add(entry.value)
+	}
+	return generated
+}
+
+// Warn produces a parser warning at the given position.
+func (ctx *BlockContext) Warn(pos Position, warning string) {
+	ctx.b.warn(pos, warning)
+}
+
+// Warnf is like Warn, except the warning is formatted with fmt.Sprintf.
+func (ctx *BlockContext) Warnf(pos Position, format string, a ...interface{}) {
+	ctx.b.warn(pos, fmt.Sprintf(format, a...))
+}
+
+// publicElement adapts the internal element interface to Element
+type publicElement struct {
+	el element
+	b  block // block for warnings
+}
+
+func (p publicElement) ID() string                          { return p.el.id() }
+func (p publicElement) Tag() string                         { return p.el.tag() }
+func (p publicElement) SetTag(tag string)                   { p.el.setTag(tag) }
+func (p publicElement) Type() string                        { return p.el.elementType() }
+func (p publicElement) Attr(name string) string             { return p.el.attr(name) }
+func (p publicElement) SetAttr(name, value string)          { p.el.setAttr(name, value) }
+func (p publicElement) BoolAttr(name string) bool           { return p.el.boolAttr(name) }
+func (p publicElement) SetBoolAttr(name string, value bool) { p.el.setBoolAttr(name, value) }
+func (p publicElement) Style(name string) string            { return p.el.style(name) }
+func (p publicElement) SetStyle(name, value string)         { p.el.setStyle(name, value) }
+func (p publicElement) AddClass(class ...string)            { p.el.addClass(class...) }
+func (p publicElement) RemoveClass(class string) bool       { return p.el.removeClass(class) }
+func (p publicElement) AddText(s string)                    { p.el.addText(s) }
+func (p publicElement) AddHTML(h HTML)                      { p.el.addHTML(h) }
+func (p publicElement) Hide()                               { p.el.hide() }
+
+func (p publicElement) CreateChild(tag, typ string) Element {
+	return publicElement{p.el.createChild(tag, typ), p.b}
+}
+
+// Add adds text, HTML, an Element, or a map value prepared for HTML.
+// other values produce a warning and are otherwise ignored
+func (p publicElement) Add(i interface{}) {
+	switch v := i.(type) {
+	case publicElement:
+		p.el.addChild(v.el)
+	case []interface{}:
+		for _, val := range v {
+			p.Add(val)
+		}
+	case string, HTML, element:
+		p.el.add(v)
+	default:
+		p.b.warn(p.b.openPosition(), fmt.Sprintf("Cannot add %T to element", i))
+	}
+}
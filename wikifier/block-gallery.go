@@ -101,6 +101,11 @@ func (g *galleryBlock) addImage(page *Page, img *imageBlock) {
 	// note: pregeneration will take care of the max scale
 	img.height = g.thumbHeight
 	img.width = 0
+
+	// with fit: cover, thumbnails are square
+	if strings.ToLower(img.getString("fit")) == "cover" {
+		img.width = g.thumbHeight
+	}
 	img.parsedDimensions = true
 	img.parse(page)
 
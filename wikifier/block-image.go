@@ -1,9 +1,11 @@
 package wikifier
 
 import (
+	"math"
 	"net/url"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,9 +20,25 @@ type imageBlock struct {
 	parsedDimensions                bool
 	fullSize                        bool
 	scales                          []int
+	calcWidth                       int
+	transform                       ImageTransform
+	candidates                      []imageCandidate
 	*Map
 }
 
+// an image in one width, for responsive srcset
+type imageCandidate struct {
+	path  string
+	width int
+}
+
+// mime types for image.formats
+var imageFormatTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
 type imagebox struct {
 	*imageBlock
 }
@@ -55,6 +73,21 @@ func (image *imageBlock) parse(page *Page) {
 		image.parsedDimensions = true
 	}
 
+	// crop and fit
+	image.transform.Fit = strings.ToLower(image.getString("fit"))
+	image.transform.Crop = image.getInts("crop", 4)
+	image.transform.Focus = image.getInts("focus", 2)
+	switch image.transform.Fit {
+	case "", "cover", "contain", "fill":
+	default:
+		image.warn(image.getKeyPos("fit"), "fit: must be one of 'cover', 'contain', or 'fill'")
+		image.transform.Fit = ""
+	}
+	if crop := image.transform.Crop; crop != nil && (crop[2] == 0 || crop[3] == 0) {
+		image.warn(image.getKeyPos("crop"), "crop: width and height must be positive")
+		image.transform.Crop = nil
+	}
+
 	// compatibility
 	if image.align == "" {
 		image.align = image.getString("float")
@@ -115,39 +148,50 @@ func (image *imageBlock) parse(page *Page) {
 		// - require read access to local image directory
 
 		// these must be provided by wiki
-		if page.Opt.Image.Sizer == nil || page.Opt.Image.Calc == nil {
+		img := page.Opt.Image
+		if (img.Sizer == nil && img.TransformSizer == nil) || (img.Calc == nil && img.TransformCalc == nil) {
 			image.warn(image.openPos, "image.sizer and image.calc required with image.size_method 'server'")
 			image.parseFailed = true
 			return
 		}
 
+		// fit only matters if both dimensions are given
+		if image.width == 0 || image.height == 0 {
+			image.transform.Fit = ""
+		}
+
 		// determine dimensions
 		var calcWidth, calcHeight int
-		calcWidth, calcHeight, image.fullSize = page.Opt.Image.Calc(
+		calcWidth, calcHeight, image.fullSize = page.Opt.Image.calc(
 			image.file,
 			image.width,
 			image.height,
+			image.transform,
 			page,
 		)
 
 		// path is as returned by the function that sizes the image
-		image.path = page.Opt.Image.Sizer(
+		image.path = page.Opt.Image.sizer(
 			image.file,
 			calcWidth,
 			calcHeight,
+			image.transform,
 			page,
 		)
 
 		// remember that the page uses this image in these dimensions
 		// consider: should we remember the retina scales? I guess it doesn't really DEPEND on them
-		page.Images[image.file] = append(page.Images[image.file], []int{calcWidth, calcHeight})
+		image.addDimensions(page, calcWidth, calcHeight)
 
-		// for each retina scale, determine whether the scaled dimensions would exceed full-size
+		// for each retina scale, determine whether the scaled dimensions would exceed full-size.
+		// gallery parses images twice, so start over
+		image.scales, image.candidates = nil, nil
 		for _, scale := range page.Opt.Image.Retina {
-			_, _, tooBig := page.Opt.Image.Calc(
+			_, _, tooBig := page.Opt.Image.calc(
 				image.file,
 				scale*image.width,
 				scale*image.height,
+				image.transform,
 				page,
 			)
 			if !tooBig {
@@ -155,6 +199,14 @@ func (image *imageBlock) parse(page *Page) {
 			}
 		}
 
+		// responsive mode: generate the image in each breakpoint width.
+		// vector images look the same in any size
+		image.calcWidth = calcWidth
+		isVector := strings.ToLower(filepath.Ext(image.file)) == ".svg"
+		if len(page.Opt.Image.Breakpoints) != 0 && !image.fullSize && calcWidth != 0 && !isVector {
+			image.addCandidates(page, calcWidth, calcHeight)
+		}
+
 	} else {
 		// note: this should never happen because the config parser validates it
 		image.warn(image.openPos, "image.size_method neither 'javascript' nor 'server'")
@@ -200,8 +252,18 @@ func (image *imageBlock) imageHTML(isBox bool, page *Page, el element) {
 	// skip is using full size image (since it can't be scaled any larger than that)
 	// skip if the image URL is absolute (not an image served by this wiki)
 	//
-	srcset := ""
-	if !image.fullSize && !isAbsolute && len(image.scales) != 0 {
+	// in responsive mode, widths are used instead of scales, and the
+	// browser chooses according to sizes
+	//
+	srcset, sizes := "", ""
+	if !isAbsolute && len(image.candidates) > 1 {
+		srcset = image.widthSrcset("")
+		sizes = page.Opt.Image.Sizes
+		if sizes == "" {
+			w := strconv.Itoa(image.calcWidth) + "px"
+			sizes = "(max-width: " + w + ") 100vw, " + w
+		}
+	} else if !image.fullSize && !isAbsolute && len(image.scales) != 0 {
 		srcset = ScaleString(image.path, image.scales)
 	}
 
@@ -216,7 +278,10 @@ func (image *imageBlock) imageHTML(isBox bool, page *Page, el element) {
 
 		// parse the link
 		// ok, displaySame bool, target, display, tooltip, linkType string
-		if ok, target, _, _, _ := page.parseLink(image.link, &FmtOpt{Pos: image.getKeyPos("link")}); ok {
+		pos := image.getKeyPos("link")
+		ok, target, linkType, _, _ := page.parseLink(image.link, &FmtOpt{Pos: pos})
+		page.addLink(Link{Type: linkType, Target: target, Ok: ok, Pos: pos})
+		if ok {
 			image.link = target
 			linkTarget = "_blank"
 		} else {
@@ -248,11 +313,7 @@ func (image *imageBlock) imageHTML(isBox bool, page *Page, el element) {
 		}
 
 		// create img with parent as either a or div
-		img := divOrA.createChild("img", "image-img")
-		img.setMeta("nonContainer", true)
-		img.setAttr("src", image.path)
-		img.setAttr("alt", image.alt)
-		img.setAttr("srcset", srcset)
+		image.createImg(page, divOrA, "image-img", srcset, sizes, isAbsolute)
 
 		return
 	}
@@ -271,11 +332,7 @@ func (image *imageBlock) imageHTML(isBox bool, page *Page, el element) {
 	}
 
 	// create img with parent as either a or div
-	img := divOrA.createChild("img", "imagebox-img")
-	img.setMeta("nonContainer", true)
-	img.setAttr("src", image.path)
-	img.setAttr("alt", image.alt)
-	img.setAttr("srcset", srcset)
+	img := image.createImg(page, divOrA, "imagebox-img", srcset, sizes, isAbsolute)
 
 	// insert javascript if using browser sizing
 	if image.useJS {
@@ -297,6 +354,157 @@ func (image *imageBlock) imageHTML(isBox bool, page *Page, el element) {
 	}
 }
 
+// creates the img element, inside <picture> if other formats are offered
+func (image *imageBlock) createImg(page *Page, parent element, typ, srcset, sizes string, isAbsolute bool) element {
+
+	// offer each format other than the image's own
+	var formats []string
+	if !isAbsolute {
+		formats = OfferedImageFormats(page.Opt, image.path)
+	}
+
+	if len(formats) != 0 {
+		picture := parent.createChild("picture", "image-picture")
+		for _, format := range formats {
+			source := picture.createChild("source", "")
+			source.setMeta("nonContainer", true)
+			source.setAttr("type", imageFormatTypes[format])
+			source.setAttr("sizes", sizes)
+
+			// same as the img srcset, with a different extension
+			switch {
+			case sizes != "":
+				source.setAttr("srcset", image.widthSrcset(format))
+			case srcset != "":
+				source.setAttr("srcset", replaceExt(image.path, format)+" 1x, "+
+					ScaleString(replaceExt(image.path, format), image.scales))
+			default:
+				source.setAttr("srcset", replaceExt(image.path, format))
+			}
+		}
+		parent = picture
+	}
+
+	img := parent.createChild("img", typ)
+	img.setMeta("nonContainer", true)
+	img.setAttr("src", image.path)
+	img.setAttr("alt", image.alt)
+	img.setAttr("srcset", srcset)
+	img.setAttr("sizes", sizes)
+	return img
+}
+
+// OfferedImageFormats returns the formats from image.formats which are
+// offered with <picture> for an image file, other than its own.
+//
+// Vector and animated images are not converted. WebP is only offered for
+// PNG images, since generated WebP images are lossless and would be larger
+// than the JPEG.
+//
+func OfferedImageFormats(opt *PageOpt, file string) []string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+	if ext == "jpg" {
+		ext = "jpeg"
+	}
+	if _, ok := imageFormatTypes[ext]; !ok {
+		return nil
+	}
+	var formats []string
+	for _, format := range opt.Image.Formats {
+		if format == ext || (format == "webp" && ext != "png") {
+			continue
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// determines the srcset candidates for responsive mode: the image in its
+// displayed width, in each retina scale, and in each breakpoint width
+// which is smaller than the largest of those
+func (image *imageBlock) addCandidates(page *Page, width, height int) {
+	maxWidth := width
+	widths := []int{width}
+	for _, scale := range image.scales {
+		widths = append(widths, scale*width)
+		if scale*width > maxWidth {
+			maxWidth = scale * width
+		}
+	}
+	for _, bp := range page.Opt.Image.Breakpoints {
+		if bp < maxWidth {
+			widths = append(widths, bp)
+		}
+	}
+	sort.Ints(widths)
+
+	for i, w := range widths {
+		if i != 0 && widths[i-1] == w {
+			continue
+		}
+		if w == width {
+			image.candidates = append(image.candidates, imageCandidate{image.path, width})
+			continue
+		}
+
+		// preserve the displayed aspect ratio
+		h := int(math.Max(1, math.Floor(float64(height)*float64(w)/float64(width)+0.5)))
+		calcWidth, calcHeight, tooBig := page.Opt.Image.calc(image.file, w, h, image.transform, page)
+		if tooBig {
+			continue
+		}
+		path := page.Opt.Image.sizer(image.file, calcWidth, calcHeight, image.transform, page)
+		image.addDimensions(page, calcWidth, calcHeight)
+		image.candidates = append(image.candidates, imageCandidate{path, calcWidth})
+	}
+}
+
+// remembers that the page uses this image in these dimensions, along with
+// any crop or fit, so that those versions may be generated
+func (image *imageBlock) addDimensions(page *Page, width, height int) {
+	page.Images[image.file] = append(page.Images[image.file], []int{width, height})
+	t := image.transform
+	if t.Fit == "" && t.Crop == nil {
+		return
+	}
+	for _, existing := range page.Transforms[image.file] {
+		if existing.Fit == t.Fit && intsEqual(existing.Crop, t.Crop) && intsEqual(existing.Focus, t.Focus) {
+			return
+		}
+	}
+	page.Transforms[image.file] = append(page.Transforms[image.file], t)
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// srcset with width descriptors, optionally in another format
+func (image *imageBlock) widthSrcset(format string) string {
+	srcset := make([]string, len(image.candidates))
+	for i, c := range image.candidates {
+		path := c.path
+		if format != "" {
+			path = replaceExt(path, format)
+		}
+		srcset[i] = path + " " + strconv.Itoa(c.width) + "w"
+	}
+	return strings.Join(srcset, ", ")
+}
+
+// replaces the extension of an image path
+func replaceExt(path, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + ext
+}
+
 // fetch a string key, producing a warning at the appropriate spot if needed
 func (image *imageBlock) getString(key string) string {
 	s, err := image.GetStr(key)
@@ -307,6 +515,27 @@ func (image *imageBlock) getString(key string) string {
 	return s
 }
 
+// fetch a comma-separated list of n non-negative integers, producing a
+// warning at the appropriate spot if needed
+func (image *imageBlock) getInts(key string, n int) []int {
+	s := image.getString(key)
+	if s == "" {
+		return nil
+	}
+	list, err := parseIntList(strings.Replace(s, "px", "", -1))
+	if err != nil || len(list) != n {
+		image.warn(image.getKeyPos(key), key+": must be "+strconv.Itoa(n)+" comma-separated integers")
+		return nil
+	}
+	for _, i := range list {
+		if i < 0 {
+			image.warn(image.getKeyPos(key), key+": must not be negative")
+			return nil
+		}
+	}
+	return list
+}
+
 // fetch a pixel size key, producing a warning at the appropriate spot if needed
 func (image *imageBlock) getPx(key string) int {
 	s, err := image.GetStr(key)
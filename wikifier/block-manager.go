@@ -1,5 +1,10 @@
 package wikifier
 
+import "sync"
+
+// blocksMu protects blockInitializers from concurrent registration
+var blocksMu sync.RWMutex
+
 var blockAliases = map[string]string{
 	"section":   "sec",
 	"paragraph": "p",
@@ -10,26 +15,28 @@ var blockAliases = map[string]string{
 }
 
 var blockInitializers = map[string]func(name string, b *parserBlock) block{
-	"main":      newMainBlock,
-	"clear":     newClearBlock,
-	"sec":       newSecBlock,
-	"p":         newPBlock,
-	"map":       newMapBlock,
-	"infobox":   newInfobox,
-	"infosec":   newInfosec,
-	"invisible": newInvisibleBlock,
-	"list":      newListBlock,
-	"numlist":   newNumlistBlock,
-	"code":      newCodeBlock,
-	"fmt":       newFmtBlock,
-	"html":      newHTMLBlock,
-	"history":   newHistoryBlock,
-	"style":     newStyleBlock,
-	"imagebox":  newImagebox,
-	"image":     newImageBlock,
-	"model":     newModelBlock,
-	"toc":       newTocBlock,
-	"gallery":   newGalleryBlock,
+	"main":       newMainBlock,
+	"clear":      newClearBlock,
+	"sec":        newSecBlock,
+	"p":          newPBlock,
+	"map":        newMapBlock,
+	"infobox":    newInfobox,
+	"infosec":    newInfosec,
+	"invisible":  newInvisibleBlock,
+	"list":       newListBlock,
+	"numlist":    newNumlistBlock,
+	"code":       newCodeBlock,
+	"fmt":        newFmtBlock,
+	"html":       newHTMLBlock,
+	"history":    newHistoryBlock,
+	"style":      newStyleBlock,
+	"imagebox":   newImagebox,
+	"image":      newImageBlock,
+	"model":      newModelBlock,
+	"toc":        newTocBlock,
+	"gallery":    newGalleryBlock,
+	"ref":        newRefBlock,
+	"references": newReferencesBlock,
 }
 
 func newBlock(blockType, blockName, headingID string, blockClasses []string, parentBlock block, parentCatch catch, pos Position, page *Page) block {
@@ -52,7 +59,10 @@ func newBlock(blockType, blockName, headingID string, blockClasses []string, par
 		genericCatch: &genericCatch{},
 		_page:        page,
 	}
-	if init, ok := blockInitializers[blockType]; ok {
+	blocksMu.RLock()
+	init, ok := blockInitializers[blockType]
+	blocksMu.RUnlock()
+	if ok {
 		b := init(blockName, underlying)
 
 		// multi
@@ -66,6 +76,8 @@ func newBlock(blockType, blockName, headingID string, blockClasses []string, par
 }
 
 func generateBlock(b block, page *Page) HTML {
+	page.reserveReferences(b)
 	b.html(page, b.el()) // FIXME: actual page
+	page.generateReferences()
 	return b.el().generate()
 }
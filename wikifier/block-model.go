@@ -33,6 +33,9 @@ func (mb *modelBlock) parse(page *Page) {
 	// assign the underlying Map of the model{} block to @m
 	model.Set("m", mb.Map)
 
+	// remember that the page uses this, even if it does not exist yet
+	page.Models[file] = ModelInfo{File: model.Name(), FileNE: model.NameNE()}
+
 	// check if it exists before anything else
 	if !model.Exists() {
 		mb.warn(mb.openPos, "Model $"+name+"{} does not exist")
@@ -51,7 +54,7 @@ func (mb *modelBlock) parse(page *Page) {
 	mb.modelName = name
 	mb.model = model
 
-	// remember the page uses this
+	// update with info from the model itself
 	page.Models[file] = model.modelInfo()
 }
 
@@ -0,0 +1,50 @@
+package wikifier
+
+import (
+	"strconv"
+	"strings"
+)
+
+type refBlock struct {
+	*parserBlock
+}
+
+func newRefBlock(name string, b *parserBlock) block {
+	return &refBlock{parserBlock: b}
+}
+
+func (b *refBlock) html(page *Page, el element) {
+	el.setMeta("noTags", true)
+
+	// format the citation text
+	var text HTML
+	if str := strings.TrimSpace(strings.Join(b.textContent(), "")); str != "" {
+		text = page.Fmt(str, b.openPos)
+	}
+	if len(b.blockContent()) != 0 {
+		b.warn(b.openPos, "Blocks within ref{} are ignored")
+	}
+
+	// ref [1] { text } defines the text for a numbered reference
+	if b.name != "" {
+		n, err := strconv.Atoi(b.name)
+		if err != nil || n < 1 {
+			b.warn(b.openPos, "Reference name must be a number")
+			return
+		}
+		ref := page.numberedReference(n, b.openPos)
+		if ref.Text != "" || ref.Fake {
+			b.warn(b.openPos, "Reference ["+b.name+"] already has text")
+			return
+		}
+		ref.Text = text
+		return
+	}
+
+	// ref { text } cites a new reference at this position
+	if text == "" {
+		b.warn(b.openPos, "Reference has no text")
+		return
+	}
+	el.addHTML(page.citeReference(page.newReference(text, b.openPos)))
+}
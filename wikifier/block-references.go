@@ -0,0 +1,71 @@
+package wikifier
+
+import "strconv"
+
+type referencesBlock struct {
+	list element
+	*parserBlock
+}
+
+func newReferencesBlock(name string, b *parserBlock) block {
+	return &referencesBlock{parserBlock: b}
+}
+
+func (b *referencesBlock) html(page *Page, el element) {
+	el.setTag("ul")
+
+	// references may be cited after this block, so the list is
+	// filled once the rest of the page has been generated
+	b.list = el
+	page.refBlocks = append(page.refBlocks, b)
+}
+
+func (b *referencesBlock) fill(page *Page) {
+	count := 0
+	for _, ref := range page.References() {
+
+		// fake references are not listed
+		if ref.Fake {
+			continue
+		}
+		count++
+
+		li := b.list.createChild("li", "ref-item")
+		li.setAttr("id", refAnchorID(&ref))
+		key := li.createChild("span", "ref-key")
+		num := "[" + strconv.Itoa(ref.Number) + "]"
+
+		// back-links to each citation
+		switch ref.Citations {
+		case 0:
+			key.addText(num)
+		case 1:
+			a := key.createChild("a", "ref-anchor")
+			a.setAttr("href", "#"+citeAnchorID(&ref, 1))
+			a.addText(num)
+		default:
+			key.addText(num)
+			sup := li.createChild("sup", "ref-backlinks")
+			for i := 1; i <= ref.Citations; i++ {
+				a := sup.createChild("a", "ref-anchor")
+				a.setAttr("href", "#"+citeAnchorID(&ref, i))
+				a.addText(backlinkLabel(i))
+			}
+		}
+
+		li.addHTML(ref.Text)
+	}
+
+	// nothing to show
+	if count == 0 {
+		b.list.hide()
+	}
+}
+
+// a, b, ..., z, then numbers
+func backlinkLabel(i int) string {
+	if i <= 26 {
+		return string(rune('a' + i - 1))
+	}
+	return strconv.Itoa(i)
+}
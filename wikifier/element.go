@@ -4,9 +4,15 @@ import (
 	htmlfmt "html"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-var identifiers = make(map[string]int)
+// element identifiers are unique across all pages, which may be
+// generated at the same time
+var (
+	identifiers   = make(map[string]int)
+	identifiersMu sync.Mutex
+)
 
 // HTML encapsulates a string to indicate that it is preformatted HTML.
 // It lets quiki's parsers know not to attempt to format it any further.
@@ -77,10 +83,13 @@ type genericElement struct {
 }
 
 func newElement(tag, typ string) element {
+	identifiersMu.Lock()
 	identifiers[typ]++
+	id := typ + "-" + strconv.Itoa(identifiers[typ])
+	identifiersMu.Unlock()
 	return &genericElement{
 		_tag:   tag,
-		_id:    typ + "-" + strconv.Itoa(identifiers[typ]),
+		_id:    id,
 		typ:    typ,
 		attrs:  make(map[string]interface{}),
 		styles: make(map[string]string),
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -14,6 +15,7 @@ var (
 	colorRegex    = regexp.MustCompile(`(?i)^#[\da-f]+$`)
 	wikiRegex     = regexp.MustCompile(`^(\w+):(.*)$`)
 	oldLinkRegex  = regexp.MustCompile(`^([\!\$\~]+?)(.+)([\!\$\~]+?)$`)
+	refRegex      = regexp.MustCompile(`^\d+$`)
 )
 
 var linkNormalizers = map[string]func(string) string{
@@ -423,6 +425,7 @@ func (p *Page) parseFormatType(formatType string, o *FmtOpt) HTML {
 	// [[link]]
 	if formatType[0] == '[' && formatType[len(formatType)-1] == ']' {
 		ok, target, linkType, tooltip, display := p.parseLink(formatType[1:len(formatType)-1], o)
+		p.addLink(Link{Type: linkType, Target: target, Ok: ok, Pos: o.Pos})
 		invalid := ""
 		if !ok {
 			invalid = " invalid"
@@ -439,12 +442,33 @@ func (p *Page) parseFormatType(formatType string, o *FmtOpt) HTML {
 		))
 	}
 
-	// TODO: fake references.
-	// if ($type eq 'ref') {
-	//     $page->{reference_number} ||= 1;
-	//     my $ref = $page->{reference_number}++;
-	//     return qq{<sup style="font-size: 75%"><a href="#wiki-ref-$ref" class="wiki-ref-anchor">[$ref]</a></sup>};
-	// }
+	// fake reference
+	// [ref]
+	if formatType == "ref" {
+		ref := p.newReference("", o.Pos)
+		ref.Fake = true
+		return p.citeReference(ref)
+	}
+
+	// inline reference
+	// [ref: citation text]
+	if strings.HasPrefix(formatType, "ref:") {
+		text := strings.TrimSpace(strings.TrimPrefix(formatType, "ref:"))
+		if text == "" {
+			p.warn(o.Pos, "Reference has no text")
+			return HTML("")
+		}
+		ref := p.newReference(p.FmtOpts(text, o.Pos, FmtOpt{NoWarnings: o.NoWarnings}), o.Pos)
+		return p.citeReference(ref)
+	}
+
+	// numbered reference
+	// [1]
+	if refRegex.MatchString(formatType) {
+		if n, err := strconv.Atoi(formatType); err == nil && n > 0 {
+			return p.citeReference(p.numberedReference(n, o.Pos))
+		}
+	}
 
 	// color name
 	if color, exists := colors[strings.ToLower(formatType)]; exists {
@@ -456,11 +480,6 @@ func (p *Page) parseFormatType(formatType string, o *FmtOpt) HTML {
 		return HTML(`<span style="color: "` + formatType + `";">`)
 	}
 
-	// TODO: real references.
-	// if ($type =~ m/^\d+$/) {
-	//     return qq{<sup style="font-size: 75%"><a href="#wiki-ref-$type" class="wiki-ref-anchor">[$type]</a></sup>};
-	// }
-
 	// inline html
 	// [html:x<sup>2</sup>]
 	if strings.HasPrefix(formatType, "html:") {
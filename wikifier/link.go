@@ -0,0 +1,101 @@
+package wikifier
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Link represents a link on a page.
+type Link struct {
+	Type   string   `json:"type"`   // internal, external, category, contact, or other
+	Target string   `json:"target"` // link target, as used in the href
+	Ok     bool     `json:"ok"`     // false if the link was found to be invalid
+	Pos    Position `json:"-"`      // position of the link
+}
+
+// Links returns the links found while generating the page, in the order they
+// appear. The page must be parsed and its HTML generated first.
+func (p *Page) Links() []Link {
+	links := make([]Link, len(p.links))
+	copy(links, p.links)
+	sort.SliceStable(links, func(i, j int) bool {
+		if links[i].Pos.Line != links[j].Pos.Line {
+			return links[i].Pos.Line < links[j].Pos.Line
+		}
+		return links[i].Pos.Column < links[j].Pos.Column
+	})
+	return links
+}
+
+// HeadingIDs returns the IDs of the section headings on the page, without
+// the qa- prefix. These are the valid #section anchors for links to the page.
+// The page must be parsed and its HTML generated first.
+func (p *Page) HeadingIDs() []string {
+	var ids []string
+	for id, n := range p.headingIDs {
+		ids = append(ids, id)
+		for i := 1; i < n; i++ {
+			ids = append(ids, id+"-"+strconv.Itoa(i))
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// record a link, ignoring duplicates at the same position
+func (p *Page) addLink(link Link) {
+	for _, l := range p.links {
+		if l.Pos == link.Pos && l.Target == link.Target {
+			return
+		}
+	}
+	p.links = append(p.links, link)
+}
+
+// LinkedPage returns the name of the page, without extension, and the section
+// which the target of a [[link]] refers to. The link may include display text
+// before a pipe. Relative links are resolved using the page prefix.
+//
+// If the link is not to a page on this wiki, or it is to a section of the
+// same page, ok is false.
+//
+func (p *Page) LinkedPage(link string) (pageName, section string, ok bool) {
+
+	// discard display text
+	target := link
+	if split := strings.SplitN(link, "|", 2); len(split) == 2 {
+		target = split[1]
+	}
+	target = strings.TrimSpace(target)
+
+	// not a page link
+	if target == "" ||
+		linkRegex.MatchString(target) ||
+		strings.HasPrefix(target, "mailto:") ||
+		mailRegex.MatchString(target) ||
+		wikiRegex.MatchString(target) ||
+		strings.HasPrefix(target, "~") {
+		return
+	}
+
+	// separate the section
+	if hashIdx := strings.IndexByte(target, '#'); hashIdx != -1 {
+		section = strings.TrimSpace(target[hashIdx+1:])
+		target = strings.TrimSpace(target[:hashIdx])
+	}
+
+	// section on same page
+	if target == "" {
+		return
+	}
+
+	// absolute or relative to the prefix
+	if target[0] == '/' {
+		target = target[1:]
+	} else if pfx := p.Prefix(); pfx != "" {
+		target = pfx + "/" + target
+	}
+
+	return PageNameNE(target), section, true
+}
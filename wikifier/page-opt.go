@@ -2,6 +2,7 @@ package wikifier
 
 import (
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -40,6 +41,7 @@ type PageOpt struct {
 type PageOptPage struct {
 	EnableTitle bool        // enable page title headings
 	EnableCache bool        // enable page caching
+	CacheMemory int         // max size of pages held in memory in MB
 	Code        PageOptCode // `code{}` block options
 }
 
@@ -72,14 +74,51 @@ type PageOptRoot struct {
 	Category string // category root path
 	Page     string // page root path
 	File     string // file index path
+	Search   string // search path
 }
 
 // PageOptImage describes wiki imaging options.
 type PageOptImage struct {
-	Retina     []int
-	SizeMethod string
-	Calc       func(file string, width, height int, page *Page) (w, h int, fullSize bool)
-	Sizer      func(file string, width, height int, page *Page) (path string)
+	Retina             []int
+	SizeMethod         string
+	Calc               func(file string, width, height int, page *Page) (w, h int, fullSize bool)
+	Sizer              func(file string, width, height int, page *Page) (path string)
+	Type               string   // format of generated images, or empty to preserve
+	Quality            int      // quality of generated JPEGs, 1-100
+	Breakpoints        []int    // widths for responsive srcset, or empty to disable
+	Sizes              string   // sizes attribute for responsive images, or empty for default
+	Formats            []string // additional formats offered with <picture>; only webp
+	AllowedSizes       [][]int  // dimensions which may always be generated on request
+	UploadMaxSize      int      // maximum upload size in megabytes
+	UploadMaxDimension int      // uploads wider or taller than this are downsized
+
+	// like Calc and Sizer, but also given the fit and crop of the image.
+	// if set, these are used instead of Calc and Sizer
+	TransformCalc  func(file string, width, height int, t ImageTransform, page *Page) (w, h int, fullSize bool)
+	TransformSizer func(file string, width, height int, t ImageTransform, page *Page) (path string)
+}
+
+// ImageTransform describes how an image is cropped and fit into its dimensions.
+type ImageTransform struct {
+	Fit   string `json:"fit,omitempty"`   // cover, contain, or fill (default); only with both dimensions
+	Crop  []int  `json:"crop,omitempty"`  // region of the full-size image to use: x, y, width, height
+	Focus []int  `json:"focus,omitempty"` // point in the full-size image to keep in view with cover: x, y
+}
+
+// calls TransformCalc, or Calc without the transform if it is not set
+func (opt PageOptImage) calc(file string, width, height int, t ImageTransform, page *Page) (int, int, bool) {
+	if opt.TransformCalc != nil {
+		return opt.TransformCalc(file, width, height, t, page)
+	}
+	return opt.Calc(file, width, height, page)
+}
+
+// calls TransformSizer, or Sizer without the transform if it is not set
+func (opt PageOptImage) sizer(file string, width, height int, t ImageTransform, page *Page) string {
+	if opt.TransformSizer != nil {
+		return opt.TransformSizer(file, width, height, t, page)
+	}
+	return opt.Sizer(file, width, height, page)
 }
 
 // PageOptCategory describes wiki category options.
@@ -144,6 +183,7 @@ var defaultPageOpt = PageOpt{
 	Page: PageOptPage{
 		EnableTitle: true,
 		EnableCache: false,
+		CacheMemory: 32,
 		Code: PageOptCode{
 			Style: "monokailight",
 		},
@@ -165,12 +205,15 @@ var defaultPageOpt = PageOpt{
 		Image:    "/images",
 		Category: "/topic",
 		File:     "",
+		Search:   "/search",
 	},
 	Image: PageOptImage{
-		Retina:     []int{2, 3},
-		SizeMethod: "javascript",
-		Calc:       nil,
-		Sizer:      nil,
+		Retina:        []int{2, 3},
+		SizeMethod:    "javascript",
+		Calc:          nil,
+		Sizer:         nil,
+		Quality:       100,
+		UploadMaxSize: 10,
 	},
 	Category: PageOptCategory{
 		PerPage: 5,
@@ -206,6 +249,7 @@ func InjectPageOpt(page *Page, opt *PageOpt) error {
 		"root.category":   &opt.Root.Category,   // http path to categories
 		"root.page":       &opt.Root.Page,       // http path to pages
 		"root.file":       &opt.Root.File,       // http path to file index
+		"root.search":     &opt.Root.Search,     // http path to search
 		"page.code.lang":  &opt.Page.Code.Lang,  // code{} language
 		"page.code.style": &opt.Page.Code.Style, // code{} style
 	}
@@ -232,6 +276,7 @@ func InjectPageOpt(page *Page, opt *PageOpt) error {
 	opt.Root.Category = filepath.ToSlash(opt.Root.Category)
 	opt.Root.Page = filepath.ToSlash(opt.Root.Page)
 	opt.Root.File = filepath.ToSlash(opt.Root.File)
+	opt.Root.Search = filepath.ToSlash(opt.Root.Search)
 
 	// easy bool options
 	pageOptBool := map[string]*bool{
@@ -254,30 +299,78 @@ func InjectPageOpt(page *Page, opt *PageOpt) error {
 	if retinaStr, err := page.GetStr("image.retina"); err != nil {
 		return errors.Wrap(err, "image.retina")
 	} else if retinaStr != "" {
-		var retina []int
-
-		// save time - this might just be one scale
-		if scale, err := strconv.Atoi(retinaStr); err == nil {
-			retina = []int{scale}
-
-		} else {
-			// more than 1 scale, separated by comma
-
-			scales := strings.Split(retinaStr, ",")
-			retina = make([]int, 0, len(scales))
-			for _, s := range scales {
-				intVal, err := strconv.Atoi(s)
-				if err != nil {
-					return errors.Wrap(err, "image.retina: must be list of integers")
-				}
-				retina = append(retina, intVal)
-			}
+		retina, err := parseIntList(retinaStr)
+		if err != nil {
+			return errors.Wrap(err, "image.retina: must be list of integers")
 		}
 		opt.Image.Retina = retina
 	}
 
+	// image.breakpoints - widths for responsive images
+	if bpStr, err := page.GetStr("image.breakpoints"); err != nil {
+		return errors.Wrap(err, "image.breakpoints")
+	} else if bpStr != "" {
+		breakpoints, err := parseIntList(bpStr)
+		if err != nil {
+			return errors.Wrap(err, "image.breakpoints: must be list of integers")
+		}
+		for _, bp := range breakpoints {
+			if bp < 1 {
+				return errors.New("image.breakpoints: must be list of positive integers")
+			}
+		}
+		sort.Ints(breakpoints)
+		opt.Image.Breakpoints = breakpoints
+	}
+
+	// image.sizes - sizes attribute for responsive images
+	str, err := page.GetStr("image.sizes")
+	if err != nil {
+		return errors.Wrap(err, "image.sizes")
+	}
+	opt.Image.Sizes = str
+
+	// image.formats - additional formats for <picture>. formats which every
+	// browser supports are not accepted, since the browser would always pick
+	// them over the original
+	str, err = page.GetStr("image.formats")
+	if err != nil {
+		return errors.Wrap(err, "image.formats")
+	}
+	if str != "" {
+		opt.Image.Formats = nil
+		for _, format := range strings.Split(str, ",") {
+			format = strings.TrimSpace(format)
+			if format != "webp" {
+				return errors.New("image.formats: must be 'webp'")
+			}
+			opt.Image.Formats = append(opt.Image.Formats, format)
+		}
+	}
+
+	// image.allowed_sizes - dimensions which may be generated on request
+	str, err = page.GetStr("image.allowed_sizes")
+	if err != nil {
+		return errors.Wrap(err, "image.allowed_sizes")
+	}
+	if str != "" {
+		opt.Image.AllowedSizes = nil
+		for _, size := range strings.Split(str, ",") {
+			dims := strings.Split(strings.TrimSpace(size), "x")
+			if len(dims) != 2 {
+				return errors.New("image.allowed_sizes: must be list of WIDTHxHEIGHT")
+			}
+			width, err1 := strconv.Atoi(dims[0])
+			height, err2 := strconv.Atoi(dims[1])
+			if err1 != nil || err2 != nil || width < 0 || height < 0 || width+height == 0 {
+				return errors.New("image.allowed_sizes: must be list of WIDTHxHEIGHT")
+			}
+			opt.Image.AllowedSizes = append(opt.Image.AllowedSizes, []int{width, height})
+		}
+	}
+
 	// image.size_method - how to determine imagebox dimensions
-	str, err := page.GetStr("image.size_method")
+	str, err = page.GetStr("image.size_method")
 	if err != nil {
 		return errors.Wrap(err, "image.size_method")
 	}
@@ -288,6 +381,31 @@ func InjectPageOpt(page *Page, opt *PageOpt) error {
 		opt.Image.SizeMethod = str
 	}
 
+	// image.type - format of generated images
+	str, err = page.GetStr("image.type")
+	if err != nil {
+		return errors.Wrap(err, "image.type")
+	}
+	if str != "" {
+		if str != "png" && str != "jpeg" && str != "webp" {
+			return errors.New("image.type: must be one of 'png', 'jpeg', or 'webp'")
+		}
+		opt.Image.Type = str
+	}
+
+	// image.quality - quality of generated jpegs
+	str, err = page.GetStr("image.quality")
+	if err != nil {
+		return errors.Wrap(err, "image.quality")
+	}
+	if str != "" {
+		intVal, err := strconv.Atoi(str)
+		if err != nil || intVal < 1 || intVal > 100 {
+			return errors.New("image.quality: must be integer between 1 and 100")
+		}
+		opt.Image.Quality = intVal
+	}
+
 	// cat.per_page - how many posts to show on each page of /topic
 	str, err = page.GetStr("cat.per_page")
 	if err != nil {
@@ -301,6 +419,26 @@ func InjectPageOpt(page *Page, opt *PageOpt) error {
 		opt.Category.PerPage = intVal
 	}
 
+	// easy int options
+	pageOptInt := map[string]*int{
+		"image.upload.max_size":      &opt.Image.UploadMaxSize,      // max upload size in MB
+		"image.upload.max_dimension": &opt.Image.UploadMaxDimension, // downsize larger uploads
+		"page.cache.memory":          &opt.Page.CacheMemory,         // max size of pages in memory in MB
+	}
+	for name, ptr := range pageOptInt {
+		str, err := page.GetStr(name)
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		if str != "" {
+			intVal, err := strconv.Atoi(str)
+			if err != nil || intVal < 0 {
+				return errors.New(name + ": must be non-negative integer")
+			}
+			*ptr = intVal
+		}
+	}
+
 	// navigation - ordered navigation items
 	obj, err := page.GetObj("navigation")
 	if err != nil {
@@ -329,3 +467,17 @@ func InjectPageOpt(page *Page, opt *PageOpt) error {
 
 	return nil
 }
+
+// parses a comma-separated list of integers
+func parseIntList(str string) ([]int, error) {
+	split := strings.Split(str, ",")
+	list := make([]int, 0, len(split))
+	for _, s := range split {
+		intVal, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, intVal)
+	}
+	return list, nil
+}
@@ -22,25 +22,31 @@ import (
 // It provides the most basic public interface to parsing with the wikifier engine.
 type Page struct {
 	Source       string   // source content
+	HasSource    bool     // True if Source should be used even when empty
 	FilePath     string   // Path to the .page file
 	VarsOnly     bool     // True if Parse() should only extract variables
 	Opt          *PageOpt // page options
 	styles       []styleEntry
 	staticStyles []string
 	codeStyles   bool
-	parser       *parser              // wikifier parser instance
-	main         block                // main block
-	Images       map[string][][]int   // references to images
-	Models       map[string]ModelInfo // references to models
-	PageLinks    map[string][]int     // references to other pages
+	parser       *parser                     // wikifier parser instance
+	main         block                       // main block
+	Images       map[string][][]int          // references to images
+	Transforms   map[string][]ImageTransform // crops and fits applied to images
+	Models       map[string]ModelInfo        // references to models
+	PageLinks    map[string][]int            // references to other pages
 	sectionN     int
 	name         string
 	headingIDs   map[string]int
-	Wiki         interface{} // only available during Parse() and HTML()
-	Markdown     bool        // true if this is a markdown source
-	model        bool        // true if this is a model being generated
-	Warnings     []Warning   // parser warnings
-	Error        *Warning    // parser error, as an encodable Warning
+	references   []*Reference       // references cited on the page
+	refNumbers   map[int]bool       // numbers of explicitly numbered references
+	refBlocks    []*referencesBlock // references{} blocks to be filled
+	links        []Link             // links found while generating
+	Wiki         interface{}        // only available during Parse() and HTML()
+	Markdown     bool               // true if this is a markdown source
+	model        bool               // true if this is a model being generated
+	Warnings     []Warning          // parser warnings
+	Error        *Warning           // parser error, as an encodable Warning
 	_html        HTML
 	_text        string
 	_preview     string
@@ -49,23 +55,24 @@ type Page struct {
 
 // PageInfo represents metadata associated with a page.
 type PageInfo struct {
-	Path        string     `json:"-"`                   // absolute filepath
-	File        string     `json:"file,omitempty"`      // name with extension, always with forward slashes
-	FileNE      string     `json:"file_ne,omitempty"`   // name without extension, always with forward slashes
-	Created     *time.Time `json:"created,omitempty"`   // creation time
-	Modified    *time.Time `json:"modified,omitempty"`  // modify time
-	Draft       bool       `json:"draft,omitempty"`     // true if page is marked as draft
-	Generated   bool       `json:"generated,omitempty"` // true if page was generated from another source
-	External    bool       `json:"external,omitempty"`  // true if page is outside the page directory
-	Redirect    string     `json:"redirect,omitempty"`  // path page is to redirect to
-	FmtTitle    HTML       `json:"fmt_title,omitempty"` // title with formatting tags
-	Title       string     `json:"title,omitempty"`     // title without tags
-	Author      string     `json:"author,omitempty"`    // author's name
-	Description string     `json:"desc,omitempty"`      // description
-	Keywords    []string   `json:"keywords,omitempty"`  // keywords
-	Preview     string     `json:"preview,omitempty"`   // first 25 words or 150 chars. empty w/ description
-	Warnings    []Warning  `json:"warnings,omitempty"`  // parser warnings
-	Error       *Warning   `json:"error,omitempty"`     // parser error, as an encodable warning
+	Path        string      `json:"-"`                    // absolute filepath
+	File        string      `json:"file,omitempty"`       // name with extension, always with forward slashes
+	FileNE      string      `json:"file_ne,omitempty"`    // name without extension, always with forward slashes
+	Created     *time.Time  `json:"created,omitempty"`    // creation time
+	Modified    *time.Time  `json:"modified,omitempty"`   // modify time
+	Draft       bool        `json:"draft,omitempty"`      // true if page is marked as draft
+	Generated   bool        `json:"generated,omitempty"`  // true if page was generated from another source
+	External    bool        `json:"external,omitempty"`   // true if page is outside the page directory
+	Redirect    string      `json:"redirect,omitempty"`   // path page is to redirect to
+	FmtTitle    HTML        `json:"fmt_title,omitempty"`  // title with formatting tags
+	Title       string      `json:"title,omitempty"`      // title without tags
+	Author      string      `json:"author,omitempty"`     // author's name
+	Description string      `json:"desc,omitempty"`       // description
+	Keywords    []string    `json:"keywords,omitempty"`   // keywords
+	Preview     string      `json:"preview,omitempty"`    // first 25 words or 150 chars. empty w/ description
+	References  []Reference `json:"references,omitempty"` // references cited on the page
+	Warnings    []Warning   `json:"warnings,omitempty"`   // parser warnings
+	Error       *Warning    `json:"error,omitempty"`      // parser error, as an encodable warning
 }
 
 // Warning represents a warning on a page.
@@ -82,6 +89,7 @@ func NewPage(filePath string) *Page {
 		Opt:           &myOpt,
 		variableScope: newVariableScope(),
 		Images:        make(map[string][][]int),
+		Transforms:    make(map[string][]ImageTransform),
 		Models:        make(map[string]ModelInfo),
 		PageLinks:     make(map[string][]int),
 		headingIDs:    make(map[string]int),
@@ -93,6 +101,7 @@ func NewPage(filePath string) *Page {
 func NewPageSource(source string) *Page {
 	p := NewPage("")
 	p.Source = source
+	p.HasSource = true
 	return p
 }
 
@@ -139,10 +148,10 @@ func (p *Page) _parse() error {
 
 	// create reader from file path or source code provided
 	var reader io.Reader
-	if p.Markdown && p.Source != "" {
+	if p.Markdown && p.hasSource() {
 		d := markdown.Run([]byte(p.Source))
 		reader = bytes.NewReader(d)
-	} else if p.Source != "" {
+	} else if p.hasSource() {
 		reader = strings.NewReader(p.Source)
 	} else if p.Markdown && p.FilePath != "" {
 		md, err := ioutil.ReadFile(p.FilePath)
@@ -205,10 +214,15 @@ func (p *Page) Text() string {
 	if p._text != "" {
 		return p._text
 	}
-	p._text = html.UnescapeString(strip.StripTags(string(p.HTML())))
+	p._text = p.HTML().Text()
 	return p._text
 }
 
+// Text returns the plain text of the HTML, with tags stripped.
+func (h HTML) Text() string {
+	return html.UnescapeString(strip.StripTags(string(h)))
+}
+
 // Preview returns a preview of the text on the page, up to 25 words or 150 characters.
 // If the page has a Description, that is used instead of generating a preview.
 func (p *Page) Preview() string {
@@ -259,13 +273,18 @@ func (p *Page) Preview() string {
 
 // Exists is true if the page exists.
 func (p *Page) Exists() bool {
-	if p.Source != "" {
+	if p.hasSource() {
 		return true
 	}
 	_, err := os.Stat(p.FilePath)
 	return err == nil
 }
 
+// true if the page is parsed from Source rather than FilePath
+func (p *Page) hasSource() bool {
+	return p.HasSource || p.Source != ""
+}
+
 // CacheExists is true if the page cache file exists.
 func (p *Page) CacheExists() bool {
 	_, err := os.Stat(p.CachePath())
@@ -591,6 +610,7 @@ func (p *Page) Info() PageInfo {
 		Description: desc,
 		Keywords:    p.Keywords(),
 		Preview:     prev,
+		References:  p.References(),
 		Warnings:    p.Warnings,
 		Error:       p.Error,
 	}
@@ -0,0 +1,142 @@
+package wikifier
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// matches numbered citations such as [1]
+var numberedRefRegex = regexp.MustCompile(`\[(\d+)\]`)
+
+// Reference represents a citation on a page.
+type Reference struct {
+	Number    int      `json:"number"`              // reference number, starting at 1
+	Text      HTML     `json:"text,omitempty"`      // formatted citation text
+	Citations int      `json:"citations,omitempty"` // number of times the reference is cited
+	Fake      bool     `json:"fake,omitempty"`      // true if created by [ref] with no text
+	Pos       Position `json:"-"`                   // position of the first citation
+}
+
+// References returns the references collected while generating the page,
+// ordered by number. The page must be parsed and its HTML generated first.
+func (p *Page) References() []Reference {
+	refs := make([]Reference, len(p.references))
+	for i, ref := range p.references {
+		refs[i] = *ref
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Number < refs[j].Number
+	})
+	return refs
+}
+
+// find a reference by number
+func (p *Page) getReference(n int) *Reference {
+	for _, ref := range p.references {
+		if ref.Number == n {
+			return ref
+		}
+	}
+	return nil
+}
+
+// reserveReferences finds the numbers used by [n] citations and ref [n] {}
+// blocks before any HTML is generated, so that references which are
+// numbered automatically do not take them
+func (p *Page) reserveReferences(b block) {
+	switch b.blockType() {
+
+	// text in these is not formatted
+	case "code", "html":
+		return
+
+	case "ref":
+		if n, err := strconv.Atoi(b.blockName()); err == nil && n > 0 {
+			p.reserveReference(n)
+		}
+	}
+
+	for _, text := range b.textContent() {
+		for _, match := range numberedRefRegex.FindAllStringSubmatch(text, -1) {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > 0 {
+				p.reserveReference(n)
+			}
+		}
+	}
+	for _, child := range b.blockContent() {
+		p.reserveReferences(child)
+	}
+}
+
+func (p *Page) reserveReference(n int) {
+	if p.refNumbers == nil {
+		p.refNumbers = make(map[int]bool)
+	}
+	p.refNumbers[n] = true
+}
+
+// create a new reference with the next available number which was not
+// reserved for an explicitly numbered reference
+func (p *Page) newReference(text HTML, pos Position) *Reference {
+	n := 1
+	for _, ref := range p.references {
+		if ref.Number >= n {
+			n = ref.Number + 1
+		}
+	}
+	for p.refNumbers[n] {
+		n++
+	}
+	ref := &Reference{Number: n, Text: text, Pos: pos}
+	p.references = append(p.references, ref)
+	return ref
+}
+
+// find or create a reference by number
+func (p *Page) numberedReference(n int, pos Position) *Reference {
+	if ref := p.getReference(n); ref != nil {
+		return ref
+	}
+	ref := &Reference{Number: n, Pos: pos}
+	p.references = append(p.references, ref)
+	return ref
+}
+
+// cite a reference, returning the HTML for the superscript anchor
+func (p *Page) citeReference(ref *Reference) HTML {
+	ref.Citations++
+	num := strconv.Itoa(ref.Number)
+
+	// fake references do not link anywhere
+	if ref.Fake {
+		return HTML(`<sup class="q-ref">[` + num + `]</sup>`)
+	}
+
+	return HTML(`<sup class="q-ref"><a class="q-ref-anchor" id="` + citeAnchorID(ref, ref.Citations) +
+		`" href="#` + refAnchorID(ref) + `">[` + num + `]</a></sup>`)
+}
+
+// called after the main block HTML is generated to finalize references
+func (p *Page) generateReferences() {
+
+	// warn about references which were cited but never defined
+	for _, ref := range p.references {
+		if !ref.Fake && ref.Text == "" {
+			p.warn(ref.Pos, "Reference ["+strconv.Itoa(ref.Number)+"] has no text")
+		}
+	}
+
+	// fill references{} blocks
+	for _, b := range p.refBlocks {
+		b.fill(p)
+	}
+}
+
+func refAnchorID(ref *Reference) string {
+	return "q-ref-" + strconv.Itoa(ref.Number)
+}
+
+func citeAnchorID(ref *Reference, n int) string {
+	return "q-cite-" + strconv.Itoa(ref.Number) + "-" + strconv.Itoa(n)
+}